@@ -3,6 +3,10 @@ module github.com/zbdba/db-recovery
 go 1.13
 
 require (
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
+	github.com/klauspost/compress v1.11.13
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/spf13/cobra v1.1.1
+	github.com/xitongsys/parquet-go v1.5.4
 )
@@ -17,8 +17,10 @@ package client
 import (
 	"flag"
 	`fmt`
+	"github.com/zbdba/db-recovery/recovery/output"
 	"github.com/zbdba/db-recovery/recovery/redo"
 	"github.com/zbdba/db-recovery/recovery/utils/logs"
+	"os"
 	"runtime"
 	"strings"
 
@@ -32,8 +34,56 @@ var (
 	DBName    string
 	TableName string
 
+	// the create table statement file.
+	StructFile string
+
+	// the index id of the dropped table's cluster index.
+	IndexId uint64
+
+	// filter the junk records by the plausibility score.
+	MinConfidence float64
+	RejectedFile  string
+
+	// filter the records by the transaction id.
+	MinTrxId  uint64
+	MaxTrxId  uint64
+	NewestTrx int
+
+	// the secondary index names to read the partial rows.
+	IndexName string
+
+	// the doublewrite buffer files and which page version is used.
+	DoublewriteFile string
+	PageVersion     string
+
 	OpType    string
 
+	// the output format and the sql dialect of the recovered rows.
+	OutputFormat string
+	Dialect      string
+
+	// batch the rows into the multi-row insert statements.
+	BatchRows         int
+	MaxStatementBytes int
+	TxnStatements     int
+	DisableChecks     bool
+
+	// write the rows to the files in the output directory.
+	OutputDir   string
+	Compress    string
+	MaxFileSize uint64
+
+	// the bytes of the Parquet row group.
+	RowGroupSize int64
+
+	// apply the statements to the target server.
+	ApplyDSN     string
+	DryRun       bool
+	ApplyRetries int
+
+	// write the rows to the SQLite database file.
+	SQLiteFile string
+
 	// redo info.
 	RedoFile  string
 
@@ -49,7 +99,7 @@ func NewRootCommand(use, short string) *cobra.Command {
 		SuggestFor: []string{use},
 	}
 	rc.PersistentFlags().StringVar(&OpType, "OpType", "", "The OpType can be RecoveryData," +
-		"RecoveryStruct,PrintData.")
+		"RecoveryStruct,RecoveryDeleteMarked,RecoveryOrphaned,RecoveryDiff,ListIndexIds,PrintData.")
 	rc.PersistentFlags().StringVar(&LogPath, "LogPath", "/tmp", "set the log file path.")
 	rc.PersistentFlags().StringVar(&LogLevel, "LogLevel", "DEBUG", "set the log level.")
 	rc.AddCommand(NewRecoveryCommand())
@@ -62,6 +112,34 @@ func NewRecoveryCommand() *cobra.Command {
 		Use:   "recovery <subcommand>",
 		Short: "recovery related commands",
 	}
+	jc.PersistentFlags().StringVar(&OutputFormat, "OutputFormat", output.FormatReplace, "The output " +
+		"format of the recovered rows, can be " + strings.Join(output.Formats, ",") + ".")
+	jc.PersistentFlags().StringVar(&Dialect, "Dialect", output.DialectMySQL, "The sql dialect " +
+		"of the statements, can be " + strings.Join(output.Dialects, ",") + ".")
+	jc.PersistentFlags().IntVar(&BatchRows, "BatchRows", 1, "The rows per insert statement, " +
+		"the rows of the same table are batched into the multi-row insert statement.")
+	jc.PersistentFlags().IntVar(&MaxStatementBytes, "MaxStatementBytes", 4194304, "The max bytes " +
+		"of the multi-row insert statement, it should not be larger than the max_allowed_packet.")
+	jc.PersistentFlags().IntVar(&TxnStatements, "TxnStatements", 0, "Write BEGIN and COMMIT " +
+		"around every N statements, 0 means no transaction.")
+	jc.PersistentFlags().BoolVar(&DisableChecks, "DisableChecks", false, "Write SET unique_checks=0, " +
+		"foreign_key_checks=0 before the statements, and restore them at the end.")
+	jc.PersistentFlags().StringVar(&OutputDir, "OutputDir", "", "Write the rows to the output " +
+		"directory instead of stdout, one file per database, table and source, with a manifest.json.")
+	jc.PersistentFlags().StringVar(&Compress, "Compress", output.CompressNone, "The compression " +
+		"of the files in the OutputDir, can be none,gzip,zstd.")
+	jc.PersistentFlags().Uint64Var(&MaxFileSize, "MaxFileSize", 0, "Rotate the file in the " +
+		"OutputDir when its size before compression reach it, 0 means no rotation.")
+	jc.PersistentFlags().Int64Var(&RowGroupSize, "RowGroupSize", output.DefaultRowGroupSize, "The bytes " +
+		"of the Parquet row group, the rows are buffered in memory until the row group is full.")
+	jc.PersistentFlags().StringVar(&ApplyDSN, "ApplyDSN", "", "Apply the statements to the " +
+		"target server instead of writing them, like user:password@tcp(127.0.0.1:3306)/.")
+	jc.PersistentFlags().BoolVar(&DryRun, "DryRun", false, "Only prepare the statements on " +
+		"the ApplyDSN server to check them, don't execute them.")
+	jc.PersistentFlags().IntVar(&ApplyRetries, "ApplyRetries", 3, "Retry the transaction " +
+		"rolled back by the deadlock or the lock wait timeout on the ApplyDSN server.")
+	jc.PersistentFlags().StringVar(&SQLiteFile, "SQLiteFile", "", "Write the rows to the SQLite " +
+		"database file instead of stdout, one table per recovered table with the provenance columns.")
 	jc.AddCommand(NewFromDataFileCommand())
 	jc.AddCommand(NewFromRedoFileCommand())
 	return jc
@@ -85,6 +163,38 @@ func NewFromDataFileCommand() *cobra.Command {
 	jc.Flags().StringVar(&TableName, "TableName", "", "The table name.")
 	_ = jc.MarkFlagRequired("TableName")
 
+	jc.Flags().StringVar(&StructFile, "TableStructFile", "", "The path of the file which store " +
+		"the create table statement, it provide the ENUM/SET labels and the DECIMAL precision.")
+
+	jc.Flags().Uint64Var(&IndexId, "IndexId", 0, "The cluster index id of the dropped table, " +
+		"scan all pages of the TableDataFile which have the index id, the TableDataFile can be " +
+		"the ibdata1 or the carved page files, identify like: 'ibdata1','pages1'")
+
+	jc.Flags().Float64Var(&MinConfidence, "MinConfidence", 0, "Only output the rows which " +
+		"plausibility score is not less than it, the score is between 0 and 1.")
+
+	jc.Flags().StringVar(&RejectedFile, "RejectedFile", "", "The path of the file which store " +
+		"the rows rejected by the MinConfidence, with the reasons.")
+
+	jc.Flags().Uint64Var(&MinTrxId, "MinTrxId", 0, "Only output the rows which DB_TRX_ID " +
+		"is not less than it.")
+
+	jc.Flags().Uint64Var(&MaxTrxId, "MaxTrxId", 0, "Only output the rows which DB_TRX_ID " +
+		"is not larger than it.")
+
+	jc.Flags().IntVar(&NewestTrx, "NewestTrx", 0, "Only output the rows touched by the " +
+		"newest N transactions.")
+
+	jc.Flags().StringVar(&IndexName, "IndexName", "", "Read the partial rows from the secondary " +
+		"index, which only have the index columns and the primary key columns, identify many " +
+		"indexes like: 'idx1','idx2' to merge the partial rows by the primary key.")
+
+	jc.Flags().StringVar(&DoublewriteFile, "DoublewriteFile", "", "The path of the doublewrite " +
+		"buffer files, the ibdata1 or the MySQL 8.0.20 dblwr files, identify like: 'ibdata1','#ib_16384_0.dblwr'")
+
+	jc.Flags().StringVar(&PageVersion, "PageVersion", ibdata.PageVersionNewest, "Which page version " +
+		"is used when the page have copies in the doublewrite buffer, can be newest,oldest,all.")
+
 	return jc
 }
 
@@ -101,17 +211,132 @@ func FromDataFile(cmd *cobra.Command, args []string) {
 	IsRecovery := false
 
 	p := ibdata.NewParseIB()
-	err := p.ParseDictPage(SysDataFile)
+
+	w, err := NewOutputWriter()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer CloseOutputWriter(w)
+	p.Writer = w
+
+	p.MinConfidence = MinConfidence
+	if RejectedFile != "" {
+		f, err := os.OpenFile(RejectedFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		defer f.Close()
+
+		// The rejected rows are written in the same format, the LOAD DATA,
+		// Parquet and COPY files are written as the sql statements, which
+		// keep the reasons and can have the rows of many tables.
+		opts := OutputOptions()
+		if opts.Format == output.FormatCopy {
+			opts.Dialect = output.DialectPostgres
+		}
+		if opts.Format == output.FormatLoadData || opts.Format == output.FormatParquet ||
+			opts.Format == output.FormatCopy {
+			opts.Format = output.FormatReplace
+		}
+		rw, err := output.NewRowWriter(f, opts)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		defer rw.Close()
+		p.RejectedWriter = rw
+	}
+
+	// The dropped table's dict records may have been purged.
+	if IndexId != 0 {
+		p.RecoverDroppedDict = true
+	}
+
+	err = p.ParseDictPage(SysDataFile)
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
 
+	// The MySQL 8.0 data file have the table definition in the SDI,
+	// it have the instant columns which the data dictionary don't have.
+	for _, path := range strings.Split(TableFile, ",") {
+		err = p.LoadSDITables(path)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	if StructFile != "" {
+		err = p.GetTableFieldsFromStruct(StructFile, DBName)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
 	if OpType == "RecoveryData" {
 		IsRecovery = true
 	}
 
-	RecoveryErr := p.ParseTableData(TableFile, DBName, TableName, IsRecovery)
+	if DoublewriteFile != "" {
+		if PageVersion != ibdata.PageVersionNewest && PageVersion != ibdata.PageVersionOldest &&
+			PageVersion != ibdata.PageVersionAll {
+			fmt.Println("the PageVersion can be newest,oldest,all.")
+			return
+		}
+		p.PageVersion = PageVersion
+		err = p.LoadDoublewrite(strings.Split(DoublewriteFile, ","))
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	p.MinTrxId = MinTrxId
+	p.MaxTrxId = MaxTrxId
+	if NewestTrx > 0 {
+		ids, err := p.NewestTrxIds(TableFile, DBName, TableName, NewestTrx)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if len(ids) != 0 {
+			p.MinTrxId = ids[len(ids)-1]
+		}
+	}
+
+	// The deleted records which have not been purged are still on the
+	// normal record list, read them with the deleted flag.
+	if OpType == "RecoveryDeleteMarked" {
+		p.DeleteMarkedOnly = true
+	}
+
+	// The records which can't be reached from the record lists,
+	// scan the page heap to find them.
+	if OpType == "RecoveryOrphaned" {
+		p.ScanHeap = true
+	}
+
+	var RecoveryErr error
+	if OpType == "ListIndexIds" {
+		// Find the stale index ids, such as the index before TRUNCATE TABLE.
+		_, RecoveryErr = p.ListIndexIds(strings.Split(TableFile, ","), DBName, TableName)
+	} else if OpType == "RecoveryDiff" {
+		// Only output the deleted rows which are not in the live rows.
+		RecoveryErr = p.DiffTableData(TableFile, DBName, TableName)
+	} else if IndexName != "" {
+		// The cluster index is overwritten, read the secondary indexes.
+		RecoveryErr = p.ParseSecondaryIndexData(TableFile, DBName, TableName,
+			strings.Split(IndexName, ","), IsRecovery)
+	} else if IndexId != 0 {
+		RecoveryErr = p.ParseIndexData(strings.Split(TableFile, ","), IndexId, DBName, TableName, IsRecovery)
+	} else {
+		RecoveryErr = p.ParseTableData(TableFile, DBName, TableName, IsRecovery)
+	}
 	if RecoveryErr != nil {
 		fmt.Println(RecoveryErr)
 	}
@@ -137,6 +362,9 @@ func NewFromRedoFileCommand() *cobra.Command {
 
 	jc.Flags().StringVar(&TableName, "TableName", "", "identify the table name which you want to recover.")
 
+	jc.Flags().StringVar(&StructFile, "TableStructFile", "", "The path of the file which store " +
+		"the create table statement, it provide the ENUM/SET labels and the DECIMAL precision.")
+
 	return jc
 }
 
@@ -151,13 +379,21 @@ func FromRedoFile(cmd *cobra.Command, args []string) {
 	}
 
 
-	p, err := redo.NewParseRedo(SysDataFile, TableName, DBName)
+	p, err := redo.NewParseRedo(SysDataFile, TableName, DBName, StructFile)
 
 	if err != nil {
 		logs.Error("parse redo failed, the error is ", err.Error())
 		return
 	}
 
+	w, err := NewOutputWriter()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer CloseOutputWriter(w)
+	p.Writer = w
+
 	LogFileList := strings.Split(RedoFile, ",")
 	ParseErr := p.Parse(LogFileList)
 
@@ -169,6 +405,43 @@ func FromRedoFile(cmd *cobra.Command, args []string) {
 	logs.FlushLogs()
 }
 
+// The options of the row writer from the flags.
+func OutputOptions() output.Options {
+	return output.Options{
+		Format:            OutputFormat,
+		Dialect:           Dialect,
+		BatchRows:         BatchRows,
+		MaxStatementBytes: MaxStatementBytes,
+		TxnStatements:     TxnStatements,
+		DisableChecks:     DisableChecks,
+		RowGroupSize:      RowGroupSize}
+}
+
+// Create the row writer, the rows are written to stdout or the OutputDir,
+// or applied to the ApplyDSN server, or written to the SQLite database.
+func NewOutputWriter() (ibdata.RowWriter, error) {
+	if ApplyDSN != "" {
+		return output.OpenApplyWriter(ApplyDSN, DryRun, ApplyRetries, OutputOptions())
+	}
+	if SQLiteFile != "" {
+		return output.OpenSQLiteWriter(SQLiteFile, OutputOptions())
+	}
+	if OutputFormat == output.FormatLoadData && OutputDir == "" {
+		return nil, fmt.Errorf("the %s format should identify the OutputDir", output.FormatLoadData)
+	}
+	if OutputDir != "" {
+		return output.NewDirWriter(OutputDir, Compress, MaxFileSize, OutputOptions())
+	}
+	return output.NewRowWriter(os.Stdout, OutputOptions())
+}
+
+// Flush the rows, and write the manifest of the OutputDir.
+func CloseOutputWriter(w ibdata.RowWriter) {
+	if err := w.Close(); err != nil {
+		fmt.Println("close the output failed, the error is ", err.Error())
+	}
+}
+
 func NewVersionCommand() *cobra.Command {
 	vc := &cobra.Command{
 		Use:   "version",
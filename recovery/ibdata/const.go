@@ -32,3 +32,216 @@ const (
 	SysIndexesIdx uint64 = 3
 	SysFieldsIdx  uint64 = 4
 )
+
+// The precise type flag of the virtual generated column.
+// Reference mysql-5.7.19/storage/innobase/include/data0type.h
+// #define DATA_VIRTUAL 8192
+const DataVirtual uint64 = 8192
+
+// The record header info bits.
+// Reference mysql-8.0.29/storage/innobase/include/rem0rec.h
+const (
+	RecInfoBitsMask    uint64 = 0xF0
+	RecInfoMinRecFlag  uint64 = 0x10
+	RecInfoDeletedFlag uint64 = 0x20
+
+	// The record have the row version, added by 8.0.29.
+	RecInfoVersionFlag uint64 = 0x40
+
+	// The record have the field num, added by 8.0.12.
+	RecInfoInstantFlag uint64 = 0x80
+)
+
+// The state of the record read from the page.
+const (
+	// The record is on the normal record list and not delete marked.
+	RecordLive string = "live"
+
+	// The record is deleted, but it have not been purged,
+	// it is still on the normal record list with the deleted flag.
+	RecordDeleteMarked string = "delete-marked"
+
+	// The record have been purged and moved to the PAGE_FREE list.
+	RecordPurgedFree string = "purged-free"
+
+	// The record can't be reached from the record lists,
+	// it is found by scanning the page heap.
+	RecordOrphaned string = "orphaned"
+
+	// The record is on the page which have been freed to the extent,
+	// the page keep the old records until it is reused.
+	RecordFreePage string = "free-page"
+)
+
+// The class of the deleted record compared with the live records by the primary key.
+const (
+	// The primary key have not found in the live records.
+	DiffDeleted string = "truly deleted"
+
+	// The primary key is found in the live records or the newer deleted
+	// record, but the values are different.
+	DiffOlderVersion string = "older version of a row"
+
+	// The primary key and the values are the same as the live record,
+	// such as the record moved by the page split.
+	DiffDuplicate string = "duplicate of a live row"
+)
+
+// The trx sys page and the doublewrite buffer info in it.
+// Reference mysql-5.7.19/storage/innobase/include/trx0sys.h
+const (
+	// #define TRX_SYS_PAGE_NO FSP_TRX_SYS_PAGE_NO
+	TrxSysPageNo uint64 = 5
+
+	// #define TRX_SYS_DOUBLEWRITE (UNIV_PAGE_SIZE - 200)
+	TrxSysDoublewrite uint64 = 16384 - 200
+
+	// The offsets in the doublewrite info, after the FSEG header.
+	TrxSysDoublewriteMagic  uint64 = 10
+	TrxSysDoublewriteBlock1 uint64 = 14
+	TrxSysDoublewriteBlock2 uint64 = 18
+
+	// #define TRX_SYS_DOUBLEWRITE_MAGIC_N 536853855
+	TrxSysDoublewriteMagicN uint64 = 536853855
+
+	// #define TRX_SYS_DOUBLEWRITE_BLOCK_SIZE FSP_EXTENT_SIZE
+	TrxSysDoublewriteBlockSize uint64 = 64
+)
+
+// Which page version is used when the page have copies in the doublewrite buffer.
+const (
+	// The valid version with the largest LSN.
+	PageVersionNewest string = "newest"
+
+	// The valid version with the smallest LSN.
+	PageVersionOldest string = "oldest"
+
+	// All versions of the page.
+	PageVersionAll string = "all"
+)
+
+// The file space header and the extent descriptor.
+// Reference mysql-5.7.19/storage/innobase/include/fsp0fsp.h
+const (
+	// #define FIL_PAGE_TYPE_FSP_HDR 8, FIL_PAGE_TYPE_XDES 9
+	FilPageTypeFspHdr uint64 = 8
+	FilPageTypeXdes   uint64 = 9
+
+	// #define FSP_HEADER_OFFSET FIL_PAGE_DATA
+	FspHeaderOffset uint64 = 38
+
+	// #define FSP_FREE_LIMIT 12
+	FspFreeLimit uint64 = 12
+
+	// #define XDES_ARR_OFFSET (FSP_HEADER_OFFSET + FSP_HEADER_SIZE)
+	XdesArrOffset uint64 = 38 + 112
+
+	// #define XDES_SIZE (XDES_BITMAP + UT_BITS_IN_BYTES(FSP_EXTENT_SIZE * XDES_BITS_PER_PAGE))
+	XdesSize   uint64 = 40
+	XdesState  uint64 = 20
+	XdesBitmap uint64 = 24
+
+	// #define XDES_FREE 1, the extent is in the free list of the space.
+	XdesFree uint64 = 1
+
+	// #define FSP_EXTENT_SIZE 64, the pages of an extent when the page size is 16k.
+	FspExtentSize uint64 = 64
+)
+
+// The serialized dictionary information (SDI) in the MySQL 8.0 tablespace,
+// it is the table definition in JSON, compressed by zlib.
+// Reference mysql-8.0.29/storage/innobase/include/fil0fil.h
+// Reference mysql-8.0.29/storage/innobase/api/api0misc.cc
+const (
+	// #define FIL_PAGE_SDI 17853, the SDI index page.
+	FilPageSdi uint64 = 17853
+
+	// #define FIL_PAGE_SDI_BLOB 18, the SDI stored off page.
+	FilPageSdiBlob uint64 = 18
+
+	// #define PAGE_NEW_INFIMUM (PAGE_DATA + REC_N_NEW_EXTRA_BYTES)
+	// #define PAGE_NEW_SUPREMUM (PAGE_DATA + 2 * REC_N_NEW_EXTRA_BYTES + 8)
+	PageNewInfimum  uint64 = 99
+	PageNewSupremum uint64 = 112
+
+	// The SDI record fields are type(4), id(8), DB_TRX_ID(6), DB_ROLL_PTR(7),
+	// uncompressed_len(4), compressed_len(4) and data.
+	SdiRecOffType      uint64 = 0
+	SdiRecOffId        uint64 = 4
+	SdiRecOffUncompLen uint64 = 25
+	SdiRecOffCompLen   uint64 = 29
+	SdiRecOffData      uint64 = 33
+
+	// The blob page header, #define BTR_BLOB_HDR_PART_LEN 0,
+	// #define BTR_BLOB_HDR_NEXT_PAGE_NO 4, #define BTR_BLOB_HDR_SIZE 8.
+	BtrBlobHdrPartLen  uint64 = 38
+	BtrBlobHdrNextPage uint64 = 38 + 4
+	BtrBlobHdrData     uint64 = 38 + 8
+
+	// #define FIL_NULL ULINT32_UNDEFINED
+	FilNull uint64 = 0xFFFFFFFF
+)
+
+// The column type of the MySQL 8.0 data dictionary.
+// Reference mysql-8.0.29/sql/dd/types/column.h enum_column_types
+const (
+	DDTypeDecimal uint64 = iota + 1
+	DDTypeTiny
+	DDTypeShort
+	DDTypeLong
+	DDTypeFloat
+	DDTypeDouble
+	DDTypeNull
+	DDTypeTimestamp
+	DDTypeLonglong
+	DDTypeInt24
+	DDTypeDate
+	DDTypeTime
+	DDTypeDatetime
+	DDTypeYear
+	DDTypeNewdate
+	DDTypeVarchar
+	DDTypeBit
+	DDTypeTimestamp2
+	DDTypeDatetime2
+	DDTypeTime2
+	DDTypeNewdecimal
+	DDTypeEnum
+	DDTypeSet
+	DDTypeTinyBlob
+	DDTypeMediumBlob
+	DDTypeLongBlob
+	DDTypeBlob
+	DDTypeVarString
+	DDTypeString
+	DDTypeGeometry
+	DDTypeJson
+)
+
+// The index type of the MySQL 8.0 data dictionary.
+// Reference mysql-8.0.29/sql/dd/types/index.h enum_index_type
+const (
+	DDIndexPrimary uint64 = iota + 1
+	DDIndexUnique
+	DDIndexMultiple
+	DDIndexFulltext
+	DDIndexSpatial
+)
+
+// The index type of SYS_INDEXES.
+// Reference mysql-5.7.19/storage/innobase/include/dict0mem.h
+const (
+	// #define DICT_CLUSTERED 1, #define DICT_UNIQUE 2
+	DictClustered uint64 = 1
+	DictUnique    uint64 = 2
+
+	// #define DICT_FTS 32, #define DICT_SPATIAL 64
+	DictFts     uint64 = 32
+	DictSpatial uint64 = 64
+)
+
+// The collation id of the binary charset.
+const BinaryCollationId uint64 = 63
+
+// The collation id of latin1_swedish_ci, InnoDB store it as DATA_CHAR and DATA_VARCHAR.
+const Latin1CollationId uint64 = 8
@@ -0,0 +1,217 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Read the page copies from the doublewrite buffer, they hold the recently
+// flushed pages, which may be the version before delete, or the intact copy
+// of the page which is torn in the data file. The file can be the ibdata1,
+// the doublewrite buffer is the two blocks recorded in the trx sys page,
+// or the MySQL 8.0.20 #ib_*.dblwr file, which only store the page copies.
+func (P *ParseIB) LoadDoublewrite(paths []string) error {
+	for _, path := range paths {
+		pages, err := P.ParseDoublewriteFile(path)
+		if err != nil {
+			return err
+		}
+		logs.Info("read ", len(pages), " pages from the doublewrite buffer ", path)
+		P.DoublewritePages = append(P.DoublewritePages, pages...)
+	}
+	return nil
+}
+
+// Parse the page copies in the doublewrite file.
+func (P *ParseIB) ParseDoublewriteFile(path string) ([]Page, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		logs.Error("Error while opening file, the err is ", err)
+		return nil, err
+	}
+	defer file.Close()
+
+	// The ibdata1 have the doublewrite info in the trx sys page.
+	var PageNos []uint64
+	TrxSys := make([]byte, DefaultPageSize)
+	if _, err := file.ReadAt(TrxSys, int64(TrxSysPageNo)*int64(DefaultPageSize)); err == nil {
+		dw := TrxSys[TrxSysDoublewrite:]
+		if utils.MatchReadFrom4(dw[TrxSysDoublewriteMagic:]) == TrxSysDoublewriteMagicN {
+			block1 := utils.MatchReadFrom4(dw[TrxSysDoublewriteBlock1:])
+			block2 := utils.MatchReadFrom4(dw[TrxSysDoublewriteBlock2:])
+			logs.Debug("the doublewrite block1 is ", block1, " block2 is ", block2)
+
+			for i := uint64(0); i < TrxSysDoublewriteBlockSize; i++ {
+				PageNos = append(PageNos, block1+i)
+			}
+			for i := uint64(0); i < TrxSysDoublewriteBlockSize; i++ {
+				PageNos = append(PageNos, block2+i)
+			}
+		}
+	}
+
+	var pages []Page
+	for i := uint64(0); ; i++ {
+		// The .dblwr file only store the page copies.
+		PageNo := i
+		if len(PageNos) != 0 {
+			if i >= uint64(len(PageNos)) {
+				break
+			}
+			PageNo = PageNos[i]
+		}
+
+		d := make([]byte, DefaultPageSize)
+		n, err := file.ReadAt(d, int64(PageNo)*int64(DefaultPageSize))
+		if n < DefaultPageSize {
+			if err != nil && err != io.EOF {
+				logs.Error("read doublewrite page failed, the error is ", err)
+				return nil, err
+			}
+			break
+		}
+
+		// Skip the empty slot.
+		if !utils.PageIsValid(d) {
+			continue
+		}
+
+		p, err := P.ParseFilHeader(d)
+		if err != nil {
+			return nil, err
+		}
+		p.OriginalData = d
+		pages = append(pages, p)
+	}
+	return pages, nil
+}
+
+// Read the table data file pages, and merge the page copies of the same
+// tablespace from the doublewrite buffer, choose the page version by the
+// PageVersion: the newest valid version, the oldest valid version, or all.
+func (P *ParseIB) ReadTablePages(path string) ([]Page, error) {
+	pages, ParseFileErr := P.ParseFile(path)
+	if ParseFileErr != nil {
+		return nil, ParseFileErr
+	}
+	if len(P.DoublewritePages) == 0 || len(pages) == 0 {
+		return pages, nil
+	}
+
+	// The space id of the file is stored in every page.
+	SpaceId := pages[0].fh.FIL_PAGE_SPACE_ID
+
+	// The versions of the page are grouped by the page key, all versions
+	// are kept when the KeepPageVersions is set.
+	var keys []PageKey
+	versions := make(map[PageKey][]Page)
+	AddVersion := func(p Page) {
+		key := P.MakePageKey(p)
+		if _, ok := versions[key]; !ok {
+			keys = append(keys, key)
+		}
+		versions[key] = append(versions[key], p)
+	}
+	for _, p := range pages {
+		AddVersion(p)
+	}
+
+	copies := 0
+	for _, p := range P.DoublewritePages {
+		if p.fh.FIL_PAGE_SPACE_ID != SpaceId {
+			continue
+		}
+		AddVersion(p)
+		copies++
+	}
+	logs.Info("found ", copies, " doublewrite page copies of space ", SpaceId)
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].SpaceId != keys[j].SpaceId {
+			return keys[i].SpaceId < keys[j].SpaceId
+		}
+		if keys[i].PageNo != keys[j].PageNo {
+			return keys[i].PageNo < keys[j].PageNo
+		}
+		return keys[i].LSN < keys[j].LSN
+	})
+
+	var AllPages []Page
+	for _, key := range keys {
+		AllPages = append(AllPages, P.ChoosePageVersions(versions[key])...)
+	}
+	return AllPages, nil
+}
+
+// Choose the page versions by the PageVersion, the first version is the
+// page in the data file, it is kept when no version is valid.
+func (P *ParseIB) ChoosePageVersions(versions []Page) []Page {
+	if len(versions) == 1 {
+		return versions
+	}
+
+	var valid []Page
+	for _, p := range versions {
+		if utils.PageIsValid(p.OriginalData) {
+			valid = append(valid, p)
+		}
+	}
+	if len(valid) == 0 {
+		logs.Warn("page ", versions[0].fh.FIL_PAGE_OFFSET, " have no valid version")
+		return versions[:1]
+	}
+
+	switch P.PageVersion {
+	case PageVersionAll:
+		// Skip the same version.
+		var pages []Page
+		seen := make(map[uint64]bool)
+		for _, p := range valid {
+			if !seen[p.fh.FIL_PAGE_LSN] {
+				seen[p.fh.FIL_PAGE_LSN] = true
+				pages = append(pages, p)
+			}
+		}
+		return pages
+
+	case PageVersionOldest:
+		oldest := valid[0]
+		for _, p := range valid {
+			if p.fh.FIL_PAGE_LSN < oldest.fh.FIL_PAGE_LSN {
+				oldest = p
+			}
+		}
+		return []Page{oldest}
+
+	case PageVersionNewest, "":
+		newest := valid[0]
+		for _, p := range valid {
+			if p.fh.FIL_PAGE_LSN > newest.fh.FIL_PAGE_LSN {
+				newest = p
+			}
+		}
+		return []Page{newest}
+	}
+
+	logs.Error(fmt.Sprintf("unknown page version %s", P.PageVersion))
+	return versions[:1]
+}
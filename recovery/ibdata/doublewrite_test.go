@@ -0,0 +1,102 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// Make the page 4 of the space 3 with the LSN and the crc32 checksum,
+// the torn page have the older LSN at the page end.
+func MakeTestVersionPage(lsn uint64, torn bool) []byte {
+	d := MakeTestPage(FilPageIndex, nil)
+	binary.BigEndian.PutUint32(d[34:], 3)
+	binary.BigEndian.PutUint64(d[16:], lsn)
+	binary.BigEndian.PutUint32(d[len(d)-4:], uint32(lsn))
+	if torn {
+		binary.BigEndian.PutUint32(d[len(d)-4:], uint32(lsn-1))
+	}
+	binary.BigEndian.PutUint32(d, utils.PageChecksumCrc32(d))
+	return d
+}
+
+// The .dblwr file only store the page copies, the empty slot and the torn copy are skipped.
+func TestParseDoublewriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doublewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var d []byte
+	d = append(d, MakeTestVersionPage(100, false)...)
+	d = append(d, make([]byte, DefaultPageSize)...)
+	d = append(d, MakeTestVersionPage(200, false)...)
+	d = append(d, MakeTestVersionPage(300, true)...)
+	path := filepath.Join(dir, "#ib_16384_0.dblwr")
+	if err := ioutil.WriteFile(path, d, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	P := NewParseIB()
+	pages, err := P.ParseDoublewriteFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[0].fh.FIL_PAGE_LSN != 100 || pages[1].fh.FIL_PAGE_LSN != 200 {
+		t.Fatalf("read %d pages from the doublewrite file, expected the LSN 100 and 200", len(pages))
+	}
+	if pages[0].fh.FIL_PAGE_SPACE_ID != 3 || pages[0].fh.FIL_PAGE_OFFSET != 4 {
+		t.Errorf("the page is %d of space %d, expected 4 of space 3",
+			pages[0].fh.FIL_PAGE_OFFSET, pages[0].fh.FIL_PAGE_SPACE_ID)
+	}
+}
+
+// The torn page in the data file have two copies in the doublewrite buffer.
+func TestChoosePageVersions(t *testing.T) {
+	P := NewParseIB()
+	versions := MakeTestPages(t, P, MakeTestVersionPage(400, true), MakeTestVersionPage(200, false),
+		MakeTestVersionPage(100, false), MakeTestVersionPage(200, false))
+
+	for version, expected := range map[string][]uint64{
+		PageVersionNewest: {200},
+		"":                {200},
+		PageVersionOldest: {100},
+		PageVersionAll:    {200, 100},
+	} {
+		P.PageVersion = version
+		var lsn []uint64
+		for _, p := range P.ChoosePageVersions(versions) {
+			lsn = append(lsn, p.fh.FIL_PAGE_LSN)
+		}
+		if fmt.Sprint(lsn) != fmt.Sprint(expected) {
+			t.Errorf("the %s versions are %v, expected %v", version, lsn, expected)
+		}
+	}
+
+	// The page in the data file is kept when no version is valid.
+	P.PageVersion = PageVersionNewest
+	torn := MakeTestPages(t, P, MakeTestVersionPage(400, true), MakeTestVersionPage(300, true))
+	if pages := P.ChoosePageVersions(torn); len(pages) != 1 || pages[0].fh.FIL_PAGE_LSN != 400 {
+		t.Errorf("choose %d pages when no version is valid, expected the page in the data file", len(pages))
+	}
+}
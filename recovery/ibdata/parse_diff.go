@@ -0,0 +1,196 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Read both the live records and the deleted records of the table, and
+// compare the deleted records with the live records by the primary key.
+// The PAGE_FREE list also have the records moved by the page split and
+// reorganize, they still exist in the table, so only the newest deleted
+// record of every primary key which have not found in the live records
+// is output.
+func (P *ParseIB) DiffTableData(path string, DBName string, TableName string) error {
+	pages, ParseFileErr := P.ReadTablePages(path)
+	if ParseFileErr != nil {
+		return ParseFileErr
+	}
+	P.File = path
+
+	table, GetTableErr := P.GetTableFromDict(DBName, TableName)
+	if GetTableErr != nil {
+		logs.Error("get table from dict failed, the error is ", GetTableErr,
+			" the db name is ", DBName, " the table name is ", TableName)
+		return GetTableErr
+	}
+	KeyNames := P.GetPrimaryKeyNames(table)
+
+	// The records on the free pages are deleted, they are not live.
+	FreePages := P.GetFreePages(pages)
+
+	var LiveRecords, DeletedRecords []Records
+	for _, page := range pages {
+		P.ParsePageHeader(&page)
+
+		// Only the cluster index leaf page, the same as ParseTableData.
+		if page.fh.FIL_PAGE_TYPE != FilPageIndex || page.ph.PAGE_LEVEL != 0 ||
+			page.ph.PAGE_MAX_TRX_ID != 0 {
+			continue
+		}
+
+		if FreePages[page.fh.FIL_PAGE_OFFSET] {
+			DeletedRecords = append(DeletedRecords, P.ParseFreePageRecords(page, table.Columns)...)
+			continue
+		}
+
+		pos := len(page.OriginalData) - len(page.data)
+		for _, r := range P.ParsePageRecords(page.OriginalData, pos, table.Columns, false, 0) {
+			if r.State == RecordLive {
+				LiveRecords = append(LiveRecords, r)
+			} else {
+				DeletedRecords = append(DeletedRecords, r)
+			}
+		}
+
+		if page.ph.PAGE_FREE != 0 && page.ph.PAGE_FREE < uint64(DefaultPageSize) {
+			DeletedRecords = append(DeletedRecords,
+				P.ParsePageRecords(page.OriginalData, pos, table.Columns, true, page.ph.PAGE_FREE)...)
+		}
+	}
+
+	lost, counts := ClassifyDeletedRecords(LiveRecords, DeletedRecords, KeyNames)
+
+	logs.Info(fmt.Sprintf("the diff result: live rows %d, %s %d, %s %d, %s %d",
+		len(LiveRecords), DiffDeleted, counts[DiffDeleted], DiffOlderVersion, counts[DiffOlderVersion],
+		DiffDuplicate, counts[DiffDuplicate]))
+
+	// Filter the records by the transaction id and the plausibility score.
+	lost = P.FilterTrxRecords(lost)
+	lost = P.FilterRecords(lost, TableName, DBName)
+	if len(lost) == 0 {
+		logs.Info("have no deleted data")
+		return nil
+	}
+
+	// Write the records in the output format.
+	P.WriteRecords(lost, TableName, DBName)
+	return nil
+}
+
+// Classify the deleted records by the primary key, and return the truly
+// deleted records and the count of every class. The primary key may have
+// many deleted versions, such as the row updated and then deleted, the
+// newest version by the DB_TRX_ID is truly deleted and the others are the
+// older versions of it.
+func ClassifyDeletedRecords(LiveRecords []Records, DeletedRecords []Records,
+	KeyNames []string) ([]Records, map[string]int) {
+
+	LiveMap := make(map[string]Records)
+	for _, r := range LiveRecords {
+		LiveMap[MakeRecordKey(r.Columns, KeyNames)] = r
+	}
+
+	NewestMap := make(map[string]Records)
+	for _, r := range DeletedRecords {
+		key := MakeRecordKey(r.Columns, KeyNames)
+		if _, ok := LiveMap[key]; ok {
+			continue
+		}
+		if newest, ok := NewestMap[key]; !ok || r.TrxId > newest.TrxId {
+			NewestMap[key] = r
+		}
+	}
+
+	// The same deleted record may be found more than once.
+	seen := make(map[string]bool)
+	counts := make(map[string]int)
+	var lost []Records
+	for _, r := range DeletedRecords {
+		key := MakeRecordKey(r.Columns, KeyNames)
+
+		class := DiffDeleted
+		if live, ok := LiveMap[key]; ok {
+			class = DiffOlderVersion
+			if SameRecordValues(live.Columns, r.Columns) {
+				class = DiffDuplicate
+			}
+		} else if newest := NewestMap[key]; !SameRecordValues(newest.Columns, r.Columns) {
+			class = DiffOlderVersion
+		} else if seen[key] {
+			class = DiffDuplicate
+		}
+		counts[class]++
+
+		logs.Debug("the ", r.State, " record at page ", r.PageNo, " offset ", r.Offset, " is ", class)
+		if class != DiffDeleted {
+			continue
+		}
+		seen[key] = true
+		lost = append(lost, NewestMap[key])
+	}
+	return lost, counts
+}
+
+// Get the primary key column names, the table without primary key use the DB_ROW_ID.
+func (P *ParseIB) GetPrimaryKeyNames(table Tables) []string {
+	var names []string
+	for _, idx := range table.Indexes {
+		if idx.Name == "PRIMARY" {
+			for _, f := range idx.Fields {
+				names = append(names, f.ColumnName)
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "DB_ROW_ID")
+	}
+	return names
+}
+
+// Make the primary key values of the record to a string key.
+func MakeRecordKey(columns []Columns, KeyNames []string) string {
+	var values []string
+	for _, name := range KeyNames {
+		for _, c := range columns {
+			if c.FieldName == name {
+				values = append(values, fmt.Sprintf("%v", c.FieldValue))
+			}
+		}
+	}
+	return strings.Join(values, "\x00")
+}
+
+// Make the user column values of the record to a string,
+// the internal columns are different between the versions.
+func MakeRecordValues(columns []Columns) string {
+	var values []string
+	for _, c := range columns {
+		if c.FieldName == "DB_ROW_ID" || c.FieldName == "DB_TRX_ID" || c.FieldName == "DB_ROLL_PTR" {
+			continue
+		}
+		values = append(values, fmt.Sprintf("%v", c.FieldValue))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// Whether the two records have the same user column values.
+func SameRecordValues(a []Columns, b []Columns) bool {
+	return MakeRecordValues(a) == MakeRecordValues(b)
+}
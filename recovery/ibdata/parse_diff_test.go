@@ -0,0 +1,54 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"testing"
+)
+
+func MakeTestDiffRecord(id int, TrxId uint64, value string) Records {
+	return Records{TrxId: TrxId, Columns: []Columns{
+		{FieldName: "id", FieldValue: id},
+		{FieldName: "DB_TRX_ID", FieldValue: TrxId},
+		{FieldName: "c1", FieldValue: value},
+	}}
+}
+
+func TestClassifyDeletedRecords(t *testing.T) {
+	live := []Records{MakeTestDiffRecord(1, 10, "a")}
+	deleted := []Records{
+		// The row 1 is live, the record moved by the page split and the older version.
+		MakeTestDiffRecord(1, 10, "a"),
+		MakeTestDiffRecord(1, 5, "b"),
+		// The row 2 is updated twice and then deleted, the newest version is found last.
+		MakeTestDiffRecord(2, 20, "x"),
+		MakeTestDiffRecord(2, 30, "y"),
+		MakeTestDiffRecord(2, 40, "z"),
+		MakeTestDiffRecord(2, 40, "z"),
+		// The row 3 is only deleted.
+		MakeTestDiffRecord(3, 50, "c"),
+	}
+
+	lost, counts := ClassifyDeletedRecords(live, deleted, []string{"id"})
+	if len(lost) != 2 {
+		t.Fatalf("have %d truly deleted records, expected 2", len(lost))
+	}
+	if lost[0].TrxId != 40 || lost[0].Columns[2].FieldValue != "z" || lost[1].TrxId != 50 {
+		t.Errorf("the truly deleted records are %v", lost)
+	}
+	if counts[DiffDeleted] != 2 || counts[DiffOlderVersion] != 3 || counts[DiffDuplicate] != 2 {
+		t.Errorf("the counts are %v", counts)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Scan the page heap byte by byte to find the records which can't be reached
+// from the normal record list or the PAGE_FREE list, such as the record which
+// the free list pointer is overwritten. Every offset between the supremum and
+// the page directory is tried as a record origin, and only the record which
+// pass the header, null bitmap and field length checks is decoded.
+// Reference undrop-for-innodb c_parser.c check_for_a_record
+func (P *ParseIB) ScanPageHeap(page Page, columns []Columns) []Records {
+	var AllRecords []Records
+
+	d := page.OriginalData
+	if len(d) != DefaultPageSize || len(columns) == 0 {
+		return nil
+	}
+
+	table, ok := P.GetColumnsTable(columns)
+	if !ok {
+		logs.Error("can't find table by field's table id ", columns[0].TableID)
+		return nil
+	}
+
+	// Skip the records which have been read from the record lists.
+	known := make(map[uint64]bool)
+	for _, r := range P.ParsePageRecords(d, len(d)-len(page.data), columns, false, 0) {
+		known[r.Offset] = true
+	}
+	if page.ph.PAGE_FREE != 0 && page.ph.PAGE_FREE < uint64(DefaultPageSize) {
+		for _, r := range P.ParsePageRecords(d, len(d)-len(page.data), columns, true, page.ph.PAGE_FREE) {
+			known[r.Offset] = true
+		}
+	}
+
+	var start, ExtraBytes uint64
+	if utils.PageIsComp(d) == 0 {
+		// The supremum record of the redundant page is "supremum\0".
+		start = 38 + 36 + 2*10 + 2 + 2*6 + 8 + 9
+		ExtraBytes = 6
+	} else {
+		start = 38 + 36 + 2*10 + 2*5 + 8 + 8
+		ExtraBytes = 5
+	}
+
+	// The page directory is at the page end, before the fil trailer.
+	// #define PAGE_DIR (FIL_PAGE_DATA_END)
+	// #define PAGE_DIR_SLOT_SIZE 2
+	end := uint64(DefaultPageSize) - 8 - page.ph.PAGE_N_DIR_SLOTS*2
+	if end <= start {
+		return nil
+	}
+
+	for offset := start + ExtraBytes; offset < end; offset++ {
+		if known[offset] {
+			continue
+		}
+		if !P.CheckRecordHeader(d, offset, page.ph.PAGE_N_HEAP, uint64(len(columns)), start, end) {
+			continue
+		}
+
+		c, size, ok := P.ParseHeapRecord(d, offset, columns, table, end)
+		if !ok {
+			continue
+		}
+
+		logs.Debug("found orphaned record at offset ", offset, " data size is ", size)
+		AllRecords = append(AllRecords, Records{
+			Columns: c,
+			State:   RecordOrphaned,
+			PageNo:  page.fh.FIL_PAGE_OFFSET,
+			Offset:  offset})
+
+		// The data of the record can't be another record's origin.
+		offset += size - 1
+	}
+	return AllRecords
+}
+
+// Check the record header before the origin.
+// 1.The info bits should not have the min record flag, and the n_owned
+// should not be larger than PAGE_DIR_SLOT_MAX_N_OWNED.
+// 2.The heap_no should be in the range of the page heap, the record
+// status should be REC_STATUS_ORDINARY on the leaf page.
+// 3.The next record should be in the page heap, or be the supremum, or be 0.
+// Reference mysql-5.7.19/storage/innobase/include/rem0rec.ic
+func (P *ParseIB) CheckRecordHeader(d []byte, offset uint64, NHeap uint64, NFields uint64, start uint64, end uint64) bool {
+
+	// #define PAGE_HEAP_NO_USER_LOW 2
+	// #define PAGE_DIR_SLOT_MAX_N_OWNED 8
+	NHeap &= 0x7FFF
+
+	var InfoBits, NOwned, HeapNo, next uint64
+	if utils.PageIsComp(d) == 0 {
+		InfoBits = utils.MatchReadFrom1(d[offset-6:]) & RecInfoBitsMask
+		NOwned = utils.MatchReadFrom1(d[offset-6:]) & 0x0F
+
+		// #define REC_OLD_HEAP_NO 5, REC_HEAP_NO_MASK 0xFFF8
+		HeapNo = (utils.MatchReadFrom2(d[offset-5:]) & 0xFFF8) >> 3
+
+		// #define REC_OLD_N_FIELDS 4, REC_OLD_N_FIELDS_MASK 0x7FE
+		if (utils.MatchReadFrom2(d[offset-4:])&0x7FE)>>1 != NFields {
+			return false
+		}
+
+		// The redundant record store the absolute offset of the next record.
+		next = utils.MatchReadFrom2(d[offset-2:])
+		if next != 0 && next != start-9 && (next < start || next >= end) {
+			return false
+		}
+	} else {
+		InfoBits = utils.MatchReadFrom1(d[offset-5:]) & RecInfoBitsMask
+		NOwned = utils.MatchReadFrom1(d[offset-5:]) & 0x0F
+
+		// #define REC_NEW_HEAP_NO 4, REC_HEAP_NO_MASK 0xFFF8
+		HeapNo = (utils.MatchReadFrom2(d[offset-4:]) & 0xFFF8) >> 3
+
+		// #define REC_STATUS_ORDINARY 0
+		if utils.RecGetStatus(d, offset) != 0 {
+			return false
+		}
+
+		// The compact record store the relative offset of the next record.
+		next = utils.MatchReadFrom2(d[offset-2:])
+		if next != 0 {
+			next = (offset + next) & 0xFFFF
+			if next != start-8 && (next < start || next >= end) {
+				return false
+			}
+		}
+	}
+
+	if InfoBits&RecInfoMinRecFlag != 0 || NOwned > 8 {
+		return false
+	}
+	if HeapNo < 2 || HeapNo >= NHeap {
+		return false
+	}
+	return true
+}
+
+// Decode the record at the offset when the null bitmap and the field length
+// match the table struct, return the columns and the record data size.
+func (P *ParseIB) ParseHeapRecord(d []byte, offset uint64, columns []Columns, table Tables, end uint64) (c []Columns, size uint64, ok bool) {
+
+	// The garbage data may make the offsets out of the page.
+	defer func() {
+		if err := recover(); err != nil {
+			logs.Debug("skip the record at offset ", offset, " the error is ", err)
+			c, size, ok = nil, 0, false
+		}
+	}()
+
+	var offsets []uint64 = make([]uint64, len(columns)*2+2, len(columns)*2+2)
+	origin := d[offset:]
+
+	if utils.PageIsComp(d) == 0 {
+		P.IbrecInitOffsetsOld(d, offset, origin, &offsets, uint64(len(columns)))
+	} else {
+		if !P.CheckNullBitmap(d, offset, table) {
+			return nil, 0, false
+		}
+		if !P.IbrecInitOffsetsNew(d, offset, origin, &offsets, table) {
+			return nil, 0, false
+		}
+	}
+
+	size = utils.RecOffsDataSize(&offsets)
+	if size == 0 || offset+size > end {
+		return nil, 0, false
+	}
+
+	if !P.CheckFieldSize(&offsets, columns) || !P.CheckVarFieldSize(offsets, columns) {
+		return nil, 0, false
+	}
+
+	c, _ = P.ParseRecords(d, origin, offsets, columns)
+	return c, size, true
+}
+
+// The unused bits of the last null bitmap byte are always zero,
+// the bitmap is set to zero before the record is built.
+// Reference mysql-5.7.19/storage/innobase/rem/rem0rec.cc rec_convert_dtuple_to_rec_comp
+func (P *ParseIB) CheckNullBitmap(d []byte, offset uint64, table Tables) bool {
+	present, ExtraBytes, ok := P.RecGetInstantFields(d, offset, table)
+	if !ok {
+		return false
+	}
+
+	NullCount := 0
+	for i := 0; i < len(table.Columns); i++ {
+		if present[i] && table.Columns[i].IsNUll {
+			NullCount++
+		}
+	}
+	if NullCount%8 == 0 {
+		return true
+	}
+
+	// The null bitmap is stored from the high address to the low address,
+	// the last byte have the unused high bits.
+	last := d[offset-ExtraBytes-1-uint64(NullCount-1)/8]
+	return last>>uint(NullCount%8) == 0
+}
+
+// Check the variable length field size, it should not be larger than the
+// column max length, and the external field should have the 20 bytes reference.
+func (P *ParseIB) CheckVarFieldSize(offsets []uint64, columns []Columns) bool {
+	for i := 0; i < len(columns); i++ {
+		if utils.RecOffsNthDefault(offsets, i) {
+			continue
+		}
+		if utils.GetFixedLength(columns[i].FieldType, columns[i].FieldLen) != 0 {
+			continue
+		}
+
+		value := offsets[2:][1+i]
+		if value&(1<<31) != 0 {
+			if !columns[i].IsNUll {
+				return false
+			}
+			continue
+		}
+
+		DataLen := utils.RecOffsNthSize(&offsets, i)
+		if value&(1<<30) != 0 {
+			// #define BTR_EXTERN_FIELD_REF_SIZE 20
+			if DataLen < 20 {
+				return false
+			}
+			continue
+		}
+
+		switch columns[i].FieldType {
+		case utils.DATA_BLOB, utils.DATA_GEOMETRY, utils.DATA_VAR_POINT:
+			continue
+		}
+		if columns[i].FieldLen != 0 && DataLen > columns[i].FieldLen {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The page have one linked record, one orphaned record which no list
+// reach, a record header with the bad null bitmap and a random byte run.
+func TestScanPageHeap(t *testing.T) {
+	P := NewParseIB()
+	extra, data := MakeTestSDIRecord(t, TestSDI)
+	pages := MakeTestPages(t, P, MakeTestPage(FilPageSdi, [][2][]byte{{extra, data}}))
+	for TableId, table := range P.GetSDITables(pages) {
+		P.TableMap[TableId] = table
+	}
+	columns := P.TableMap[1065].Columns
+
+	// The version 0 record (id, DB_TRX_ID, DB_ROLL_PTR, c1, c3).
+	fields := func(id uint32, c1 uint32, c3 uint32) []byte {
+		d := make([]byte, 4+6+7+4+4)
+		binary.BigEndian.PutUint32(d, id|0x80000000)
+		d[9] = byte(id)
+		binary.BigEndian.PutUint32(d[17:], c1|0x80000000)
+		binary.BigEndian.PutUint32(d[21:], c3|0x80000000)
+		return d
+	}
+	d := MakeTestPage(FilPageIndex, [][2][]byte{{MakeTestExtra(0, 0), fields(1, 5, 7)}})
+	binary.BigEndian.PutUint16(d[38+4:], 0x8000|5)
+
+	// The header is the null bitmap, the info bits, the heap no and the next record.
+	header := func(rec int, NullBitmap byte, HeapNo int) {
+		d[rec-6] = NullBitmap
+		d[rec-5] = 0
+		binary.BigEndian.PutUint16(d[rec-4:], uint16(HeapNo<<3))
+		binary.BigEndian.PutUint16(d[rec-2:], 0)
+	}
+	const orphan, junk = 300, 400
+	header(orphan, 0, 3)
+	copy(d[orphan:], fields(2, 6, 8))
+
+	// The c1 is the only nullable column, the other null bits are unused.
+	header(junk, 0xFE, 4)
+	copy(d[junk:], fields(3, 6, 8))
+
+	random := rand.New(rand.NewSource(1))
+	random.Read(d[500:2000])
+
+	page := MakeTestPages(t, P, d)[0]
+	P.ParsePageHeader(&page)
+	if !P.CheckRecordHeader(d, junk, page.ph.PAGE_N_HEAP, uint64(len(columns)), 120, uint64(DefaultPageSize-8)) {
+		t.Error("the junk record is rejected by the header, expected it is rejected by the null bitmap")
+	}
+
+	records := P.ScanPageHeap(page, columns)
+	if len(records) != 1 {
+		t.Fatalf("found %d records, expected the orphaned record, %v", len(records), records)
+	}
+	r := records[0]
+	var values []interface{}
+	for _, c := range r.Columns {
+		values = append(values, c.FieldValue)
+	}
+	if r.Offset != orphan || r.State != RecordOrphaned || fmt.Sprint(values) != "[2 2 0 6 8 abc]" {
+		t.Errorf("found the %s record %v at offset %d", r.State, values, r.Offset)
+	}
+}
@@ -15,9 +15,9 @@
 package ibdata
 
 import (
-	`bytes`
 	"fmt"
 	"os"
+	"sort"
 	`strings`
 	"sync"
 
@@ -29,9 +29,72 @@ type ParseIB struct {
 	// store table's struct.
 	TableMap map[uint64]Tables
 
+	// The secondary index record layouts, the key is the index id ORed by
+	// the SecondaryIndexTableFlag, they are not the tables.
+	IndexTableMap map[uint64]Tables
+
 	// TODO: change to map.
 	// store page data.
 	D *sync.Map
+
+	// Only output the delete marked records which have not been purged,
+	// the records are still on the normal record list.
+	DeleteMarkedOnly bool
+
+	// Scan the page heap to find the orphaned records.
+	ScanHeap bool
+
+	// Read the purged records of the dict tables too, the dropped
+	// table's dict records are on the PAGE_FREE list after purge.
+	RecoverDroppedDict bool
+
+	// Only output the records which confidence is not less than it,
+	// the rejected records are written to the RejectedWriter.
+	MinConfidence  float64
+	RejectedWriter RowWriter
+
+	// Only output the records which DB_TRX_ID is in the range,
+	// 0 means no limit.
+	MinTrxId uint64
+	MaxTrxId uint64
+
+	// The page copies read from the doublewrite buffer, and which
+	// version is used when the page have many versions.
+	DoublewritePages []Page
+	PageVersion      string
+
+	// Write the recovered rows in the output format.
+	Writer RowWriter
+
+	// The data file which is being parsed, it is written with the rows.
+	File string
+
+	// Keep all versions of the same page in the file, the carved page files
+	// may have the same page of the index several times with different LSN.
+	KeepPageVersions bool
+}
+
+// Store a record read from the page, and where it come from.
+type Records struct {
+	Columns []Columns
+
+	// The record state, RecordLive, RecordDeleteMarked or RecordPurgedFree.
+	State  string
+	PageNo uint64
+	Offset uint64
+
+	// The plausibility score between 0 and 1, and the reasons
+	// why some values of the record are not plausible.
+	Confidence float64
+	Reasons    []string
+
+	// The DB_TRX_ID and DB_ROLL_PTR of the record, the transaction
+	// which insert, update or delete the record last.
+	TrxId   uint64
+	RollPtr uint64
+
+	// The last LSN of the page, only set for the free page record.
+	LSN uint64
 }
 
 // Store the table structure info.
@@ -42,6 +105,14 @@ type Tables struct {
 	Indexes   map[uint64]Indexes
 	NullCount int
 	SpaceId   uint64
+
+	// The field num of the record before the first instant add column,
+	// 0 means the table have no instant column added by 8.0.12 ~ 8.0.28.
+	InstantCols uint64
+
+	// The virtual generated columns, they are not in the Columns,
+	// because they are not stored in the record.
+	VirtualColumns []Columns
 }
 
 // Store the table columns info.
@@ -56,6 +127,40 @@ type Columns struct {
 	IsBinary   bool
 	IsUnsigned bool
 	TableID    uint64
+
+	// The ENUM/SET labels, the data dictionary don't store it,
+	// should be read from the table struct.
+	Elements []string
+
+	// MySQL 8.0 instant add/drop column info, the row version which the
+	// column added or dropped, 0 means it is not added or dropped instantly.
+	// The default value is used when the record don't store the column,
+	// nil means the default value is NULL.
+	VersionAdded   uint64
+	VersionDropped uint64
+	InstantDefault interface{}
+
+	// The virtual generated column is not stored in the cluster index record,
+	// the base columns which it depends on are read from SYS_VIRTUAL.
+	IsVirtual   bool
+	BaseColumns []string
+
+	// The charset collation id of the string column.
+	Charset uint64
+
+	// The precision and scale of the DECIMAL column, and the bits of the
+	// BIT column, the data dictionary don't store them, should be read
+	// from the table struct.
+	Precision int
+	Scale     int
+
+	// The LEN of SYS_COLUMNS, the max bytes of the column value, the
+	// FieldLen of the DATA_BINARY column is 0 to parse it as the string.
+	MaxLen uint64
+
+	// The secondary index record only store the prefix of the column,
+	// such as KEY k1 (c1(10)), the value is not the whole column value.
+	IsPrefix bool
 }
 
 // Store the table index info.
@@ -71,6 +176,10 @@ type Indexes struct {
 type Fields struct {
 	ColumnPos  uint64
 	ColumnName string
+
+	// The bytes of the column prefix in the index, 0 means the whole column.
+	PrefixLen uint64
+
 	//ColumnType  uint64
 	ColumnValue interface{}
 }
@@ -95,6 +204,10 @@ type FilHeader struct {
 	FIL_PAGE_TYPE           uint64
 	FIL_PAGE_FILE_FLUSH_LSN uint64
 	FIL_PAGE_ARCH_LOG_NO    uint64
+
+	// The FIL_PAGE_ARCH_LOG_NO is used to store the space id since 4.1.
+	// #define FIL_PAGE_SPACE_ID FIL_PAGE_ARCH_LOG_NO_OR_SPACE_ID
+	FIL_PAGE_SPACE_ID uint64
 }
 
 // Name those fields with MySQL code style.
@@ -133,6 +246,23 @@ type SystemPageHeader struct {
 	DICT_HDR_FIELDS       uint64
 }
 
+// Identify the page by the space id and the page number, and the LSN
+// when the page versions are kept.
+type PageKey struct {
+	SpaceId uint64
+	PageNo  uint64
+	LSN     uint64
+}
+
+// Make the key of the page, the first page of the same key is read.
+func (P *ParseIB) MakePageKey(p Page) PageKey {
+	key := PageKey{SpaceId: p.fh.FIL_PAGE_SPACE_ID, PageNo: p.fh.FIL_PAGE_OFFSET}
+	if P.KeepPageVersions {
+		key.LSN = p.fh.FIL_PAGE_LSN
+	}
+	return key
+}
+
 type Page struct {
 	// All MySQL page have this header.
 	fh FilHeader
@@ -153,6 +283,7 @@ func NewParseIB() *ParseIB {
 	TableMap := make(map[uint64]Tables)
 	d := new(sync.Map)
 	p.TableMap = TableMap
+	p.IndexTableMap = make(map[uint64]Tables)
 	p.D = d
 	return p
 }
@@ -170,8 +301,7 @@ func (P *ParseIB) ParseFile(path string) ([]Page, error) {
 
 	defer file.Close()
 
-	var PageMap map[uint64]string
-	PageMap = make(map[uint64]string)
+	PageMap := make(map[PageKey]bool)
 
 	for {
 		// Read a page from data file.
@@ -180,7 +310,7 @@ func (P *ParseIB) ParseFile(path string) ([]Page, error) {
 			break
 		}
 		if err != nil {
-			logs.Error("read data from file failed, the error is %s", err.Error())
+			logs.Error("read data from file failed, the error is ", err.Error())
 			return nil, err
 		}
 
@@ -193,12 +323,11 @@ func (P *ParseIB) ParseFile(path string) ([]Page, error) {
 		// Store the page.
 		p.OriginalData = d
 
-		// TODO: why have the same pages?
-		// Store the page into the PageMap.
-		_, ok := PageMap[p.fh.FIL_PAGE_OFFSET]
-		if !ok {
+		// Skip the same page, such as the empty pages.
+		key := P.MakePageKey(p)
+		if !PageMap[key] {
 			AllPages = append(AllPages, p)
-			PageMap[p.fh.FIL_PAGE_OFFSET] = "have"
+			PageMap[key] = true
 		}
 	}
 	return AllPages, nil
@@ -288,13 +417,13 @@ func (P *ParseIB) AddInternalColumnsLow(InternalFieldName string, TableId uint64
 	var InternalField Columns
 	switch InternalFieldName {
 	case "DB_ROW_ID":
-		InternalField = Columns{FieldName: "DB_ROW_ID", FieldType: utils.DATA_MISSING, FieldPos: 0,
+		InternalField = Columns{FieldName: "DB_ROW_ID", FieldType: utils.DATA_SYS, FieldPos: 0,
 			FieldLen: 6, IsNUll: false, TableID: TableId}
 	case "DB_TRX_ID":
-		InternalField = Columns{FieldName: "DB_TRX_ID", FieldType: utils.DATA_MISSING,
+		InternalField = Columns{FieldName: "DB_TRX_ID", FieldType: utils.DATA_SYS,
 			FieldPos: FieldPos, FieldLen: 6, IsNUll: false, TableID: TableId}
 	case "DB_ROLL_PTR":
-		InternalField = Columns{FieldName: "DB_ROLL_PTR", FieldType: utils.DATA_MISSING,
+		InternalField = Columns{FieldName: "DB_ROLL_PTR", FieldType: utils.DATA_SYS,
 			FieldPos: FieldPos, FieldLen: 7, IsNUll: false, TableID: TableId}
 	}
 	return InternalField
@@ -303,7 +432,7 @@ func (P *ParseIB) AddInternalColumnsLow(InternalFieldName string, TableId uint64
 // Get all column info from sys column dict tables.
 func (P *ParseIB) GetAllColumns() error {
 
-	var AllPageColumns [][]Columns
+	var AllPageColumns []Records
 	columns := P.MakeSysColumnsColumns()
 	v, ok := P.D.Load(SysColumnsIdx)
 	if !ok {
@@ -317,63 +446,113 @@ func (P *ParseIB) GetAllColumns() error {
 	for _, dt := range dts {
 
 		// Start parse sys column table page.
-		AllColumns := P.ParsePage(dt.data, dt.pos, columns, false, 0)
+		AllColumns := P.ParseDictRecords(dt, columns)
 		AllPageColumns = append(AllPageColumns, AllColumns...)
 	}
 
-	for _, c := range AllPageColumns {
+	for _, r := range AllPageColumns {
+		// TABLE_ID, POS, MTYPE, PRTYPE, LEN and NAME.
+		values, ok := GetDictUints(r.Columns, 0, 1, 5, 6, 7)
+		FieldName, NameOk := GetDictString(r.Columns, 4)
+		if !ok || !NameOk {
+			logs.Warn("skip the SYS_COLUMNS record which can't be decoded, the state is ", r.State,
+				" page is ", r.PageNo, " offset is ", r.Offset)
+			continue
+		}
+		TableId, pos, mtype, prtype, length := values[0], values[1], values[2], values[3], values[4]
 
-		v, ok := P.TableMap[c[0].FieldValue.(uint64)]
+		v, ok := P.TableMap[TableId]
 		if ok {
 			t := v
 
+			// The purged record may be the old version of the column.
+			if r.State == RecordPurgedFree && t.HaveColumn(pos, prtype) {
+				continue
+			}
+
 			IsNull := false
 			IsUnsigned := false
 			var MySQLType uint64
 
+			// #define DATA_VIRTUAL	8192
+			// the virtual column's POS encode the nth virtual column in the high bits,
+			// and the column position in the table in the low bits.
+			// Reference mysql-5.7.19/storage/innobase/include/dict0crea.ic dict_create_v_col_pos
+			IsVirtual := prtype & DataVirtual != 0
+			FieldPos := pos
+			if IsVirtual {
+				FieldPos &= 0xFFFF
+			}
+
 			// #define DATA_NOT_NULL	256
 			// this is ORed to the precise type when the column is declared as NOT NULL
 			// TODO: const
-			if c[6].FieldValue.(uint64) & 256 == 0 {
+			if prtype & 256 == 0 {
 				IsNull = true
-				t.NullCount++
+				if !IsVirtual {
+					t.NullCount++
+				}
 			}
 
 			// #define DATA_UNSIGNED	512
-			usign := c[6].FieldValue.(uint64) & 512
+			usign := prtype & 512
 			if usign != 0 {
 				IsUnsigned = true
 			}
 
 			// reference MySQL dtype_get_mysql_type method.
 			// mysql-5.7.19/storage/innobase/include/data0type.ic
-			MySQLType = c[6].FieldValue.(uint64) & 0xFF
+			MySQLType = prtype & 0xFF
+
+			// reference MySQL dtype_get_charset_coll method.
+			// #define CHAR_COLL_MASK MAX_CHAR_COLL_NUM
+			Charset := (prtype >> 16) & 32767
 
 			IsBinary := false
-			if c[5].FieldValue.(uint64) == utils.DATA_BLOB {
-				if (c[6].FieldValue.(uint64) & 1024) != 0 {
+			if mtype == utils.DATA_BLOB {
+				if (prtype & 1024) != 0 {
 					// this is text type.
 					IsBinary = true
 				}
 			}
 
 			var TempFieldLen uint64 = 0
-			if c[5].FieldValue.(uint64) != utils.DATA_BINARY {
-				TempFieldLen = c[7].FieldValue.(uint64)
+			if mtype != utils.DATA_BINARY {
+				TempFieldLen = length
 			}
 
-			t.Columns = append(t.Columns,
-				Columns{
-					FieldName: c[4].FieldValue.(string),
-					FieldType: c[5].FieldValue.(uint64),
-					MySQLType: MySQLType,
-					FieldPos: c[1].FieldValue.(uint64),
-					FieldLen: TempFieldLen,
-					IsNUll: IsNull, IsUnsigned: IsUnsigned,
-					TableID: c[0].FieldValue.(uint64),
-					IsBinary: IsBinary})
-
-			P.TableMap[c[0].FieldValue.(uint64)] = t
+			column := Columns{
+				FieldName: FieldName,
+				FieldType: mtype,
+				MySQLType: MySQLType,
+				FieldPos: FieldPos,
+				FieldLen: TempFieldLen,
+				IsNUll: IsNull, IsUnsigned: IsUnsigned,
+				TableID: TableId,
+				IsBinary: IsBinary,
+				IsVirtual: IsVirtual,
+				Charset: Charset,
+				MaxLen: length}
+
+			// The virtual column is not stored in the record, don't put it
+			// into the columns, otherwise the following fields will be shifted.
+			if IsVirtual {
+				t.VirtualColumns = append(t.VirtualColumns, column)
+			} else {
+				t.Columns = append(t.Columns, column)
+			}
+
+			P.TableMap[TableId] = t
+		}
+	}
+
+	// The purged records are not in the column position order.
+	if P.RecoverDroppedDict {
+		for TableId, t := range P.TableMap {
+			sort.SliceStable(t.Columns, func(i, j int) bool {
+				return t.Columns[i].FieldPos < t.Columns[j].FieldPos
+			})
+			P.TableMap[TableId] = t
 		}
 	}
 	return nil
@@ -382,7 +561,7 @@ func (P *ParseIB) GetAllColumns() error {
 // Get all index info from sys index dict table.
 func (P *ParseIB) GetAllIndexes() error {
 
-	var AllPageColumns [][]Columns
+	var AllPageColumns []Records
 	columns := P.MakeSysIndexesColumns()
 	v, ok := P.D.Load(SysIndexesIdx)
 	if !ok {
@@ -394,18 +573,33 @@ func (P *ParseIB) GetAllIndexes() error {
 	dts := v.([]DataDict)
 	for _, dt := range dts {
 		// Start parse sys indexes table pages.
-		AllColumns := P.ParsePage(dt.data, dt.pos, columns, false, 0)
+		AllColumns := P.ParseDictRecords(dt, columns)
 		AllPageColumns = append(AllPageColumns, AllColumns...)
 
 	}
 
-	for _, columns := range AllPageColumns {
+	for _, r := range AllPageColumns {
+		// TABLE_ID, ID, N_FIELDS, TYPE and NAME.
+		values, ok := GetDictUints(r.Columns, 0, 1, 5, 6)
+		IndexName, NameOk := GetDictString(r.Columns, 4)
+		if !ok || !NameOk {
+			logs.Warn("skip the SYS_INDEXES record which can't be decoded, the state is ", r.State,
+				" page is ", r.PageNo, " offset is ", r.Offset)
+			continue
+		}
+		TableId, IndexId, FieldNum, IndexType := values[0], values[1], values[2], values[3]
+
 		var index map[uint64]Indexes
-		v, ok := P.TableMap[columns[0].FieldValue.(uint64)]
+		v, ok := P.TableMap[TableId]
 
 		if ok {
 			t := v
 
+			// The purged record may be the old version of the index.
+			if _, exist := t.Indexes[IndexId]; exist && r.State == RecordPurgedFree {
+				continue
+			}
+
 			if t.Indexes == nil {
 				// Use map to store index, and the key is the index id. value is index info.
 				index = make(map[uint64]Indexes)
@@ -413,17 +607,17 @@ func (P *ParseIB) GetAllIndexes() error {
 				index = t.Indexes
 			}
 
-			index[columns[1].FieldValue.(uint64)] =
+			index[IndexId] =
 				Indexes{
-					Id: columns[1].FieldValue.(uint64),
-					Name: columns[4].FieldValue.(string),
-					IndexType: columns[6].FieldValue.(uint64),
-					FieldNum: columns[5].FieldValue.(uint64)}
+					Id: IndexId,
+					Name: IndexName,
+					IndexType: IndexType,
+					FieldNum: FieldNum}
 
 			t.Indexes = index
-			P.TableMap[columns[0].FieldValue.(uint64)] = t
+			P.TableMap[TableId] = t
 		} else {
-			logs.Error("Table ID have not found ", columns[0].FieldValue)
+			logs.Error("Table ID have not found ", TableId)
 		}
 	}
 	return nil
@@ -432,7 +626,7 @@ func (P *ParseIB) GetAllIndexes() error {
 // Get all fields from sys fields dict table.
 func (P *ParseIB) GetAllFields() error {
 
-	var AllPageColumns [][]Columns
+	var AllPageColumns []Records
 	columns := P.MakeSysFieldsColumns()
 	v, ok := P.D.Load(SysFieldsIdx)
 	if !ok {
@@ -444,28 +638,45 @@ func (P *ParseIB) GetAllFields() error {
 	dts := v.([]DataDict)
 	for _, dt := range dts {
 		// Start parse sys fields table pages.
-		AllColumns := P.ParsePage(dt.data, dt.pos, columns, false, 0)
+		AllColumns := P.ParseDictRecords(dt, columns)
 		AllPageColumns = append(AllPageColumns, AllColumns...)
 	}
 
 	// store all fields into index map
 	IndexFieldsMap := make(map[uint64][]*Fields)
-	for _, columns := range AllPageColumns {
-		v, ok := IndexFieldsMap[columns[0].FieldValue.(uint64)]
+	for _, r := range AllPageColumns {
+		// INDEX_ID, POS and COL_NAME.
+		values, ok := GetDictUints(r.Columns, 0, 1)
+		ColumnName, NameOk := GetDictString(r.Columns, 4)
+		if !ok || !NameOk {
+			logs.Warn("skip the SYS_FIELDS record which can't be decoded, the state is ", r.State,
+				" page is ", r.PageNo, " offset is ", r.Offset)
+			continue
+		}
+		IndexId, pos := values[0], values[1]
+		v, ok := IndexFieldsMap[IndexId]
+
+		// The purged record may be the old version of the field.
+		if ok && r.State == RecordPurgedFree && HaveField(v, pos) {
+			continue
+		}
+
 		if ok {
-			v = append(v, &Fields{ColumnPos: columns[1].FieldValue.(uint64),
-				ColumnName: columns[4].FieldValue.(string)})
-			IndexFieldsMap[columns[0].FieldValue.(uint64)] = v
+			v = append(v, &Fields{ColumnPos: pos, ColumnName: ColumnName})
+			IndexFieldsMap[IndexId] = v
 		} else {
 			// TODO: nil value
 			var fields []*Fields
 			// TODO: Column Type
-			fields = append(fields, &Fields{ColumnPos: columns[1].FieldValue.(uint64),
-				ColumnName: columns[4].FieldValue.(string)})
-			IndexFieldsMap[columns[0].FieldValue.(uint64)] = fields
+			fields = append(fields, &Fields{ColumnPos: pos, ColumnName: ColumnName})
+			IndexFieldsMap[IndexId] = fields
 		}
 	}
 
+	for _, fields := range IndexFieldsMap {
+		DecodeFieldsPos(fields)
+	}
+
 	// Scan all table index and get fields from index map
 	for TableId, table := range P.TableMap {
 		// Scan table index's array.
@@ -498,30 +709,134 @@ func (P *ParseIB) GetAllTables() error {
 	for _, dt := range dts {
 
 		// parse sys table page.
-		AllColumns := P.ParsePage(dt.data, dt.pos, columns, false, 0)
+		AllColumns := P.ParseDictRecords(dt, columns)
+
+		for _, r := range AllColumns {
+			// NAME, ID and SPACE.
+			values, ok := GetDictUints(r.Columns, 3, 9)
+			name, NameOk := GetDictString(r.Columns, 0)
+			if !ok || !NameOk {
+				logs.Warn("skip the SYS_TABLES record which can't be decoded, the state is ", r.State,
+					" page is ", r.PageNo, " offset is ", r.Offset)
+				continue
+			}
+			TableId, SpaceId := values[0], values[1]
+
+			// The purged record may be the old name of the table.
+			if _, exist := P.TableMap[TableId]; exist && r.State == RecordPurgedFree {
+				continue
+			}
 
-		for _, columns := range AllColumns {
 			// database and table name, for example: zbdba3/jingbo_test
 			var TBName string
 			var DBName string
-			if strings.Contains(columns[0].FieldValue.(string), "/") {
-				n := strings.Split(columns[0].FieldValue.(string), "/")
+			if strings.Contains(name, "/") {
+				n := strings.Split(name, "/")
 				DBName = n[0]
 				TBName = n[1]
 			} else {
-				TBName = columns[0].FieldValue.(string)
+				TBName = name
 			}
-			P.TableMap[columns[3].FieldValue.(uint64)] =
+			P.TableMap[TableId] =
 				Tables{
 				DBName: DBName,
 				TableName: TBName,
 				NullCount: 0,
-				SpaceId: columns[9].FieldValue.(uint64)}
+				SpaceId: SpaceId}
 		}
 	}
 	return nil
 }
 
+// Get the unsigned integer values of the dict record columns, false when any
+// of them can't be decoded, such as the purged record which is overwritten.
+func GetDictUints(columns []Columns, positions ...int) ([]uint64, bool) {
+	var values []uint64
+	for _, i := range positions {
+		if i >= len(columns) {
+			return nil, false
+		}
+		v, ok := columns[i].FieldValue.(uint64)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
+
+// Get the string value of the dict record column, false when it can't be decoded.
+func GetDictString(columns []Columns, i int) (string, bool) {
+	if i >= len(columns) {
+		return "", false
+	}
+	v, ok := columns[i].FieldValue.(string)
+	return v, ok
+}
+
+// Parse the dict table page, the purged records on the PAGE_FREE list
+// are read after the normal records when RecoverDroppedDict is set.
+func (P *ParseIB) ParseDictRecords(dt DataDict, columns []Columns) []Records {
+	AllRecords := P.ParsePageRecords(dt.data, dt.pos, columns, false, 0)
+	if !P.RecoverDroppedDict {
+		return AllRecords
+	}
+
+	// The PAGE_FREE is at the page header, and the redundant
+	// record use the absolute offset.
+	// #define PAGE_FREE 6
+	PageFree := utils.MatchReadFrom2(dt.data[38+6:])
+	if PageFree == 0 || PageFree >= uint64(DefaultPageSize) {
+		return AllRecords
+	}
+	return append(AllRecords, P.ParsePageRecords(dt.data, dt.pos, columns, true, PageFree)...)
+}
+
+// Whether the table have the column at the position.
+func (t Tables) HaveColumn(pos uint64, prtype uint64) bool {
+	columns := t.Columns
+	if prtype&DataVirtual != 0 {
+		columns = t.VirtualColumns
+		pos &= 0xFFFF
+	}
+	for _, c := range columns {
+		if c.FieldPos == pos {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the index have the field at the position.
+func HaveField(fields []*Fields, pos uint64) bool {
+	for _, f := range fields {
+		if f.ColumnPos == pos {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode the SYS_FIELDS POS of the index fields. When the index have the
+// column prefix, the POS of every field is the field number << 16 plus the
+// prefix length, the first field is always decoded so because its field
+// number is 0.
+// Reference mysql-5.7.19/storage/innobase/dict/dict0load.cc dict_load_field_low
+func DecodeFieldsPos(fields []*Fields) {
+	first := -1
+	for i, f := range fields {
+		if first < 0 || f.ColumnPos < fields[first].ColumnPos {
+			first = i
+		}
+	}
+	for i, f := range fields {
+		if i == first || f.ColumnPos > 0xFFFF {
+			f.PrefixLen = f.ColumnPos & 0xFFFF
+			f.ColumnPos = f.ColumnPos >> 16
+		}
+	}
+}
+
 // Get table struct in dict page.
 func (P *ParseIB) ParseDictPage(FilePath string) error {
 
@@ -624,9 +939,81 @@ func (P *ParseIB) ParseDictPage(FilePath string) error {
 	// Add internal columns.
 	P.AddInternalColumns()
 
+	// Get the base columns of the virtual columns.
+	logs.Debug("start parse sys_virtual.")
+	P.GetAllVirtual(pages)
+
 	return nil
 }
 
+// Get the virtual column's base columns from SYS_VIRTUAL dict table.
+// SYS_VIRTUAL is added by MySQL 5.7, it is not at the fixed page like
+// other dict tables, so find its index from the SYS_TABLES and SYS_INDEXES,
+// and then find the pages which belong to the index.
+func (P *ParseIB) GetAllVirtual(pages []Page) {
+
+	var IndexId uint64
+	for _, table := range P.TableMap {
+		if table.DBName == "" && table.TableName == "SYS_VIRTUAL" {
+			for _, idx := range table.Indexes {
+				IndexId = idx.Id
+			}
+		}
+	}
+
+	if IndexId == 0 {
+		logs.Debug("sys virtual table have not found, the MySQL version may be older than 5.7.")
+		return
+	}
+
+	columns := P.MakeSysVirtualColumns()
+	for _, p := range pages {
+		if p.fh.FIL_PAGE_TYPE != FilPageIndex {
+			continue
+		}
+
+		P.ParsePageHeader(&p)
+		if p.ph.PAGE_INDEX_ID != IndexId || p.ph.PAGE_LEVEL != 0 {
+			continue
+		}
+
+		AllColumns := P.ParsePage(p.OriginalData, len(p.OriginalData)-len(p.data), columns, false, 0)
+		for _, c := range AllColumns {
+			// TABLE_ID, POS and BASE_POS.
+			values, ok := GetDictUints(c, 0, 1, 2)
+			if !ok {
+				continue
+			}
+			TableId := values[0]
+			table, ok := P.TableMap[TableId]
+			if !ok {
+				continue
+			}
+
+			// The POS is encoded the same as the SYS_COLUMNS,
+			// and the BASE_POS is the base column's position in the table.
+			pos := values[1] & 0xFFFF
+			BasePos := values[2]
+
+			var BaseName string
+			for _, column := range table.Columns {
+				if column.FieldPos == BasePos && column.FieldName != "DB_ROW_ID" &&
+					column.FieldName != "DB_TRX_ID" && column.FieldName != "DB_ROLL_PTR" {
+					BaseName = column.FieldName
+				}
+			}
+
+			for i := range table.VirtualColumns {
+				if table.VirtualColumns[i].FieldPos == pos && BaseName != "" {
+					table.VirtualColumns[i].BaseColumns =
+						append(table.VirtualColumns[i].BaseColumns, BaseName)
+				}
+			}
+			P.TableMap[TableId] = table
+		}
+	}
+}
+
 func (P *ParseIB) ParseSysPageHeader(page Page) (Page, error) {
 
 	pos := 0
@@ -728,12 +1115,13 @@ func (P *ParseIB) ParseFilHeader(d []byte) (Page, error) {
 
 	PageFileFlushLsn := utils.MatchReadFrom8(d[pos:])
 	logs.Debug("PageFileFlushLsn:", PageFileFlushLsn)
-	p.fh.FIL_PAGE_LSN = PageFileFlushLsn
+	p.fh.FIL_PAGE_FILE_FLUSH_LSN = PageFileFlushLsn
 	pos += 8
 
 	PageArchLogNo := utils.MatchReadFrom4(d[pos:])
 	logs.Debug("PageArchLogNo:", PageArchLogNo)
 	p.fh.FIL_PAGE_ARCH_LOG_NO = PageArchLogNo
+	p.fh.FIL_PAGE_SPACE_ID = PageArchLogNo
 	pos += 4
 
 	p.data = d[pos:]
@@ -970,6 +1358,17 @@ func (P *ParseIB) ParsePageHeader(page *Page) *Page {
 // TODO: support compress and encrypt page.
 func (P *ParseIB) ParsePage(d []byte, pos int, columns []Columns, IsRecovery bool, PageFree uint64) [][]Columns {
 
+	var AllColumns [][]Columns
+	for _, r := range P.ParsePageRecords(d, pos, columns, IsRecovery, PageFree) {
+		AllColumns = append(AllColumns, r.Columns)
+	}
+	return AllColumns
+}
+
+// Parse the page records, the same as ParsePage, and mark every record
+// with its state and position in the page.
+func (P *ParseIB) ParsePageRecords(d []byte, pos int, columns []Columns, IsRecovery bool, PageFree uint64) []Records {
+
 	// catch panic
 	//defer func() {
 	//	if err := recover(); err != nil {
@@ -977,7 +1376,7 @@ func (P *ParseIB) ParsePage(d []byte, pos int, columns []Columns, IsRecovery boo
 	//	}
 	//}()
 
-	var AllColumns [][]Columns
+	var AllRecords []Records
 
 	// The PAGE_BTR_SEG_LEAF length
 	pos += 10
@@ -1034,6 +1433,7 @@ func (P *ParseIB) ParsePage(d []byte, pos int, columns []Columns, IsRecovery boo
 	}
 
 	var TotalLen uint64
+	var TrxId, RollPtr uint64
 	for {
 		// TODO: const
 		if offset < (16384 - 6) && (offset != supremum) {
@@ -1049,7 +1449,7 @@ func (P *ParseIB) ParsePage(d []byte, pos int, columns []Columns, IsRecovery boo
 			if utils.PageIsComp(d) == 0 {
 				P.IbrecInitOffsetsOld(d, offset, origin, &offsets, uint64(len(columns)))
 			} else {
-				v, ok := P.TableMap[columns[0].TableID]
+				v, ok := P.GetColumnsTable(columns)
 				if !ok {
 					logs.Error("can't find table by field's table id ", columns[0].TableID)
 					break
@@ -1071,7 +1471,14 @@ func (P *ParseIB) ParsePage(d []byte, pos int, columns []Columns, IsRecovery boo
 
 			c, _ = P.ParseRecords(d, origin, offsets, columns)
 			TotalLen += utils.RecOffsSize(&offsets)
-			AllColumns = append(AllColumns, c)
+			TrxId, RollPtr = GetRecordTrx(c)
+			AllRecords = append(AllRecords, Records{
+				Columns: c,
+				State:   P.GetRecordState(d, offset, IsRecovery),
+				PageNo:  utils.MatchReadFrom4(d[4:]),
+				Offset:  offset,
+				TrxId:   TrxId,
+				RollPtr: RollPtr})
 
 		END:
 			// Read the next record, the last 2 bytes store the next page offset.
@@ -1100,7 +1507,31 @@ func (P *ParseIB) ParsePage(d []byte, pos int, columns []Columns, IsRecovery boo
 			break
 		}
 	}
-	return AllColumns
+	return AllRecords
+}
+
+// Get the record state by the deleted flag of the record header info bits.
+// The record on the PAGE_FREE list have been purged, and the record on the
+// normal record list with the deleted flag is waiting for purge.
+// Reference mysql-5.7.19/storage/innobase/include/rem0rec.ic rec_get_deleted_flag
+func (P *ParseIB) GetRecordState(d []byte, offset uint64, IsRecovery bool) string {
+	if IsRecovery {
+		return RecordPurgedFree
+	}
+
+	var InfoBits uint64
+	if utils.PageIsComp(d) == 0 {
+		// #define REC_OLD_INFO_BITS 6
+		InfoBits = utils.MatchReadFrom1(d[offset-6:]) & RecInfoBitsMask
+	} else {
+		// #define REC_NEW_INFO_BITS 5
+		InfoBits = utils.MatchReadFrom1(d[offset-5:]) & RecInfoBitsMask
+	}
+
+	if InfoBits&RecInfoDeletedFlag != 0 {
+		return RecordDeleteMarked
+	}
+	return RecordLive
 }
 
 // Parse cluster index leaf page records, it corresponds to a row of data in the table.
@@ -1109,11 +1540,21 @@ func (P *ParseIB) ParseRecords(d []byte, o []byte, offsets []uint64, columns []C
 
 	var FieldLen uint64
 	for i := 0; i < len(columns); i++ {
+		// The field is not stored in the record, use the instant default value.
+		if utils.RecOffsNthDefault(offsets, i) {
+			if columns[i].InstantDefault == nil {
+				columns[i].FieldValue = nil
+			} else {
+				columns[i].FieldValue = columns[i].InstantDefault
+			}
+			continue
+		}
+
 		// Get field len from offset array.
 		data := utils.RecGetNthField(o, offsets, i, &FieldLen)
 		if uint64(len(data)) < FieldLen {
 			if FieldLen == 0xFFFFFFFF {
-				columns[i].FieldValue = "NULL"
+				columns[i].FieldValue = nil
 			}
 			continue
 		}
@@ -1126,7 +1567,8 @@ func (P *ParseIB) ParseRecords(d []byte, o []byte, offsets []uint64, columns []C
 			logs.Error(err.Error())
 		}
 
-		columns[i].FieldValue = value
+		value = FormatDecimal(&columns[i], data[:FieldLen], value)
+		columns[i].FieldValue = FormatElements(columns[i], value)
 	}
 
 	var c = make([]Columns, len(columns))
@@ -1190,6 +1632,21 @@ func (P *ParseIB) MakeSysIndexesColumns() []Columns {
 	return columns
 }
 
+// Make the sys virtual table column info, the table is created by the
+// InnoDB internal sql, and the values are written without the sign bit flipped.
+// Reference mysql-5.7.19/storage/innobase/dict/dict0crea.cc
+func (P *ParseIB) MakeSysVirtualColumns() []Columns {
+
+	var columns []Columns
+	columns = append(columns, Columns{FieldName: "TABLE_ID", FieldType: 6, FieldPos: 0, FieldLen: 8, IsUnsigned: true})
+	columns = append(columns, Columns{FieldName: "POS", FieldType: 6, FieldPos: 1, FieldLen: 4, IsUnsigned: true})
+	columns = append(columns, Columns{FieldName: "BASE_POS", FieldType: 6, FieldPos: 2, FieldLen: 4, IsUnsigned: true})
+	columns = append(columns, Columns{FieldName: "DB_TRX_ID", FieldType: 1, FieldPos: 3, FieldLen: 6})
+	columns = append(columns, Columns{FieldName: "DB_ROLL_PTR", FieldType: 1, FieldPos: 4, FieldLen: 7})
+
+	return columns
+}
+
 // Make the sys fields table column info
 // Reference mysql-5.7.19/storage/innobase/dict/dict0boot.cc
 func (P *ParseIB) MakeSysFieldsColumns() []Columns {
@@ -1206,6 +1663,9 @@ func (P *ParseIB) MakeSysFieldsColumns() []Columns {
 
 func (P *ParseIB) CheckFieldSize(offsets *[]uint64, columns []Columns) bool {
 	for i := 0; i < len(columns); i++ {
+		if utils.RecOffsNthDefault(*offsets, i) {
+			continue
+		}
 		if utils.GetFixedLength(columns[i].FieldType, columns[i].FieldLen) != 0 {
 			DataLen := utils.RecOffsNthSize(offsets, i);
 			if DataLen == 0 && columns[i].IsNUll {
@@ -1231,12 +1691,6 @@ func (P *ParseIB) GetColumnType(ColumnName string, columns []Columns) uint64 {
 	return 0
 }
 
-// TODO: read table struct info use sql parse,
-//  user should identify the create table sql statement.
-func (P *ParseIB) GetTableFieldsFromStruct() {
-	// TODO: need sql parser
-}
-
 func (P *ParseIB) GetTableColumnsFromDict(DBName string, TableName string) ([]Columns, error) {
 	var columns []Columns
 	for _, table := range P.TableMap {
@@ -1285,10 +1739,11 @@ func (P *ParseIB) MakeCreateTableSql(table Tables) string {
 // You should identify the IsRecovery to confirm
 // whether recovery table data or just read table data.
 func (P *ParseIB) ParseTableData(path string, DBName string, TableName string, IsRecovery bool) error {
-	pages, ParseFileErr := P.ParseFile(path)
+	pages, ParseFileErr := P.ReadTablePages(path)
 	if ParseFileErr != nil {
 		return ParseFileErr
 	}
+	P.File = path
 
 	// Get table fields info from data dict.
 	fields, GetFieldsErr := P.GetTableColumnsFromDict(DBName, TableName)
@@ -1298,6 +1753,10 @@ func (P *ParseIB) ParseTableData(path string, DBName string, TableName string, I
 		return GetFieldsErr
 	}
 
+	// The free pages keep the old records, they are not live rows.
+	FreePages := P.GetFreePages(pages)
+	var FreeRecords []Records
+
 	for _, page := range pages {
 
 		logs.Debug("page.fh.FIL_PAGE_TYPE is ", page.fh.FIL_PAGE_TYPE,
@@ -1305,6 +1764,14 @@ func (P *ParseIB) ParseTableData(path string, DBName string, TableName string, I
 
 		P.ParsePageHeader(&page)
 
+		if FreePages[page.fh.FIL_PAGE_OFFSET] {
+			if page.fh.FIL_PAGE_TYPE == FilPageIndex && page.ph.PAGE_LEVEL == uint64(0) &&
+				page.ph.PAGE_MAX_TRX_ID == uint64(0) {
+				FreeRecords = append(FreeRecords, P.ParseFreePageRecords(page, fields)...)
+			}
+			continue
+		}
+
 		if IsRecovery {
 			// If page have delete data, the page free and garbage should not be zero.
 			if page.ph.PAGE_N_RECS == uint64(0) && page.ph.PAGE_FREE == uint64(0) {
@@ -1328,80 +1795,63 @@ func (P *ParseIB) ParseTableData(path string, DBName string, TableName string, I
 			page.ph.PAGE_LEVEL == uint64(0) &&
 			page.ph.PAGE_MAX_TRX_ID == uint64(0) &&
 			!(IsRecovery && page.ph.PAGE_FREE == 0){
-			AllColumns := P.ParsePage(page.OriginalData, len(page.OriginalData)-len(page.data),
-				fields, IsRecovery, page.ph.PAGE_FREE)
-			if len(AllColumns) == 0 {
-				logs.Info("have no data")
-				continue
-			}
-
-			// Make replace into statement.
-			P.MakeReplaceIntoStatement(AllColumns, TableName, DBName)
-		}
-	}
-	return nil
-}
-
-// Make row data to replace into statement, it will be more convenient when restoring data.
-func (P *ParseIB) MakeReplaceIntoStatement(AllColumns [][]Columns, table string, database string) {
-	var buf bytes.Buffer
-	var query string
-
-	fmt.Println("Print the format sql statement: ")
-
-	for _, columns := range AllColumns {
-
-
-		buf.WriteString(fmt.Sprintf("replace into `%s`.`%s` values (", database, table))
-		firstCol := true
-
-		for _, column := range columns {
-
-			// Skip internal field.
-			if column.FieldName == "DB_ROW_ID" ||
-				column.FieldName == "DB_TRX_ID" ||
-				column.FieldName == "DB_ROLL_PTR" {
-				continue
-			}
-
-			if firstCol {
-				firstCol = false
+			var AllRecords []Records
+			if P.ScanHeap {
+				AllRecords = P.ScanPageHeap(page, fields)
 			} else {
-				buf.WriteByte(',')
+				AllRecords = P.ParsePageRecords(page.OriginalData, len(page.OriginalData)-len(page.data),
+					fields, IsRecovery, page.ph.PAGE_FREE)
 			}
 
-			if column.MySQLType == utils.MYSQL_TYPE_BIT {
-				buf.WriteString("b")
+			// Only keep the delete marked records which have not been purged.
+			if P.DeleteMarkedOnly {
+				var DeleteMarked []Records
+				for _, r := range AllRecords {
+					if r.State == RecordDeleteMarked {
+						DeleteMarked = append(DeleteMarked, r)
+					}
+				}
+				AllRecords = DeleteMarked
 			}
 
-			if column.IsBinary {
-				buf.WriteString("unhex(")
-			}
+			// Filter the records by the transaction id and the plausibility score.
+			AllRecords = P.FilterTrxRecords(AllRecords)
+			AllRecords = P.FilterRecords(AllRecords, TableName, DBName)
 
-			if column.FieldValue != nil && column.FieldValue != "NULL" {
-				buf.WriteByte('\'')
-				buf.WriteString(utils.EscapeValue(fmt.Sprintf("%v", column.FieldValue)))
-				buf.WriteByte('\'')
-			} else {
-				//buf.WriteByte('\'')
-				//buf.WriteByte('\'')
-				buf.WriteString("NULL")
+			if len(AllRecords) == 0 {
+				logs.Info("have no data")
+				continue
 			}
 
-			if column.IsBinary {
-				buf.WriteString(")")
-			}
+			// Write the records in the output format.
+			P.WriteRecords(AllRecords, TableName, DBName)
 		}
+	}
 
-		buf.WriteString(");")
-		query = buf.String()
-		buf.Reset()
-
-		fmt.Println(query)
-		logs.Debug("query is ", query)
-
+	// Report the records on the free pages separately, they are deleted data.
+	FreeRecords = P.FilterTrxRecords(FreeRecords)
+	FreeRecords = P.FilterRecords(FreeRecords, TableName, DBName)
+	if len(FreeRecords) != 0 {
+		logs.Info("write ", len(FreeRecords), " records on the free pages")
+		P.WriteRecords(FreeRecords, TableName, DBName)
 	}
+	return nil
+}
 
+// Make the record label, the state, the transaction id, and the
+// confidence when the record is scored.
+func (P *ParseIB) MakeRecordLabel(r Records) string {
+	label := r.State
+	if r.TrxId != 0 {
+		label += fmt.Sprintf(", trx id %d", r.TrxId)
+	}
+	if r.LSN != 0 {
+		label += fmt.Sprintf(", page lsn %d", r.LSN)
+	}
+	if P.ScoreEnabled() {
+		label += fmt.Sprintf(", confidence %.2f", r.Confidence)
+	}
+	return label
 }
 
 // refrence /root/mysql-5.6.30/storage/innobase/include/rem0rec.ic
@@ -1461,6 +1911,69 @@ func (P *ParseIB) IbrecInitOffsetsOld(d []byte, offset uint64, o []byte, offsets
 	}
 }
 
+// Get which columns are stored in the record and the extra bytes before the
+// null bitmap, MySQL 8.0 instant add/drop column make the record have different
+// columns, the record header info bits tell which format the record use:
+// 1.REC_INFO_INSTANT_FLAG, added by 8.0.12, the field num is stored before the null bitmap.
+// 2.REC_INFO_VERSION_FLAG, added by 8.0.29, the row version is stored before the null bitmap.
+// 3.No flag, the record is inserted before the first instant add column.
+// Reference mysql-8.0.29/storage/innobase/rem/rec.cc rec_init_null_and_len_comp
+func (P *ParseIB) RecGetInstantFields(d []byte, offset uint64, table Tables) ([]bool, uint64, bool) {
+	present := make([]bool, len(table.Columns))
+
+	// #define REC_NEW_INFO_BITS 5
+	InfoBits := utils.MatchReadFrom1(d[offset-5:]) & RecInfoBitsMask
+
+	var ExtraBytes uint64 = 5
+	switch {
+	case InfoBits&RecInfoInstantFlag != 0:
+		// The field num use one byte, or two bytes if the high bit is set.
+		n := utils.MatchReadFrom1(d[offset-6:])
+		ExtraBytes = 6
+		if n&0x80 != 0 {
+			n = (n&0x7F)<<8 | utils.MatchReadFrom1(d[offset-7:])
+			ExtraBytes = 7
+		}
+		if n > uint64(len(table.Columns)) {
+			logs.Error("the instant record field num ", n, " is larger than columns num ", len(table.Columns))
+			return nil, 0, false
+		}
+		for i := uint64(0); i < n; i++ {
+			present[i] = true
+		}
+		return present, ExtraBytes, true
+
+	case InfoBits&RecInfoVersionFlag != 0:
+		version := utils.MatchReadFrom1(d[offset-6:])
+		ExtraBytes = 6
+		for i, c := range table.Columns {
+			present[i] = c.InVersion(version)
+		}
+		return present, ExtraBytes, true
+	}
+
+	for i, c := range table.Columns {
+		if table.InstantCols != 0 {
+			// The table have instant add column by 8.0.12 ~ 8.0.28.
+			present[i] = uint64(i) < table.InstantCols
+		} else {
+			present[i] = c.InVersion(0)
+		}
+	}
+	return present, ExtraBytes, true
+}
+
+// Whether the column is stored in the record which have the row version.
+func (c Columns) InVersion(version uint64) bool {
+	if c.VersionAdded > version {
+		return false
+	}
+	if c.VersionDropped != 0 && c.VersionDropped <= version {
+		return false
+	}
+	return true
+}
+
 // When MySQL innodb Storage use COMPACT row format, use this method
 // to calculate offset array which store column start offset and length.
 func (P *ParseIB) IbrecInitOffsetsNew(d []byte, offset uint64, o []byte, offsets *[]uint64, table Tables) bool {
@@ -1470,14 +1983,34 @@ func (P *ParseIB) IbrecInitOffsetsNew(d []byte, offset uint64, o []byte, offsets
 	// have two internal fields.
 	(*offsets)[1] = uint64(len(table.Columns))
 
+	// MySQL 8.0 instant add/drop column, the record may not store all columns.
+	present, ExtraBytes, ok := P.RecGetInstantFields(d, offset, table)
+	if !ok {
+		return false
+	}
+
+	NullCount := 0
+	for i := 0; i < len(table.Columns); i++ {
+		if present[i] && table.Columns[i].IsNUll {
+			NullCount++
+		}
+	}
+
 	var offs uint64
 	// nulls = rec - (REC_N_NEW_EXTRA_BYTES + 1);
-	nulls := d[(len(d) - len(o) - (5 + 1)):]
-	lens := d[(len(d) - len(nulls) - (table.NullCount+7)/8):]
+	nulls := d[(len(d) - len(o) - int(ExtraBytes + 1)):]
+	lens := d[(len(d) - len(nulls) - (NullCount+7)/8):]
 	offs = 0
 	NullMask := 1
 	for i := 0; i < len(table.Columns); i++ {
 		var length uint64
+		if !present[i] {
+			// The field is not stored in the record, use the instant default value.
+			// #define REC_OFFS_DEFAULT	((ulint) 1 << 29)
+			length = offs | (1 << 29)
+			goto OUT
+		}
+
 		if table.Columns[i].IsNUll {
 
 			if byte(NullMask) == 0 {
@@ -1502,7 +2035,10 @@ func (P *ParseIB) IbrecInitOffsetsNew(d []byte, offset uint64, o []byte, offsets
 			//      if (debug) printf("Variable-length field: read the length\n");
 			/* Variable-length field: read the length */
 
-			if table.Columns[i].FieldLen > 255 || table.Columns[i].FieldType == utils.DATA_BLOB {
+			// Reference MySQL DATA_BIG_COL macro.
+			if table.Columns[i].FieldLen > 255 || table.Columns[i].FieldType == utils.DATA_BLOB ||
+				table.Columns[i].FieldType == utils.DATA_GEOMETRY ||
+				table.Columns[i].FieldType == utils.DATA_VAR_POINT {
 
 				if length&0x80 != 0 {
 
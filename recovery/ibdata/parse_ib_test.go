@@ -0,0 +1,96 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The carved page file have two versions of the same leaf page.
+func TestParseFileKeepPageVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parse_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var d []byte
+	for _, lsn := range []uint64{100, 200, 200} {
+		page := MakeTestPage(FilPageIndex, nil)
+		binary.BigEndian.PutUint64(page[16:], lsn)
+		d = append(d, page...)
+	}
+	path := filepath.Join(dir, "pages")
+	if err := ioutil.WriteFile(path, d, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	P := NewParseIB()
+	pages, err := P.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Errorf("read %d pages, expected 1", len(pages))
+	}
+
+	P.KeepPageVersions = true
+	pages, err = P.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("read %d page versions, expected 2", len(pages))
+	}
+}
+
+// The purged dict record may be overwritten, its values can't be decoded.
+func TestGetDictValues(t *testing.T) {
+	columns := []Columns{{FieldValue: uint64(10)}, {FieldValue: nil}, {FieldValue: "t1"}}
+	if values, ok := GetDictUints(columns, 0); !ok || values[0] != 10 {
+		t.Errorf("get %v %v, expected [10] true", values, ok)
+	}
+	if _, ok := GetDictUints(columns, 0, 1); ok {
+		t.Error("the NULL value is decoded as uint64")
+	}
+	if _, ok := GetDictUints(columns, 3); ok {
+		t.Error("the column out of the record is decoded")
+	}
+	if name, ok := GetDictString(columns, 2); !ok || name != "t1" {
+		t.Errorf("get %s %v, expected t1 true", name, ok)
+	}
+}
+
+func TestGetIndexIdState(t *testing.T) {
+	P := NewParseIB()
+	P.TableMap[10] = Tables{DBName: "test", TableName: "t1",
+		Indexes: map[uint64]Indexes{100: {Id: 100, Name: "PRIMARY"}}}
+	P.TableMap[11] = Tables{DBName: "test", TableName: "t2",
+		Indexes: map[uint64]Indexes{101: {Id: 101, Name: "PRIMARY"}, 102: {Id: 102, Name: "k1"}}}
+
+	for id, expected := range map[uint64]string{
+		100: "current PRIMARY",
+		102: "owned by test.t2 k1",
+		99:  "stale",
+	} {
+		if state := P.GetIndexIdState(P.TableMap[10], id); state != expected {
+			t.Errorf("the state of index id %d is %s, expected %s", id, state, expected)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Get the table which the index belong to.
+func (P *ParseIB) GetTableByIndexId(IndexId uint64) (Tables, bool) {
+	for _, table := range P.TableMap {
+		if _, ok := table.Indexes[IndexId]; ok {
+			return table, true
+		}
+	}
+	return Tables{}, false
+}
+
+// Read the leaf pages of the index from the data files, and decode them with
+// the table struct. When the dropped table lived in the system tablespace,
+// its index pages remain in ibdata1 until they are reused, so scan all pages
+// of the ibdata1 or the pages carved from the disk which have the index id.
+// The table struct is found by the index id first, it may be read from the
+// purged dict records, and then by the database name and table name.
+func (P *ParseIB) ParseIndexData(paths []string, IndexId uint64, DBName string, TableName string, IsRecovery bool) error {
+
+	table, ok := P.GetTableByIndexId(IndexId)
+	if !ok {
+		var err error
+		table, err = P.GetTableFromDict(DBName, TableName)
+		if err != nil {
+			ErrMsg := fmt.Sprintf("can't find the table struct by index id %d or table name %s.%s",
+				IndexId, DBName, TableName)
+			logs.Error(ErrMsg)
+			return fmt.Errorf(ErrMsg)
+		}
+	} else {
+		logs.Info("index id ", IndexId, " belong to table ", table.DBName, ".", table.TableName)
+	}
+
+	if len(table.Columns) == 0 {
+		ErrMsg := fmt.Sprintf("the table of index id %d have no columns", IndexId)
+		logs.Error(ErrMsg)
+		return fmt.Errorf(ErrMsg)
+	}
+
+	if DBName == "" {
+		DBName = table.DBName
+	}
+	if TableName == "" {
+		TableName = table.TableName
+	}
+
+	// The pages carved from the disk may have several versions of the same
+	// leaf page, don't skip them by the page number.
+	P.KeepPageVersions = true
+	defer func() { P.KeepPageVersions = false }()
+
+	for _, path := range paths {
+		pages, ParseFileErr := P.ReadTablePages(path)
+		if ParseFileErr != nil {
+			return ParseFileErr
+		}
+		P.File = path
+
+		// The free pages keep the old records, the pages carved from the
+		// disk have no extent descriptor, none of them is free.
+		FreePages := P.GetFreePages(pages)
+		var FreeRecords []Records
+
+		for _, page := range pages {
+			if page.fh.FIL_PAGE_TYPE != FilPageIndex || page.ph.PAGE_INDEX_ID != IndexId {
+				continue
+			}
+
+			P.ParsePageHeader(&page)
+			if page.ph.PAGE_LEVEL != 0 {
+				continue
+			}
+			if FreePages[page.fh.FIL_PAGE_OFFSET] {
+				FreeRecords = append(FreeRecords, P.ParseFreePageRecords(page, table.Columns)...)
+				continue
+			}
+			if IsRecovery && (page.ph.PAGE_FREE == 0 || page.ph.PAGE_FREE > uint64(DefaultPageSize)) {
+				continue
+			}
+
+			logs.Debug("found index ", IndexId, " leaf page ", page.fh.FIL_PAGE_OFFSET, " in ", path)
+
+			AllRecords := P.ParsePageRecords(page.OriginalData, len(page.OriginalData)-len(page.data),
+				table.Columns, IsRecovery, page.ph.PAGE_FREE)
+
+			// Filter the records by the transaction id and the plausibility score.
+			AllRecords = P.FilterTrxRecords(AllRecords)
+			AllRecords = P.FilterRecords(AllRecords, TableName, DBName)
+			if len(AllRecords) == 0 {
+				continue
+			}
+
+			// Write the records in the output format.
+			P.WriteRecords(AllRecords, TableName, DBName)
+		}
+
+		// Report the records on the free pages separately, the same as ParseTableData.
+		FreeRecords = P.FilterTrxRecords(FreeRecords)
+		FreeRecords = P.FilterRecords(FreeRecords, TableName, DBName)
+		if len(FreeRecords) != 0 {
+			logs.Info("write ", len(FreeRecords), " records on the free pages of ", path)
+			P.WriteRecords(FreeRecords, TableName, DBName)
+		}
+	}
+	return nil
+}
+
+// Store the pages info of an index id found in the data files.
+type IndexPages struct {
+	IndexId   uint64
+	Pages     uint64
+	LeafPages uint64
+	Records   uint64
+
+	// The index is current of the table, owned by the other table, or stale.
+	State string
+}
+
+// Group the index pages of the data files by PAGE_INDEX_ID, and print which
+// index ids are not the table's current ones or the other tables' ones. After TRUNCATE TABLE, InnoDB
+// create the new index id, but the old leaf pages in the freed extents or in
+// the old file copy still have the previous index id, decode them with the
+// ParseIndexData to get the rows before truncate.
+func (P *ParseIB) ListIndexIds(paths []string, DBName string, TableName string) ([]IndexPages, error) {
+
+	table, err := P.GetTableFromDict(DBName, TableName)
+	if err != nil {
+		logs.Error("get table from dict failed, the error is ", err,
+			" the db name is ", DBName, " the table name is ", TableName)
+		return nil, err
+	}
+
+	var ids []uint64
+	IndexMap := make(map[uint64]*IndexPages)
+	for _, path := range paths {
+		pages, ParseFileErr := P.ReadTablePages(path)
+		if ParseFileErr != nil {
+			return nil, ParseFileErr
+		}
+
+		for _, page := range pages {
+			if page.fh.FIL_PAGE_TYPE != FilPageIndex {
+				continue
+			}
+			P.ParsePageHeader(&page)
+
+			ip, ok := IndexMap[page.ph.PAGE_INDEX_ID]
+			if !ok {
+				ip = &IndexPages{IndexId: page.ph.PAGE_INDEX_ID}
+				IndexMap[page.ph.PAGE_INDEX_ID] = ip
+				ids = append(ids, page.ph.PAGE_INDEX_ID)
+			}
+			ip.Pages++
+			if page.ph.PAGE_LEVEL == 0 {
+				ip.LeafPages++
+				ip.Records += page.ph.PAGE_N_RECS
+			}
+		}
+	}
+
+	var AllIndexPages []IndexPages
+	fmt.Println("Print the index ids found in the data file: ")
+	for _, id := range ids {
+		ip := IndexMap[id]
+		ip.State = P.GetIndexIdState(table, id)
+		AllIndexPages = append(AllIndexPages, *ip)
+
+		fmt.Println(fmt.Sprintf("index id: %d, pages: %d, leaf pages: %d, records: %d, state: %s",
+			ip.IndexId, ip.Pages, ip.LeafPages, ip.Records, ip.State))
+	}
+	return AllIndexPages, nil
+}
+
+// Get the state of the index id. The file may have the pages of the other
+// tables, such as the ibdata1 or the carved file, they are not stale, only
+// the index id which no table own in the data dictionary is stale.
+func (P *ParseIB) GetIndexIdState(table Tables, id uint64) string {
+	if idx, ok := table.Indexes[id]; ok {
+		return fmt.Sprintf("current %s", idx.Name)
+	}
+	for _, t := range P.TableMap {
+		if idx, ok := t.Indexes[id]; ok {
+			return fmt.Sprintf("owned by %s.%s %s", t.DBName, t.TableName, idx.Name)
+		}
+	}
+	return "stale"
+}
@@ -0,0 +1,547 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The SDI record type of the table, the other type is the tablespace.
+// Reference mysql-8.0.29/sql/dd/sdi_fwd.h
+const SdiTypeTable uint64 = 1
+
+// Store the table definition serialized in the SDI, only the fields
+// which are used to parse the records are read.
+// Reference mysql-8.0.29/sql/dd/impl/sdi.cc
+type SDITables struct {
+	DDObjectType string    `json:"dd_object_type"`
+	DDObject     SDIObject `json:"dd_object"`
+}
+
+// Store the dd::Table object.
+type SDIObject struct {
+	Name          string       `json:"name"`
+	SchemaRef     string       `json:"schema_ref"`
+	SePrivateId   uint64       `json:"se_private_id"`
+	SePrivateData string       `json:"se_private_data"`
+	Columns       []SDIColumns `json:"columns"`
+	Indexes       []SDIIndexes `json:"indexes"`
+}
+
+// Store the dd::Column object, the se_private_data have the instant
+// column info, such as default=616263;version_added=1;physical_pos=5;
+type SDIColumns struct {
+	Name              string        `json:"name"`
+	Type              uint64        `json:"type"`
+	IsNullable        bool          `json:"is_nullable"`
+	IsUnsigned        bool          `json:"is_unsigned"`
+	IsVirtual         bool          `json:"is_virtual"`
+	CharLength        uint64        `json:"char_length"`
+	NumericPrecision  uint64        `json:"numeric_precision"`
+	NumericScale      uint64        `json:"numeric_scale"`
+	DatetimePrecision uint64        `json:"datetime_precision"`
+	SePrivateData     string        `json:"se_private_data"`
+	CollationId       uint64        `json:"collation_id"`
+	Elements          []SDIElements `json:"elements"`
+}
+
+// Store the ENUM/SET label, the name is serialized as base64.
+type SDIElements struct {
+	Name  []byte `json:"name"`
+	Index uint64 `json:"index"`
+}
+
+// Store the dd::Index object, the InnoDB add all columns of the table
+// to the PRIMARY index as the hidden elements.
+type SDIIndexes struct {
+	Name          string             `json:"name"`
+	Hidden        bool               `json:"hidden"`
+	Type          uint64             `json:"type"`
+	SePrivateData string             `json:"se_private_data"`
+	Elements      []SDIIndexElements `json:"elements"`
+}
+
+// Store the dd::Index_element object, the column_opx is the column
+// position in the table columns.
+type SDIIndexElements struct {
+	Length    uint64 `json:"length"`
+	Hidden    bool   `json:"hidden"`
+	ColumnOpx uint64 `json:"column_opx"`
+}
+
+// Read the tables from the SDI of the MySQL 8.0 data file, and put them
+// into the TableMap. The SDI have the instant ADD/DROP column versions
+// and default values, which the data dictionary don't have, so the table
+// of the same name read from the data dictionary is replaced.
+func (P *ParseIB) LoadSDITables(path string) error {
+	pages, err := P.ParseFile(path)
+	if err != nil {
+		return err
+	}
+
+	for TableId, table := range P.GetSDITables(pages) {
+		for id, t := range P.TableMap {
+			if t.DBName == table.DBName && t.TableName == table.TableName {
+				delete(P.TableMap, id)
+			}
+		}
+		logs.Info("read table ", table.DBName, ".", table.TableName, " from the SDI of ", path)
+		P.TableMap[TableId] = table
+	}
+	return nil
+}
+
+// Get the tables from the SDI leaf pages, the key is the table id.
+func (P *ParseIB) GetSDITables(pages []Page) map[uint64]Tables {
+	BlobPages := make(map[uint64][]byte)
+	for _, page := range pages {
+		if page.fh.FIL_PAGE_TYPE == FilPageSdiBlob {
+			BlobPages[page.fh.FIL_PAGE_OFFSET] = page.OriginalData
+		}
+	}
+
+	tables := make(map[uint64]Tables)
+	for _, page := range pages {
+		P.ParsePageHeader(&page)
+		if page.fh.FIL_PAGE_TYPE != FilPageSdi || page.ph.PAGE_LEVEL != 0 {
+			continue
+		}
+
+		for _, data := range P.ReadSDIRecords(page.OriginalData, BlobPages) {
+			var sdi SDITables
+			if err := json.Unmarshal(data, &sdi); err != nil {
+				logs.Warn("decode the SDI failed, the error is ", err)
+				continue
+			}
+
+			TableId, table, err := MakeSDITable(sdi.DDObject)
+			if err != nil {
+				logs.Warn("make the table from the SDI failed, the error is ", err)
+				continue
+			}
+			tables[TableId] = table
+		}
+	}
+	return tables
+}
+
+// Read the uncompressed SDI of the tables on the page, the delete marked
+// records are the old versions, skip them.
+// Reference mysql-8.0.29/storage/innobase/api/api0misc.cc
+func (P *ParseIB) ReadSDIRecords(d []byte, BlobPages map[uint64][]byte) [][]byte {
+	var records [][]byte
+
+	offset := PageNewInfimum
+	for n := 0; n < len(d); n++ {
+		// The next record offset is relative, it wraps around the page size.
+		next := utils.MatchReadFrom2(d[offset-2:])
+		offset = (offset + next) % uint64(len(d))
+		if next == 0 || offset == PageNewSupremum {
+			break
+		}
+		if offset < PageNewSupremum || offset+SdiRecOffData > uint64(len(d)) {
+			logs.Warn("the SDI record offset ", offset, " is out of the page")
+			break
+		}
+
+		if utils.MatchReadFrom1(d[offset-5:])&RecInfoDeletedFlag != 0 ||
+			utils.MatchReadFrom4(d[offset+SdiRecOffType:]) != SdiTypeTable {
+			continue
+		}
+
+		data, err := ReadSDIData(d, offset, BlobPages)
+		if err != nil {
+			logs.Warn("read the SDI ", utils.MatchReadFrom8(d[offset+SdiRecOffId:]),
+				" failed, the error is ", err)
+			continue
+		}
+		records = append(records, data)
+	}
+	return records
+}
+
+// Read the data field of the SDI record and uncompress it. The data is the
+// only variable length field, all fields are NOT NULL, so the length is just
+// before the record header. It is stored off page when it is too long.
+func ReadSDIData(d []byte, offset uint64, BlobPages map[uint64][]byte) ([]byte, error) {
+	length := utils.MatchReadFrom1(d[offset-6:])
+	external := false
+	if length&0x80 != 0 {
+		external = length&0x40 != 0
+		length = (length&0x3F)<<8 | utils.MatchReadFrom1(d[offset-7:])
+	}
+
+	start := offset + SdiRecOffData
+	if start+length > uint64(len(d)) {
+		return nil, fmt.Errorf("the SDI length %d is out of the page", length)
+	}
+	data := append([]byte{}, d[start:start+length]...)
+
+	if external {
+		// #define BTR_EXTERN_FIELD_REF_SIZE 20, the space id, page no,
+		// offset and length of the off page data.
+		if length < 20 {
+			return nil, fmt.Errorf("the SDI external reference length %d is too short", length)
+		}
+		ref := data[length-20:]
+		data = data[:length-20]
+
+		PageNo := utils.MatchReadFrom4(ref[4:])
+		for n := 0; PageNo != FilNull; n++ {
+			page, ok := BlobPages[PageNo]
+			if !ok || n > len(BlobPages) {
+				return nil, fmt.Errorf("the SDI blob page %d have not found", PageNo)
+			}
+			PartLen := utils.MatchReadFrom4(page[BtrBlobHdrPartLen:])
+			if BtrBlobHdrData+PartLen > uint64(len(page)) {
+				return nil, fmt.Errorf("the SDI blob page %d part length %d is out of the page", PageNo, PartLen)
+			}
+			data = append(data, page[BtrBlobHdrData:BtrBlobHdrData+PartLen]...)
+			PageNo = utils.MatchReadFrom4(page[BtrBlobHdrNextPage:])
+		}
+	}
+
+	CompLen := utils.MatchReadFrom4(d[offset+SdiRecOffCompLen:])
+	UncompLen := utils.MatchReadFrom4(d[offset+SdiRecOffUncompLen:])
+	if CompLen > uint64(len(data)) {
+		return nil, fmt.Errorf("the SDI compressed length %d is larger than the data length %d", CompLen, len(data))
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(data[:CompLen]))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	sdi, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(sdi)) != UncompLen {
+		return nil, fmt.Errorf("the SDI uncompressed length %d is not %d", len(sdi), UncompLen)
+	}
+	return sdi, nil
+}
+
+// Parse the se_private_data, such as default=616263;version_added=1;
+func ParseSePrivateData(s string) map[string]string {
+	values := make(map[string]string)
+	for _, kv := range strings.Split(s, ";") {
+		if pos := strings.Index(kv, "="); pos > 0 {
+			values[kv[:pos]] = kv[pos+1:]
+		}
+	}
+	return values
+}
+
+// Make the table from the dd::Table. The record columns are the PRIMARY
+// index elements, which are sorted by the physical position since 8.0.29,
+// because the instant added columns are stored at the end of the record
+// and the instant dropped columns are still stored in the old records.
+// Reference mysql-8.0.29/storage/innobase/dict/dict0dd.cc dd_fill_dict_index
+func MakeSDITable(o SDIObject) (uint64, Tables, error) {
+	TableId := o.SePrivateId
+	table := Tables{DBName: o.SchemaRef, TableName: o.Name, Indexes: make(map[uint64]Indexes)}
+
+	columns := make([]Columns, len(o.Columns))
+	for i, sc := range o.Columns {
+		c, err := MakeSDIColumn(sc, TableId)
+		if err != nil {
+			return 0, table, err
+		}
+		columns[i] = c
+	}
+
+	var order []uint64
+	for _, idx := range o.Indexes {
+		IndexData := ParseSePrivateData(idx.SePrivateData)
+		IndexId, _ := strconv.ParseUint(IndexData["id"], 10, 64)
+
+		index := Indexes{Id: IndexId, Name: idx.Name}
+		switch idx.Type {
+		case DDIndexPrimary:
+			index.IndexType = DictClustered | DictUnique
+			if idx.Hidden {
+				// The table have no primary key, the cluster index is on the DB_ROW_ID.
+				index.Name = "GEN_CLUST_INDEX"
+				index.IndexType = DictClustered
+			}
+			for _, e := range idx.Elements {
+				order = append(order, e.ColumnOpx)
+			}
+			table.SpaceId, _ = strconv.ParseUint(IndexData["space_id"], 10, 64)
+		case DDIndexUnique:
+			index.IndexType = DictUnique
+		case DDIndexFulltext:
+			index.IndexType = DictFts
+		case DDIndexSpatial:
+			index.IndexType = DictSpatial
+		}
+
+		for _, e := range idx.Elements {
+			if e.Hidden || idx.Hidden || e.ColumnOpx >= uint64(len(columns)) {
+				continue
+			}
+			f := &Fields{ColumnPos: uint64(len(index.Fields)), ColumnName: columns[e.ColumnOpx].FieldName}
+			if IsPrefixElement(columns[e.ColumnOpx], e) {
+				f.PrefixLen = e.Length
+			}
+			index.Fields = append(index.Fields, f)
+		}
+		index.FieldNum = uint64(len(index.Fields))
+		table.Indexes[IndexId] = index
+	}
+	if len(order) == 0 {
+		return 0, table, fmt.Errorf("table %s.%s have no PRIMARY index in the SDI", o.SchemaRef, o.Name)
+	}
+
+	// The physical position of the columns, the table which have no instant
+	// column added or dropped since 8.0.29 don't have it.
+	PhysicalPos := make(map[uint64]uint64)
+	for _, opx := range order {
+		if opx >= uint64(len(columns)) {
+			return 0, table, fmt.Errorf("the PRIMARY index element column %d is out of the columns", opx)
+		}
+		pos, err := strconv.ParseUint(ParseSePrivateData(o.Columns[opx].SePrivateData)["physical_pos"], 10, 64)
+		if err != nil {
+			break
+		}
+		PhysicalPos[opx] = pos
+	}
+	if len(PhysicalPos) == len(order) {
+		sort.SliceStable(order, func(i, j int) bool {
+			return PhysicalPos[order[i]] < PhysicalPos[order[j]]
+		})
+	}
+
+	var SysCols uint64
+	for _, opx := range order {
+		c := columns[opx]
+		c.FieldPos = uint64(len(table.Columns))
+		if c.FieldType == utils.DATA_SYS {
+			SysCols++
+		}
+		if c.IsNUll {
+			table.NullCount++
+		}
+		table.Columns = append(table.Columns, c)
+	}
+	for _, c := range columns {
+		if c.IsVirtual {
+			table.VirtualColumns = append(table.VirtualColumns, c)
+		}
+	}
+
+	// The instant_col is the user columns num before the first instant
+	// add column by 8.0.12 ~ 8.0.28, the record have the system columns too.
+	TableData := ParseSePrivateData(o.SePrivateData)
+	if v, ok := TableData["instant_col"]; ok {
+		InstantCols, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, table, fmt.Errorf("the instant_col %s is invalid", v)
+		}
+		table.InstantCols = InstantCols + SysCols
+	}
+	return TableId, table, nil
+}
+
+// Whether the index element is the column prefix, only the string and the
+// BLOB column can have the prefix which is shorter than the column bytes.
+// Reference mysql-8.0.29/sql/dd/impl/types/index_element_impl.cc is_prefix
+func IsPrefixElement(c Columns, e SDIIndexElements) bool {
+	switch c.FieldType {
+	case utils.DATA_VARCHAR, utils.DATA_CHAR, utils.DATA_VARMYSQL, utils.DATA_MYSQL,
+		utils.DATA_BINARY, utils.DATA_FIXBINARY, utils.DATA_BLOB:
+	default:
+		return false
+	}
+	if c.FieldType == utils.DATA_FIXBINARY && c.MySQLType != utils.MYSQL_TYPE_STRING {
+		return false
+	}
+	return e.Length < c.MaxLen
+}
+
+// Make the column from the dd::Column, the InnoDB type is the same as the
+// MySQL 5.7 data dictionary stored in SYS_COLUMNS.
+// Reference mysql-8.0.29/storage/innobase/handler/ha_innodb.cc get_innobase_type_from_mysql_type
+func MakeSDIColumn(sc SDIColumns, TableId uint64) (Columns, error) {
+	c := Columns{
+		FieldName:  sc.Name,
+		FieldLen:   sc.CharLength,
+		IsNUll:     sc.IsNullable,
+		IsUnsigned: sc.IsUnsigned,
+		TableID:    TableId,
+		IsVirtual:  sc.IsVirtual,
+		Charset:    sc.CollationId,
+		MaxLen:     sc.CharLength}
+	IsBinary := sc.CollationId == BinaryCollationId
+
+	// The fractional seconds use (fsp + 1) / 2 bytes.
+	FracLen := (sc.DatetimePrecision + 1) / 2
+
+	switch sc.Name {
+	case "DB_ROW_ID", "DB_TRX_ID":
+		c.FieldType, c.FieldLen = utils.DATA_SYS, 6
+		return c, nil
+	case "DB_ROLL_PTR":
+		c.FieldType, c.FieldLen = utils.DATA_SYS, 7
+		return c, nil
+	}
+
+	switch sc.Type {
+	case DDTypeTiny:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_INT, utils.MYSQL_TYPE_TINY, 1
+	case DDTypeShort:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_INT, utils.MYSQL_TYPE_SHORT, 2
+	case DDTypeInt24:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_INT, utils.MYSQL_TYPE_INT24, 3
+	case DDTypeLong:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_INT, utils.MYSQL_TYPE_LONG, 4
+	case DDTypeLonglong:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_INT, utils.MYSQL_TYPE_LONGLONG, 8
+	case DDTypeYear:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_INT, utils.MYSQL_TYPE_YEAR, 1
+	case DDTypeDate, DDTypeNewdate:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_INT, utils.MYSQL_TYPE_DATE, 3
+	case DDTypeFloat:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_FLOAT, utils.MYSQL_TYPE_FLOAT, 4
+	case DDTypeDouble:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_DOUBLE, utils.MYSQL_TYPE_DOUBLE, 8
+	case DDTypeTime2:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_FIXBINARY, utils.MYSQL_TYPE_TIME, 3+FracLen
+	case DDTypeDatetime2:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_FIXBINARY, utils.MYSQL_TYPE_DATETIME, 5+FracLen
+	case DDTypeTimestamp2:
+		c.FieldType, c.MySQLType, c.FieldLen = utils.DATA_FIXBINARY, utils.MYSQL_TYPE_TIMESTAMP, 4+FracLen
+	case DDTypeNewdecimal:
+		c.FieldType, c.MySQLType = utils.DATA_FIXBINARY, utils.MYSQL_TYPE_NEWDECIMAL
+		c.Precision, c.Scale = int(sc.NumericPrecision), int(sc.NumericScale)
+		c.FieldLen = DecimalBinSize(c.Precision, c.Scale)
+	case DDTypeBit:
+		c.FieldType, c.MySQLType = utils.DATA_FIXBINARY, utils.MYSQL_TYPE_BIT
+		c.Precision = int(sc.NumericPrecision)
+		c.FieldLen = (sc.NumericPrecision + 7) / 8
+
+	case DDTypeEnum, DDTypeSet:
+		// The ENUM and SET are stored as the unsigned integer.
+		c.FieldType, c.IsUnsigned = utils.DATA_INT, true
+		for _, e := range sc.Elements {
+			c.Elements = append(c.Elements, string(e.Name))
+		}
+		if sc.Type == DDTypeEnum {
+			c.MySQLType, c.FieldLen = utils.MYSQL_TYPE_ENUM, 1
+			if len(c.Elements) > 255 {
+				c.FieldLen = 2
+			}
+		} else {
+			c.MySQLType, c.FieldLen = utils.MYSQL_TYPE_SET, uint64(len(c.Elements)+7)/8
+			switch {
+			case c.FieldLen == 3:
+				c.FieldLen = 4
+			case c.FieldLen > 4:
+				c.FieldLen = 8
+			}
+		}
+
+	case DDTypeVarchar, DDTypeVarString:
+		c.MySQLType = utils.MYSQL_TYPE_VARCHAR
+		switch {
+		case IsBinary:
+			// The FieldLen of the DATA_BINARY column is 0 to parse it as the string.
+			c.FieldType, c.FieldLen = utils.DATA_BINARY, 0
+		case sc.CollationId == Latin1CollationId:
+			c.FieldType = utils.DATA_VARCHAR
+		default:
+			c.FieldType = utils.DATA_VARMYSQL
+		}
+	case DDTypeString:
+		c.MySQLType = utils.MYSQL_TYPE_STRING
+		switch {
+		case IsBinary:
+			c.FieldType = utils.DATA_FIXBINARY
+		case sc.CollationId == Latin1CollationId:
+			c.FieldType = utils.DATA_CHAR
+		default:
+			c.FieldType = utils.DATA_MYSQL
+		}
+
+	case DDTypeTinyBlob, DDTypeBlob, DDTypeMediumBlob, DDTypeLongBlob:
+		c.FieldType, c.MySQLType, c.IsBinary = utils.DATA_BLOB, utils.MYSQL_TYPE_BLOB, IsBinary
+	case DDTypeJson:
+		c.FieldType, c.MySQLType, c.IsBinary = utils.DATA_BLOB, utils.MYSQL_TYPE_JSON, true
+	case DDTypeGeometry:
+		c.FieldType, c.MySQLType = utils.DATA_GEOMETRY, utils.MYSQL_TYPE_GEOMETRY
+
+	default:
+		return c, fmt.Errorf("the column %s type %d is not supported", sc.Name, sc.Type)
+	}
+
+	// The instant column info, the dropped column is renamed to
+	// !hidden!_dropped_v<version>_p<pos>_<name>.
+	// Reference mysql-8.0.29/storage/innobase/include/dict0dd.h
+	ColumnData := ParseSePrivateData(sc.SePrivateData)
+	c.VersionAdded, _ = strconv.ParseUint(ColumnData["version_added"], 10, 64)
+	c.VersionDropped, _ = strconv.ParseUint(ColumnData["version_dropped"], 10, 64)
+	if v, ok := ColumnData["default"]; ok {
+		value, err := MakeInstantDefault(&c, v)
+		if err != nil {
+			return c, err
+		}
+		c.InstantDefault = value
+	}
+	return c, nil
+}
+
+// Decode the instant default value, it is the hex of the value stored in
+// the record, decode it as the record field.
+func MakeInstantDefault(c *Columns, value string) (interface{}, error) {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("the column %s default value %s is not hex", c.FieldName, value)
+	}
+
+	FixLength := utils.GetFixedLength(c.FieldType, c.FieldLen)
+	if FixLength != 0 && uint64(len(data)) != FixLength {
+		return nil, fmt.Errorf("the column %s default value length %d is not %d",
+			c.FieldName, len(data), FixLength)
+	}
+
+	v, err := utils.ParseData(c.FieldType, c.MySQLType, data, uint64(len(data)),
+		int(FixLength), c.IsUnsigned, &c.IsBinary)
+	if err != nil {
+		return nil, err
+	}
+	v = FormatDecimal(c, data, v)
+	return FormatElements(*c, v), nil
+}
+
+// Get the bytes of the DECIMAL value, every 9 digits use 4 bytes,
+// and the left digits use the DigitsToBytes bytes.
+// Reference mysql-8.0.29/strings/decimal.cc decimal_bin_size
+func DecimalBinSize(precision int, scale int) uint64 {
+	intg := precision - scale
+	return uint64(intg/9*4 + utils.DigitsToBytes[intg%9] + scale/9*4 + utils.DigitsToBytes[scale%9])
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// The SDI of the table below, it is created by 8.0.29 and then:
+// CREATE TABLE t1 (id INT PRIMARY KEY, c1 INT, c3 INT NOT NULL, KEY k1 (c1));
+// ALTER TABLE t1 ADD COLUMN c2 VARCHAR(20) DEFAULT 'abc', ALGORITHM=INSTANT;
+// ALTER TABLE t1 DROP COLUMN c3, ALGORITHM=INSTANT;
+const TestSDI = `{"mysqld_version_id":80029,"dd_version":80023,"sdi_version":80019,
+"dd_object_type":"Table","dd_object":{"name":"t1","schema_ref":"test",
+"se_private_id":1065,"se_private_data":"autoinc=0;version=0;",
+"columns":[
+{"name":"id","type":4,"is_nullable":false,"hidden":1,"char_length":11,
+ "se_private_data":"physical_pos=0;table_id=1065;","collation_id":255,"elements":[]},
+{"name":"c1","type":4,"is_nullable":true,"hidden":1,"char_length":11,
+ "se_private_data":"physical_pos=3;table_id=1065;","collation_id":255,"elements":[]},
+{"name":"c2","type":16,"is_nullable":true,"hidden":1,"char_length":80,
+ "se_private_data":"default=616263;physical_pos=5;table_id=1065;version_added=1;",
+ "collation_id":255,"elements":[]},
+{"name":"DB_TRX_ID","type":10,"is_nullable":false,"hidden":2,"char_length":6,
+ "se_private_data":"physical_pos=1;table_id=1065;","collation_id":63,"elements":[]},
+{"name":"DB_ROLL_PTR","type":9,"is_nullable":false,"hidden":2,"char_length":7,
+ "se_private_data":"physical_pos=2;table_id=1065;","collation_id":63,"elements":[]},
+{"name":"!hidden!_dropped_v2_p4_c3","type":4,"is_nullable":false,"hidden":2,"char_length":11,
+ "se_private_data":"physical_pos=4;table_id=1065;version_dropped=2;","collation_id":255,"elements":[]}],
+"indexes":[
+{"name":"PRIMARY","hidden":false,"type":1,
+ "se_private_data":"id=150;root=4;space_id=3;table_id=1065;trx_id=1790;",
+ "elements":[{"length":4,"hidden":false,"column_opx":0},{"length":4294967295,"hidden":true,"column_opx":3},
+  {"length":4294967295,"hidden":true,"column_opx":4},{"length":4294967295,"hidden":true,"column_opx":1},
+  {"length":4294967295,"hidden":true,"column_opx":2},{"length":4294967295,"hidden":true,"column_opx":5}]},
+{"name":"k1","hidden":false,"type":3,
+ "se_private_data":"id=151;root=5;space_id=3;table_id=1065;trx_id=1790;",
+ "elements":[{"length":4,"hidden":false,"column_opx":1},{"length":4294967295,"hidden":true,"column_opx":0}]}]}}`
+
+// Make a COMPACT index page, the records are the extra bytes before the
+// record header and the data after it, they are linked in the order.
+func MakeTestPage(PageType uint64, records [][2][]byte) []byte {
+	d := make([]byte, DefaultPageSize)
+	binary.BigEndian.PutUint32(d[4:], 4)
+	binary.BigEndian.PutUint16(d[24:], uint16(PageType))
+
+	// The PAGE_N_HEAP with the compact flag.
+	binary.BigEndian.PutUint16(d[38+4:], uint16(0x8000|(2+len(records))))
+
+	prev := PageNewInfimum
+	offset := uint64(128)
+	for i, r := range records {
+		extra, data := r[0], r[1]
+		// The last extra byte is the info bits, the first byte of the header.
+		rec := offset + uint64(len(extra)) + 4
+		copy(d[rec-4-uint64(len(extra)):], extra)
+		binary.BigEndian.PutUint16(d[rec-4:], uint16((2+i)<<3))
+		copy(d[rec:], data)
+		binary.BigEndian.PutUint16(d[prev-2:], uint16(rec-prev))
+
+		prev = rec
+		offset = rec + uint64(len(data)) + 8
+	}
+	binary.BigEndian.PutUint16(d[prev-2:], uint16(PageNewSupremum-prev))
+	return d
+}
+
+// Make the extra bytes of the record, from the low address to the high
+// address, the last byte is the info bits of the record header.
+func MakeTestExtra(InfoBits byte, before ...byte) []byte {
+	return append(before, InfoBits)
+}
+
+func MakeTestSDIRecord(t *testing.T, sdi string) ([]byte, []byte) {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write([]byte(sdi)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, SdiRecOffData)
+	binary.BigEndian.PutUint32(data[SdiRecOffType:], uint32(SdiTypeTable))
+	binary.BigEndian.PutUint64(data[SdiRecOffId:], 1065)
+	binary.BigEndian.PutUint32(data[SdiRecOffUncompLen:], uint32(len(sdi)))
+	binary.BigEndian.PutUint32(data[SdiRecOffCompLen:], uint32(compressed.Len()))
+	data = append(data, compressed.Bytes()...)
+
+	// The two bytes length of the big column, the high byte is near the header.
+	length := compressed.Len()
+	return MakeTestExtra(0, byte(length), byte(0x80|length>>8)), data
+}
+
+func MakeTestPages(t *testing.T, P *ParseIB, pages ...[]byte) []Page {
+	var all []Page
+	for _, d := range pages {
+		p, err := P.ParseFilHeader(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.OriginalData = d
+		all = append(all, p)
+	}
+	return all
+}
+
+func TestGetSDITables(t *testing.T) {
+	P := NewParseIB()
+	extra, data := MakeTestSDIRecord(t, TestSDI)
+	pages := MakeTestPages(t, P, MakeTestPage(FilPageSdi, [][2][]byte{{extra, data}}))
+
+	tables := P.GetSDITables(pages)
+	table, ok := tables[1065]
+	if !ok {
+		t.Fatalf("table 1065 have not read from the SDI, got %v", tables)
+	}
+	if table.DBName != "test" || table.TableName != "t1" || table.SpaceId != 3 {
+		t.Errorf("table is %s.%s space %d", table.DBName, table.TableName, table.SpaceId)
+	}
+
+	var names []string
+	for _, c := range table.Columns {
+		names = append(names, c.FieldName)
+	}
+	expected := "[id DB_TRX_ID DB_ROLL_PTR c1 !hidden!_dropped_v2_p4_c3 c2]"
+	if fmt.Sprint(names) != expected {
+		t.Errorf("columns are %v, expected %s", names, expected)
+	}
+
+	c2 := table.Columns[5]
+	if c2.VersionAdded != 1 || c2.InstantDefault != "abc" {
+		t.Errorf("c2 version added %d default %v", c2.VersionAdded, c2.InstantDefault)
+	}
+	if c3 := table.Columns[4]; c3.VersionDropped != 2 {
+		t.Errorf("c3 version dropped %d", c3.VersionDropped)
+	}
+	if table.Indexes[150].Name != "PRIMARY" || table.Indexes[151].Name != "k1" ||
+		len(table.Indexes[151].Fields) != 1 || table.Indexes[151].Fields[0].ColumnName != "c1" {
+		t.Errorf("indexes are %v", table.Indexes)
+	}
+}
+
+func TestGetSDITablesFromBlob(t *testing.T) {
+	P := NewParseIB()
+	extra, data := MakeTestSDIRecord(t, TestSDI)
+
+	// Move the data to the blob page, only the external reference is in the record.
+	blob := make([]byte, DefaultPageSize)
+	binary.BigEndian.PutUint32(blob[4:], 5)
+	binary.BigEndian.PutUint16(blob[24:], uint16(FilPageSdiBlob))
+	binary.BigEndian.PutUint32(blob[BtrBlobHdrPartLen:], uint32(len(data)-int(SdiRecOffData)))
+	binary.BigEndian.PutUint32(blob[BtrBlobHdrNextPage:], uint32(FilNull))
+	copy(blob[BtrBlobHdrData:], data[SdiRecOffData:])
+
+	ref := make([]byte, 20)
+	binary.BigEndian.PutUint32(ref[4:], 5)
+	binary.BigEndian.PutUint64(ref[12:], uint64(len(data)-int(SdiRecOffData)))
+	data = append(data[:SdiRecOffData], ref...)
+	extra = MakeTestExtra(0, 20, 0x80|0x40)
+
+	pages := MakeTestPages(t, P, MakeTestPage(FilPageSdi, [][2][]byte{{extra, data}}), blob)
+	if _, ok := P.GetSDITables(pages)[1065]; !ok {
+		t.Fatal("table 1065 have not read from the SDI blob page")
+	}
+}
+
+// The records before and after the instant ADD/DROP column have different
+// columns, the missing column use the instant default value.
+func TestParseInstantRecords(t *testing.T) {
+	P := NewParseIB()
+	extra, data := MakeTestSDIRecord(t, TestSDI)
+	pages := MakeTestPages(t, P, MakeTestPage(FilPageSdi, [][2][]byte{{extra, data}}))
+	for TableId, table := range P.GetSDITables(pages) {
+		P.TableMap[TableId] = table
+	}
+	columns := P.TableMap[1065].Columns
+
+	fields := func(id uint32, values ...[]byte) []byte {
+		d := make([]byte, 4+6+7)
+		binary.BigEndian.PutUint32(d, id|0x80000000)
+		d[9] = byte(id)
+		for _, v := range values {
+			d = append(d, v...)
+		}
+		return d
+	}
+	Int := func(v uint32) []byte {
+		d := make([]byte, 4)
+		binary.BigEndian.PutUint32(d, v|0x80000000)
+		return d
+	}
+
+	page := MakeTestPage(FilPageIndex, [][2][]byte{
+		// The version 0 record, c1=5 and c3=7, c2 is not stored.
+		{MakeTestExtra(0, 0), fields(1, Int(5), Int(7))},
+		// The version 1 record, c1 is NULL, c3=8 and c2='xy'.
+		{MakeTestExtra(byte(RecInfoVersionFlag), 2, 0x01, 1), fields(2, Int(8), []byte("xy"))},
+		// The version 2 record, c1=9, c2 is NULL, c3 is dropped.
+		{MakeTestExtra(byte(RecInfoVersionFlag), 0x02, 2), fields(3, Int(9))},
+	})
+
+	records := P.ParsePageRecords(page, 0, columns, false, 0)
+	if len(records) != 3 {
+		t.Fatalf("read %d records, expected 3", len(records))
+	}
+
+	expected := []string{
+		"[1 1 0 5 7 abc]",
+		"[2 2 0 <nil> 8 xy]",
+		"[3 3 0 9 <nil> <nil>]",
+	}
+	for i, r := range records {
+		var values []interface{}
+		for _, c := range r.Columns {
+			values = append(values, c.FieldValue)
+		}
+		if fmt.Sprint(values) != expected[i] {
+			t.Errorf("record %d is %v, expected %s", i, values, expected[i])
+		}
+	}
+}
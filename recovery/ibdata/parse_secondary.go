@@ -0,0 +1,243 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The secondary index record layout is stored in the IndexTableMap with
+// the index id ORed by this flag, so the record parsing can find it by the
+// column's table id, the same as the table.
+const SecondaryIndexTableFlag uint64 = 1 << 63
+
+// Get the table of the columns by the column's table id, the secondary
+// index record layout is not in the TableMap.
+func (P *ParseIB) GetColumnsTable(columns []Columns) (Tables, bool) {
+	TableId := columns[0].TableID
+	if TableId&SecondaryIndexTableFlag != 0 {
+		table, ok := P.IndexTableMap[TableId]
+		return table, ok
+	}
+	table, ok := P.TableMap[TableId]
+	return table, ok
+}
+
+// Make the secondary index leaf record columns, the record store the index
+// fields, and then the primary key fields which are not in the index fields.
+// The column prefix field is marked IsPrefix, and the fixed length column
+// only store the prefix bytes.
+// Reference mysql-5.7.19/storage/innobase/dict/dict0dict.cc dict_index_build_internal_non_clust
+func (P *ParseIB) MakeIndexColumns(table Tables, idx Indexes) ([]Columns, error) {
+	var names []string
+	prefixes := make(map[string]uint64)
+	for _, f := range idx.Fields {
+		names = append(names, f.ColumnName)
+		prefixes[f.ColumnName] = f.PrefixLen
+	}
+
+	// The primary key fields have the same prefix as the cluster index,
+	// the table without primary key use the DB_ROW_ID.
+	KeyFields := []*Fields{{ColumnName: "DB_ROW_ID"}}
+	for _, cidx := range table.Indexes {
+		if cidx.Name == "PRIMARY" {
+			KeyFields = cidx.Fields
+		}
+	}
+	for _, f := range KeyFields {
+		if _, ok := prefixes[f.ColumnName]; !ok {
+			names = append(names, f.ColumnName)
+			prefixes[f.ColumnName] = f.PrefixLen
+		}
+	}
+
+	PseudoId := idx.Id | SecondaryIndexTableFlag
+	t := Tables{Indexes: map[uint64]Indexes{idx.Id: idx}, SpaceId: table.SpaceId}
+	for i, name := range names {
+		var column Columns
+		found := false
+		// The virtual generated column is only stored in the secondary index.
+		for _, c := range append(append([]Columns{}, table.Columns...), table.VirtualColumns...) {
+			if c.FieldName == name {
+				column = c
+				found = true
+			}
+		}
+		if !found {
+			ErrMsg := fmt.Sprintf("the field %s of index %s have not found in the table columns", name, idx.Name)
+			logs.Error(ErrMsg)
+			return nil, fmt.Errorf(ErrMsg)
+		}
+
+		// The secondary index record always store all fields.
+		column.FieldPos = uint64(i)
+		column.TableID = PseudoId
+		column.VersionAdded = 0
+		column.VersionDropped = 0
+		if prefixes[name] != 0 {
+			column.IsPrefix = true
+			if utils.GetFixedLength(column.FieldType, column.FieldLen) > prefixes[name] {
+				column.FieldLen = prefixes[name]
+			}
+		}
+		if column.IsNUll {
+			t.NullCount++
+		}
+		t.Columns = append(t.Columns, column)
+	}
+
+	P.IndexTableMap[PseudoId] = t
+	return t.Columns, nil
+}
+
+// Read the secondary index leaf pages and output the partial rows, which only
+// have the index columns and the primary key columns. It is used when the
+// cluster index leaf pages are overwritten but the secondary indexes survive.
+// When many indexes are identified, the partial rows are merged by the primary key.
+func (P *ParseIB) ParseSecondaryIndexData(path string, DBName string, TableName string, IndexNames []string, IsRecovery bool) error {
+	pages, ParseFileErr := P.ReadTablePages(path)
+	if ParseFileErr != nil {
+		return ParseFileErr
+	}
+	P.File = path
+
+	table, GetTableErr := P.GetTableFromDict(DBName, TableName)
+	if GetTableErr != nil {
+		logs.Error("get table from dict failed, the error is ", GetTableErr,
+			" the db name is ", DBName, " the table name is ", TableName)
+		return GetTableErr
+	}
+	KeyNames := P.GetPrimaryKeyNames(table)
+
+	var keys []string
+	merged := make(map[string]Records)
+	for _, name := range IndexNames {
+		var idx Indexes
+		found := false
+		for _, i := range table.Indexes {
+			if i.Name == name {
+				idx = i
+				found = true
+			}
+		}
+		if !found {
+			ErrMsg := fmt.Sprintf("index %s have not found in table %s.%s", name, DBName, TableName)
+			logs.Error(ErrMsg)
+			return fmt.Errorf(ErrMsg)
+		}
+
+		columns, err := P.MakeIndexColumns(table, idx)
+		if err != nil {
+			return err
+		}
+
+		var AllRecords []Records
+		for _, page := range pages {
+			if page.fh.FIL_PAGE_TYPE != FilPageIndex || page.ph.PAGE_INDEX_ID != idx.Id {
+				continue
+			}
+			P.ParsePageHeader(&page)
+			if page.ph.PAGE_LEVEL != 0 {
+				continue
+			}
+			if IsRecovery && (page.ph.PAGE_FREE == 0 || page.ph.PAGE_FREE > uint64(DefaultPageSize)) {
+				continue
+			}
+
+			AllRecords = append(AllRecords, P.ParsePageRecords(page.OriginalData,
+				len(page.OriginalData)-len(page.data), columns, IsRecovery, page.ph.PAGE_FREE)...)
+		}
+		logs.Info("read ", len(AllRecords), " records from index ", name)
+
+		// Merge the partial rows by the primary key, the live record is preferred.
+		for _, state := range []string{RecordLive, RecordDeleteMarked, RecordPurgedFree} {
+			for _, r := range AllRecords {
+				if r.State != state {
+					continue
+				}
+				key := MakeRecordKey(r.Columns, KeyNames)
+				m, ok := merged[key]
+				if !ok {
+					keys = append(keys, key)
+					merged[key] = r
+					continue
+				}
+				m.Columns = MergeColumns(m.Columns, r.Columns)
+				merged[key] = m
+			}
+		}
+	}
+
+	// The column prefix is not the column value, it is not output.
+	var rows []Records
+	DroppedColumns := make(map[string]bool)
+	for _, key := range keys {
+		r := merged[key]
+		var columns []Columns
+		for _, c := range r.Columns {
+			if c.IsPrefix {
+				DroppedColumns[c.FieldName] = true
+				continue
+			}
+			columns = append(columns, c)
+		}
+		r.Columns = SortColumnsByTable(columns, table)
+		rows = append(rows, r)
+	}
+	for name := range DroppedColumns {
+		logs.Warn("the column ", name, " only have the prefix in the indexes, it is not output")
+	}
+
+	// The partial rows only have the index columns, the writer identify the column list.
+	rows = P.FilterRecords(rows, TableName, DBName)
+	P.WriteRecords(rows, TableName, DBName)
+	return nil
+}
+
+// Add the columns of b which a don't have, the column prefix of a is
+// replaced by the whole column of b.
+func MergeColumns(a []Columns, b []Columns) []Columns {
+	for _, cb := range b {
+		found := false
+		for i, ca := range a {
+			if ca.FieldName == cb.FieldName {
+				found = true
+				if ca.IsPrefix && !cb.IsPrefix {
+					a[i] = cb
+				}
+			}
+		}
+		if !found {
+			a = append(a, cb)
+		}
+	}
+	return a
+}
+
+// Sort the columns by the table column order.
+func SortColumnsByTable(columns []Columns, table Tables) []Columns {
+	var sorted []Columns
+	for _, tc := range table.Columns {
+		for _, c := range columns {
+			if c.FieldName == tc.FieldName {
+				sorted = append(sorted, c)
+			}
+		}
+	}
+	return sorted
+}
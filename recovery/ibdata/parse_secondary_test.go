@@ -0,0 +1,104 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+func TestDecodeFieldsPos(t *testing.T) {
+	for _, c := range []struct {
+		pos      []uint64
+		expected string
+	}{
+		// The index have no column prefix.
+		{[]uint64{0, 1, 2}, "[0/0 1/0 2/0]"},
+		// KEY (c1(10), c2), the POS is the field number << 16 plus the prefix length.
+		{[]uint64{10, 1 << 16}, "[0/10 1/0]"},
+		// KEY (c1, c2(20)).
+		{[]uint64{0, 1<<16 | 20}, "[0/0 1/20]"},
+	} {
+		var fields []*Fields
+		for _, pos := range c.pos {
+			fields = append(fields, &Fields{ColumnPos: pos})
+		}
+		DecodeFieldsPos(fields)
+
+		var decoded []string
+		for _, f := range fields {
+			decoded = append(decoded, fmt.Sprintf("%d/%d", f.ColumnPos, f.PrefixLen))
+		}
+		if fmt.Sprint(decoded) != c.expected {
+			t.Errorf("the POS %v is decoded to %v, expected %s", c.pos, decoded, c.expected)
+		}
+	}
+}
+
+func TestMakeIndexColumns(t *testing.T) {
+	P := NewParseIB()
+	table := Tables{DBName: "test", TableName: "t1", Columns: []Columns{
+		{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4, TableID: 10},
+		{FieldName: "c1", FieldType: utils.DATA_CHAR, FieldLen: 20, IsNUll: true, TableID: 10},
+		{FieldName: "c2", FieldType: utils.DATA_VARMYSQL, FieldLen: 20, IsNUll: true, TableID: 10},
+	}, Indexes: map[uint64]Indexes{
+		100: {Id: 100, Name: "PRIMARY", Fields: []*Fields{{ColumnName: "id"}}},
+		101: {Id: 101, Name: "k1", Fields: []*Fields{{ColumnName: "c1", PrefixLen: 5},
+			{ColumnPos: 1, ColumnName: "c2", PrefixLen: 5}}},
+	}}
+	P.TableMap[10] = table
+
+	columns, err := P.MakeIndexColumns(table, table.Indexes[101])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layout []string
+	for _, c := range columns {
+		layout = append(layout, fmt.Sprintf("%s/%d/%v", c.FieldName, c.FieldLen, c.IsPrefix))
+	}
+	// The fixed length prefix only store the prefix bytes.
+	if fmt.Sprint(layout) != "[c1/5/true c2/20/true id/4/false]" {
+		t.Errorf("the index columns are %v", layout)
+	}
+
+	// The layout is not the table.
+	if len(P.TableMap) != 1 {
+		t.Errorf("the TableMap have %d tables", len(P.TableMap))
+	}
+	if _, ok := P.GetColumnsTable(columns); !ok {
+		t.Error("the index layout have not found by the column's table id")
+	}
+
+	// The index on the virtual generated column, it is only in the VirtualColumns.
+	table.VirtualColumns = []Columns{{FieldName: "v1", FieldType: utils.DATA_INT, FieldLen: 4,
+		IsNUll: true, IsVirtual: true, TableID: 10}}
+	table.Indexes[102] = Indexes{Id: 102, Name: "k2", Fields: []*Fields{{ColumnName: "v1"}}}
+	columns, err = P.MakeIndexColumns(table, table.Indexes[102])
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout = nil
+	for _, c := range columns {
+		layout = append(layout, fmt.Sprintf("%s/%d/%d", c.FieldName, c.FieldPos, c.FieldLen))
+	}
+	if fmt.Sprint(layout) != "[v1/0/4 id/1/4]" {
+		t.Errorf("the virtual index columns are %v", layout)
+	}
+	if IsOutputColumn(columns[0]) {
+		t.Error("the virtual column is written to the output")
+	}
+}
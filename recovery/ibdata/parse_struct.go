@@ -0,0 +1,468 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Store a column definition read from the create table statement.
+type StructColumns struct {
+	FieldName string
+	MySQLType uint64
+	Elements  []string
+
+	// The precision and scale of the DECIMAL column, the precision
+	// is the bits of the BIT column.
+	Precision int
+	Scale     int
+}
+
+// Store a table definition read from the create table statement.
+type StructTables struct {
+	DBName    string
+	TableName string
+	Columns   []StructColumns
+}
+
+// Read the create table statements in the file, and merge the info which
+// the data dictionary don't have into the table struct, such as the ENUM/SET
+// labels. The database name is used when the table name is not qualified.
+func (P *ParseIB) GetTableFieldsFromStruct(path string, DBName string) error {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		logs.Error("read table struct file failed, the error is ", err)
+		return err
+	}
+
+	tables, err := ParseCreateTableSql(string(d))
+	if err != nil {
+		logs.Error("parse table struct file failed, the error is ", err)
+		return err
+	}
+
+	for _, st := range tables {
+		if st.DBName == "" {
+			st.DBName = DBName
+		}
+
+		found := false
+		for TableId, table := range P.TableMap {
+			if table.DBName != st.DBName || table.TableName != st.TableName {
+				continue
+			}
+			found = true
+
+			for _, sc := range st.Columns {
+				for i := range table.Columns {
+					if table.Columns[i].FieldName != sc.FieldName {
+						continue
+					}
+					switch sc.MySQLType {
+					case utils.MYSQL_TYPE_ENUM, utils.MYSQL_TYPE_SET:
+						table.Columns[i].MySQLType = sc.MySQLType
+						table.Columns[i].Elements = sc.Elements
+					case utils.MYSQL_TYPE_NEWDECIMAL:
+						table.Columns[i].Precision = sc.Precision
+						table.Columns[i].Scale = sc.Scale
+					case utils.MYSQL_TYPE_BIT:
+						table.Columns[i].Precision = sc.Precision
+					}
+				}
+			}
+			P.TableMap[TableId] = table
+		}
+
+		if !found {
+			logs.Warn("table ", st.DBName, ".", st.TableName,
+				" in the struct file have not found in the data dictionary")
+		}
+	}
+	return nil
+}
+
+// Parse all create table statements in the sql text.
+// Only the column name, type, ENUM/SET element list, DECIMAL precision and BIT width are read,
+// index definitions and table options are skipped.
+func ParseCreateTableSql(sql string) ([]StructTables, error) {
+	var tables []StructTables
+
+	for _, stmt := range SplitStatements(sql) {
+		words := strings.Fields(stmt)
+		if len(words) < 3 || !strings.EqualFold(words[0], "CREATE") {
+			continue
+		}
+
+		// CREATE [TEMPORARY] TABLE [IF NOT EXISTS] tbl_name (...)
+		pos := strings.Index(strings.ToUpper(stmt), "TABLE")
+		if pos < 0 {
+			continue
+		}
+		rest := strings.TrimSpace(stmt[pos+len("TABLE"):])
+		if strings.HasPrefix(strings.ToUpper(rest), "IF NOT EXISTS") {
+			rest = strings.TrimSpace(rest[len("IF NOT EXISTS"):])
+		}
+
+		start := strings.Index(rest, "(")
+		if start < 0 {
+			return nil, fmt.Errorf("create table statement have no column definition: %s", stmt)
+		}
+
+		var table StructTables
+		names := SplitQualifiedName(strings.TrimSpace(rest[:start]))
+		if len(names) == 2 {
+			table.DBName = names[0]
+			table.TableName = names[1]
+		} else {
+			table.TableName = names[0]
+		}
+
+		end := MatchParenthesis(rest, start)
+		if end < 0 {
+			return nil, fmt.Errorf("create table statement parenthesis not match: %s", stmt)
+		}
+
+		for _, def := range SplitTopLevel(rest[start+1:end], ',') {
+			column, ok := ParseColumnDefinition(def)
+			if ok {
+				table.Columns = append(table.Columns, column)
+			}
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// Parse one column definition, such as:
+// `c1` enum('a','b') NOT NULL DEFAULT 'a'
+// Return false when the definition is an index or a constraint.
+func ParseColumnDefinition(def string) (StructColumns, bool) {
+	var column StructColumns
+
+	def = strings.TrimSpace(def)
+	if def == "" {
+		return column, false
+	}
+
+	var name string
+	var rest string
+	if def[0] == '`' {
+		end := strings.Index(def[1:], "`")
+		if end < 0 {
+			return column, false
+		}
+		name = def[1 : end+1]
+		rest = def[end+2:]
+	} else {
+		words := strings.Fields(def)
+		switch strings.ToUpper(words[0]) {
+		case "PRIMARY", "KEY", "INDEX", "UNIQUE", "CONSTRAINT",
+			"FULLTEXT", "SPATIAL", "FOREIGN", "CHECK":
+			return column, false
+		}
+		name = words[0]
+		rest = def[len(words[0]):]
+	}
+	column.FieldName = name
+
+	rest = strings.TrimSpace(rest)
+	TypeEnd := strings.IndexAny(rest, "( \t\r\n")
+	if TypeEnd < 0 {
+		TypeEnd = len(rest)
+	}
+
+	switch strings.ToUpper(rest[:TypeEnd]) {
+	case "ENUM":
+		column.MySQLType = utils.MYSQL_TYPE_ENUM
+	case "SET":
+		column.MySQLType = utils.MYSQL_TYPE_SET
+	case "DECIMAL", "NUMERIC", "DEC", "FIXED":
+		column.MySQLType = utils.MYSQL_TYPE_NEWDECIMAL
+	case "BIT":
+		column.MySQLType = utils.MYSQL_TYPE_BIT
+	default:
+		return column, true
+	}
+
+	start := strings.Index(rest, "(")
+	if start > TypeEnd && strings.TrimSpace(rest[TypeEnd:start]) != "" {
+		start = -1
+	}
+
+	if column.MySQLType == utils.MYSQL_TYPE_NEWDECIMAL || column.MySQLType == utils.MYSQL_TYPE_BIT {
+		// The DECIMAL is DECIMAL(10,0), and DECIMAL(M) is DECIMAL(M,0).
+		// The BIT is BIT(1).
+		column.Precision = 10
+		if column.MySQLType == utils.MYSQL_TYPE_BIT {
+			column.Precision = 1
+		}
+		if start < 0 {
+			return column, true
+		}
+		end := strings.Index(rest[start:], ")")
+		if end < 0 {
+			return column, true
+		}
+		fmt.Sscanf(strings.Replace(rest[start+1:start+end], " ", "", -1), "%d,%d",
+			&column.Precision, &column.Scale)
+		return column, true
+	}
+
+	if start < 0 {
+		return column, true
+	}
+	end := MatchParenthesis(rest, start)
+	if end < 0 {
+		return column, true
+	}
+	column.Elements = ParseElements(rest[start+1 : end])
+
+	return column, true
+}
+
+// The DECIMAL value is decoded by the precision and scale which are read
+// from the table struct, otherwise it is output as hex.
+func FormatDecimal(column *Columns, data []byte, value interface{}) interface{} {
+	if column.MySQLType != utils.MYSQL_TYPE_NEWDECIMAL || column.Precision == 0 {
+		return value
+	}
+
+	decimal, err := utils.ParseDecimal(data, column.Precision, column.Scale)
+	if err != nil {
+		logs.Warn("parse decimal value of column ", column.FieldName, " failed, the error is ", err)
+		return value
+	}
+	column.IsBinary = false
+	return decimal
+}
+
+// Parse the ENUM/SET element list, such as 'a','b''c','d\'e', the quote is
+// escaped by doubling or by backslash.
+func ParseElements(list string) []string {
+	var elements []string
+	var value strings.Builder
+
+	InQuote := false
+	var quote byte
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		if !InQuote {
+			if c == '\'' || c == '"' {
+				InQuote = true
+				quote = c
+				value.Reset()
+			}
+			continue
+		}
+
+		switch {
+		case c == '\\' && i+1 < len(list):
+			i++
+			value.WriteByte(UnescapeChar(list[i]))
+		case c == quote && i+1 < len(list) && list[i+1] == quote:
+			i++
+			value.WriteByte(c)
+		case c == quote:
+			InQuote = false
+			elements = append(elements, value.String())
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return elements
+}
+
+// Reference MySQL string literal escape sequences.
+func UnescapeChar(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return '\032'
+	}
+	return c
+}
+
+// Split the sql text by ';', the ';' in quotes or comments is ignored.
+func SplitStatements(sql string) []string {
+	var stmts []string
+	var stmt strings.Builder
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := SkipQuoted(sql, i)
+			stmt.WriteString(sql[i:end])
+			i = end - 1
+		case c == '-' && strings.HasPrefix(sql[i:], "-- "), c == '#':
+			end := strings.Index(sql[i:], "\n")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end
+			}
+			stmt.WriteByte(' ')
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 3
+			}
+			stmt.WriteByte(' ')
+		case c == ';':
+			stmts = append(stmts, strings.TrimSpace(stmt.String()))
+			stmt.Reset()
+		default:
+			stmt.WriteByte(c)
+		}
+	}
+	if strings.TrimSpace(stmt.String()) != "" {
+		stmts = append(stmts, strings.TrimSpace(stmt.String()))
+	}
+	return stmts
+}
+
+// Split the text by sep, the sep in quotes or parenthesis is ignored.
+func SplitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"', '`':
+			i = SkipQuoted(s, i) - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// Return the position of the parenthesis which match the one at start.
+func MatchParenthesis(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"', '`':
+			i = SkipQuoted(s, i) - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Return the position after the quoted string which start at start.
+func SkipQuoted(s string, start int) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		if s[i] == '\\' && quote != '`' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// Split the name like `db`.`table` or db.table.
+func SplitQualifiedName(name string) []string {
+	var names []string
+	for _, n := range SplitTopLevel(name, '.') {
+		n = strings.TrimSpace(n)
+		names = append(names, strings.Trim(n, "`\""))
+	}
+	return names
+}
+
+// Remember the ENUM/SET columns which have warned, avoid warning every row.
+var WarnedElements sync.Map
+
+// The ENUM/SET value is stored as an unsigned integer, convert it to
+// the labels when the table struct provide them, otherwise keep the number.
+func FormatElements(column Columns, value interface{}) interface{} {
+	number, ok := value.(uint64)
+	if !ok || column.FieldType != utils.DATA_INT {
+		return value
+	}
+
+	var label string
+	var InLabels bool
+	switch column.MySQLType {
+	case utils.MYSQL_TYPE_ENUM:
+		if len(column.Elements) != 0 {
+			label, InLabels = utils.ParseEnum(number, column.Elements)
+		}
+	case utils.MYSQL_TYPE_SET:
+		if len(column.Elements) != 0 {
+			label, InLabels = utils.ParseSet(number, column.Elements)
+		}
+	case utils.MYSQL_TYPE_STRING:
+		// ENUM and SET are stored as DATA_INT with MYSQL_TYPE_STRING,
+		// we can't tell which one it is without the table struct.
+	default:
+		return value
+	}
+
+	if InLabels {
+		return label
+	}
+
+	// The value out of the labels may be corrupted, or the labels are
+	// reordered after the value is written, the number is not lost.
+	key := fmt.Sprintf("%d.%s", column.TableID, column.FieldName)
+	if _, warned := WarnedElements.LoadOrStore(key, true); !warned {
+		if len(column.Elements) != 0 {
+			logs.Warn("the value ", number, " of ENUM/SET column ", column.FieldName,
+				" is out of the labels, output the number.")
+		} else {
+			logs.Warn("the labels of ENUM/SET column ", column.FieldName,
+				" are unknown, output the number, identify the table struct to get the labels.")
+		}
+	}
+	return value
+}
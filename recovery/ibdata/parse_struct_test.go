@@ -0,0 +1,104 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+func TestParseElements(t *testing.T) {
+	for list, expected := range map[string][]string{
+		`'a','b'`:        {"a", "b"},
+		`'a,b', 'c'`:     {"a,b", "c"},
+		`'it''s','d\'e'`: {"it's", "d'e"},
+		`"x""y",'a\\b'`:  {`x"y`, `a\b`},
+		`'(',')','a\nb'`: {"(", ")", "a\nb"},
+		`'', 'x'`:        {"", "x"},
+	} {
+		if elements := ParseElements(list); fmt.Sprintf("%q", elements) != fmt.Sprintf("%q", expected) {
+			t.Errorf("the elements of %s are %q, expected %q", list, elements, expected)
+		}
+	}
+}
+
+func TestParseCreateTableSql(t *testing.T) {
+	sql := "CREATE TABLE IF NOT EXISTS `test`.`t1` (\n" +
+		"  `id` int(11) NOT NULL,\n" +
+		"  `e` enum('a,b','it''s','x\\'y',')') NOT NULL DEFAULT 'a,b' COMMENT 'the ; and ,',\n" +
+		"  `s` set('r','w') DEFAULT NULL,\n" +
+		"  `d` decimal(10, 2) DEFAULT NULL,\n" +
+		"  `b` bit(3) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `k1` (`e`)\n" +
+		") ENGINE=InnoDB;\n" +
+		"create table t2 (id int, c decimal);"
+
+	tables, err := ParseCreateTableSql(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 {
+		t.Fatalf("parsed %d tables, expected 2", len(tables))
+	}
+	if tables[0].DBName != "test" || tables[0].TableName != "t1" || len(tables[0].Columns) != 5 ||
+		tables[1].DBName != "" || tables[1].TableName != "t2" || len(tables[1].Columns) != 2 {
+		t.Fatalf("parsed the tables %+v", tables)
+	}
+
+	columns := tables[0].Columns
+	if fmt.Sprintf("%q", columns[1].Elements) != `["a,b" "it's" "x'y" ")"]` ||
+		columns[1].MySQLType != utils.MYSQL_TYPE_ENUM {
+		t.Errorf("the ENUM column is %+v", columns[1])
+	}
+	if fmt.Sprintf("%q", columns[2].Elements) != `["r" "w"]` || columns[2].MySQLType != utils.MYSQL_TYPE_SET {
+		t.Errorf("the SET column is %+v", columns[2])
+	}
+	if columns[3].Precision != 10 || columns[3].Scale != 2 || columns[4].Precision != 3 {
+		t.Errorf("the DECIMAL and BIT columns are %+v %+v", columns[3], columns[4])
+	}
+	if c := tables[1].Columns[1]; c.Precision != 10 || c.Scale != 0 {
+		t.Errorf("the DECIMAL column without the precision is %+v", c)
+	}
+}
+
+// The value out of the labels is the number.
+func TestFormatElements(t *testing.T) {
+	enum := Columns{FieldName: "e", FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_ENUM,
+		Elements: []string{"a", "b"}}
+	set := Columns{FieldName: "s", FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_SET,
+		Elements: []string{"r", "w", "x"}}
+	unknown := Columns{FieldName: "u", FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_STRING}
+
+	for _, c := range []struct {
+		column   Columns
+		value    uint64
+		expected interface{}
+	}{
+		{enum, 0, ""},
+		{enum, 2, "b"},
+		{enum, 3, uint64(3)},
+		{set, 0, ""},
+		{set, 5, "r,x"},
+		{set, 8, uint64(8)},
+		{unknown, 1, uint64(1)},
+	} {
+		if v := FormatElements(c.column, c.value); v != c.expected {
+			t.Errorf("the value %d of %s is %#v, expected %#v", c.value, c.column.FieldName, v, c.expected)
+		}
+	}
+}
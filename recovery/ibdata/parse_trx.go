@@ -0,0 +1,111 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Get the DB_TRX_ID and DB_ROLL_PTR from the record columns.
+func GetRecordTrx(columns []Columns) (uint64, uint64) {
+	var TrxId, RollPtr uint64
+	for _, c := range columns {
+		switch c.FieldName {
+		case "DB_TRX_ID":
+			TrxId, _ = c.FieldValue.(uint64)
+		case "DB_ROLL_PTR":
+			RollPtr, _ = c.FieldValue.(uint64)
+		}
+	}
+	return TrxId, RollPtr
+}
+
+// Keep the records which DB_TRX_ID is in the range of MinTrxId and MaxTrxId.
+func (P *ParseIB) FilterTrxRecords(AllRecords []Records) []Records {
+	if P.MinTrxId == 0 && P.MaxTrxId == 0 {
+		return AllRecords
+	}
+
+	var records []Records
+	for _, r := range AllRecords {
+		if r.TrxId < P.MinTrxId {
+			continue
+		}
+		if P.MaxTrxId != 0 && r.TrxId > P.MaxTrxId {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// Get the newest n transaction ids which touch the table rows, the live rows
+// and the deleted rows are both read. Set the MinTrxId to the last one to
+// only output the rows of the newest n transactions.
+func (P *ParseIB) NewestTrxIds(path string, DBName string, TableName string, n int) ([]uint64, error) {
+	pages, ParseFileErr := P.ReadTablePages(path)
+	if ParseFileErr != nil {
+		return nil, ParseFileErr
+	}
+
+	fields, GetFieldsErr := P.GetTableColumnsFromDict(DBName, TableName)
+	if GetFieldsErr != nil {
+		logs.Error("get fields from dict failed, the error is ", GetFieldsErr,
+			" the db name is ", DBName, " the table name is ", TableName)
+		return nil, GetFieldsErr
+	}
+
+	// The free pages keep the old records, their transactions are not
+	// the ones which touch the table rows now.
+	FreePages := P.GetFreePages(pages)
+
+	TrxMap := make(map[uint64]bool)
+	for _, page := range pages {
+		P.ParsePageHeader(&page)
+
+		// Only the cluster index leaf page, the same as ParseTableData.
+		if page.fh.FIL_PAGE_TYPE != FilPageIndex || page.ph.PAGE_LEVEL != 0 ||
+			page.ph.PAGE_MAX_TRX_ID != 0 || FreePages[page.fh.FIL_PAGE_OFFSET] {
+			continue
+		}
+
+		pos := len(page.OriginalData) - len(page.data)
+		records := P.ParsePageRecords(page.OriginalData, pos, fields, false, 0)
+		if page.ph.PAGE_FREE != 0 && page.ph.PAGE_FREE < uint64(DefaultPageSize) {
+			records = append(records,
+				P.ParsePageRecords(page.OriginalData, pos, fields, true, page.ph.PAGE_FREE)...)
+		}
+		for _, r := range records {
+			if r.TrxId != 0 {
+				TrxMap[r.TrxId] = true
+			}
+		}
+	}
+
+	var ids []uint64
+	for id := range TrxMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	if n > 0 && len(ids) > n {
+		ids = ids[:n]
+	}
+
+	logs.Info(fmt.Sprintf("the newest %d transaction ids: %v", len(ids), ids))
+	return ids, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Get the free pages of the tablespace by the extent descriptor bitmaps.
+// The page 0 and every page at the multiple of the extents an XDES page can
+// describe store the extent descriptors, every extent descriptor have the
+// extent state and two bits for every page, the first bit is XDES_FREE_BIT.
+// The pages after the FSP_FREE_LIMIT have not been initialized.
+// Reference mysql-5.7.19/storage/innobase/include/fsp0fsp.ic xdes_get_bit
+func (P *ParseIB) GetFreePages(pages []Page) map[uint64]bool {
+	FreePages := make(map[uint64]bool)
+
+	// The extents which an XDES page can describe.
+	XdesPages := uint64(DefaultPageSize)
+	var FreeLimit uint64
+	found := false
+
+	for _, p := range pages {
+		if p.fh.FIL_PAGE_TYPE != FilPageTypeFspHdr && p.fh.FIL_PAGE_TYPE != FilPageTypeXdes {
+			continue
+		}
+		base := p.fh.FIL_PAGE_OFFSET
+		if base%XdesPages != 0 {
+			continue
+		}
+
+		d := p.OriginalData
+		if p.fh.FIL_PAGE_TYPE == FilPageTypeFspHdr {
+			FreeLimit = utils.MatchReadFrom4(d[FspHeaderOffset+FspFreeLimit:])
+		}
+		found = true
+
+		for i := uint64(0); i < XdesPages/FspExtentSize; i++ {
+			entry := d[XdesArrOffset+i*XdesSize:]
+			state := utils.MatchReadFrom4(entry[XdesState:])
+
+			for j := uint64(0); j < FspExtentSize; j++ {
+				PageNo := base + i*FspExtentSize + j
+
+				// #define XDES_FREE_BIT 0, #define XDES_BITS_PER_PAGE 2
+				bit := j * 2
+				free := (entry[XdesBitmap+bit/8]>>(bit%8))&1 != 0
+				if state == XdesFree || free {
+					FreePages[PageNo] = true
+				}
+			}
+		}
+	}
+
+	if !found {
+		logs.Warn("the extent descriptor page have not found, can't find the free pages.")
+		return FreePages
+	}
+
+	for _, p := range pages {
+		if FreeLimit != 0 && p.fh.FIL_PAGE_OFFSET >= FreeLimit {
+			FreePages[p.fh.FIL_PAGE_OFFSET] = true
+		}
+	}
+
+	logs.Debug("found ", len(FreePages), " free pages by the extent descriptors")
+	return FreePages
+}
+
+// Read the records on the free page, they are the old records of the page
+// before it was freed, label them with RecordFreePage and the page LSN.
+func (P *ParseIB) ParseFreePageRecords(page Page, columns []Columns) []Records {
+	var records []Records
+	for _, r := range P.ParsePageRecords(page.OriginalData,
+		len(page.OriginalData)-len(page.data), columns, false, 0) {
+		r.State = RecordFreePage
+		r.LSN = page.fh.FIL_PAGE_LSN
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestGetFreePages(t *testing.T) {
+	// The page 0 have the FSP_FREE_LIMIT 192, the first three extents are initialized.
+	d := MakeTestPage(FilPageTypeFspHdr, nil)
+	binary.BigEndian.PutUint32(d[4:], 0)
+	binary.BigEndian.PutUint32(d[FspHeaderOffset+FspFreeLimit:], 192)
+
+	// #define XDES_FREE_FRAG 2, #define XDES_FSEG 4
+	xdes := func(i uint64, state uint32) []byte {
+		entry := d[XdesArrOffset+i*XdesSize:]
+		binary.BigEndian.PutUint32(entry[XdesState:], state)
+		return entry[XdesBitmap:]
+	}
+
+	// The extent 0 belong to the segment, the page 5 is free, the page 6 only have
+	// the XDES_CLEAN_BIT, the bits of every byte are from the lowest bit.
+	bitmap := xdes(0, 4)
+	bitmap[1] = 0x04 | 0x20
+	// The extent 1 is free, every page of it is free.
+	xdes(1, uint32(XdesFree))
+	// The extent 2 is the fragment extent, the page 130 is free.
+	bitmap = xdes(2, 2)
+	bitmap[0] = 0x10
+
+	P := NewParseIB()
+	var data [][]byte
+	data = append(data, d)
+	for _, PageNo := range []uint32{3, 5, 6, 70, 129, 130, 200} {
+		page := MakeTestPage(FilPageIndex, nil)
+		binary.BigEndian.PutUint32(page[4:], PageNo)
+		data = append(data, page)
+	}
+	FreePages := P.GetFreePages(MakeTestPages(t, P, data...))
+
+	for PageNo, free := range map[uint64]bool{0: false, 3: false, 5: true, 6: false, 64: true,
+		70: true, 127: true, 128: false, 129: false, 130: true, 191: false, 200: true} {
+		if FreePages[PageNo] != free {
+			t.Errorf("the page %d is free %v, expected %v", PageNo, FreePages[PageNo], free)
+		}
+	}
+	if len(FreePages) != 64+3 {
+		t.Errorf("found %d free pages, expected 67", len(FreePages))
+	}
+
+	// The free pages can't be told without the extent descriptor page.
+	if FreePages := P.GetFreePages(MakeTestPages(t, P, data[1:]...)); len(FreePages) != 0 {
+		t.Errorf("found %d free pages without the extent descriptor page", len(FreePages))
+	}
+}
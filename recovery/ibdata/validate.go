@@ -0,0 +1,210 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Whether the records should be scored before output.
+func (P *ParseIB) ScoreEnabled() bool {
+	return P.MinConfidence > 0 || P.RejectedWriter != nil
+}
+
+// Score the records, and keep the records which confidence is not less than
+// the MinConfidence, the rejected records are written to the RejectedWriter.
+// The free list and carved records often decode into junk, use it between
+// the record parsing and the output.
+func (P *ParseIB) FilterRecords(AllRecords []Records, table string, database string) []Records {
+	if !P.ScoreEnabled() {
+		return AllRecords
+	}
+
+	var accepted []Records
+
+	for _, r := range AllRecords {
+		r.Confidence, r.Reasons = ScoreRecord(r.Columns)
+		if r.Confidence >= P.MinConfidence {
+			accepted = append(accepted, r)
+			continue
+		}
+
+		logs.Debug("reject the record at page ", r.PageNo, " offset ", r.Offset,
+			" confidence is ", r.Confidence, " reasons are ", r.Reasons)
+		if P.RejectedWriter == nil {
+			continue
+		}
+
+		P.WriteRejectedRecord(r, table, database)
+	}
+	return accepted
+}
+
+// Check every decoded value of the record against the column info, the
+// confidence is the ratio of the plausible values, 1 means all values are
+// plausible. The reasons tell which values are not plausible.
+func ScoreRecord(columns []Columns) (float64, []string) {
+	var reasons []string
+	checked := 0
+
+	for _, column := range columns {
+		// The internal columns and the dropped columns are not output.
+		if column.FieldName == "DB_ROW_ID" || column.FieldName == "DB_TRX_ID" ||
+			column.FieldName == "DB_ROLL_PTR" || column.VersionDropped != 0 {
+			continue
+		}
+
+		checked++
+		if reason := CheckColumnValue(column); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s %s", column.FieldName, reason))
+		}
+	}
+
+	if checked == 0 {
+		return 1, nil
+	}
+	return float64(checked-len(reasons)) / float64(checked), reasons
+}
+
+// Check one decoded value, return the reason when it is not plausible.
+func CheckColumnValue(column Columns) string {
+	value := column.FieldValue
+	if value == nil {
+		if !column.IsNUll {
+			return "is NULL in NOT NULL column"
+		}
+		return ""
+	}
+
+	switch column.FieldType {
+	case utils.DATA_INT:
+		switch column.MySQLType {
+		case utils.MYSQL_TYPE_DATE:
+			return CheckDateTimeValue(fmt.Sprintf("%v", value))
+		case utils.MYSQL_TYPE_ENUM:
+			// The value in the labels have been converted to the label.
+			if number, ok := value.(uint64); ok && len(column.Elements) != 0 {
+				if _, InLabels := utils.ParseEnum(number, column.Elements); !InLabels {
+					return fmt.Sprintf("enum index %d is out of the %d labels", number, len(column.Elements))
+				}
+			}
+			return ""
+		case utils.MYSQL_TYPE_SET:
+			if number, ok := value.(uint64); ok && len(column.Elements) != 0 {
+				if _, InLabels := utils.ParseSet(number, column.Elements); !InLabels {
+					return fmt.Sprintf("set bits 0x%x are out of the %d labels", number, len(column.Elements))
+				}
+			}
+			return ""
+		}
+		// The integer and the year are decoded from their own bytes, every
+		// value is in the range of the type, so they can't be checked.
+
+	case utils.DATA_FIXBINARY:
+		switch column.MySQLType {
+		case utils.MYSQL_TYPE_DATETIME, utils.MYSQL_TYPE_DATETIME2:
+			return CheckDateTimeValue(fmt.Sprintf("%v", value))
+		case utils.MYSQL_TYPE_TIMESTAMP, utils.MYSQL_TYPE_TIMESTAMP2:
+			return CheckTimestampValue(fmt.Sprintf("%v", value))
+		}
+
+	case utils.DATA_FLOAT, utils.DATA_DOUBLE:
+		var f float64
+		switch v := value.(type) {
+		case float32:
+			f = float64(v)
+		case float64:
+			f = v
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "is not a number"
+		}
+
+	case utils.DATA_VARCHAR, utils.DATA_CHAR, utils.DATA_VARMYSQL, utils.DATA_MYSQL, utils.DATA_BLOB:
+		if s, ok := value.(string); ok && !column.IsBinary {
+			return CheckStringValue(s, column.Charset)
+		}
+	}
+	return ""
+}
+
+// Check the date or datetime value formatted like 2019-01-02 03:04:05,
+// the year 0 and the invalid month, day, hour, minute and second are junk.
+func CheckDateTimeValue(value string) string {
+	var year, month, day, hour, min, sec int
+	n, _ := fmt.Sscanf(value, "%d-%d-%d %d:%d:%d", &year, &month, &day, &hour, &min, &sec)
+	if n < 3 {
+		return fmt.Sprintf("date %s is invalid", value)
+	}
+	if year == 0 || year > 9999 || month < 1 || month > 12 || day < 1 || day > 31 {
+		return fmt.Sprintf("date %s is invalid", value)
+	}
+	if hour > 23 || min > 59 || sec > 59 {
+		return fmt.Sprintf("time %s is invalid", value)
+	}
+	return ""
+}
+
+// Check the timestamp value formatted in the local time, the timestamp is
+// stored as the seconds since 1970-01-01 00:00:00 UTC, the max value is
+// 2038-01-19 03:14:07 UTC, the larger 4 bytes seconds are junk.
+func CheckTimestampValue(value string) string {
+	if value == "0000-00-00 00:00:00" {
+		return ""
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		return fmt.Sprintf("timestamp %s is invalid", value)
+	}
+	if t.Unix() < 1 || t.Unix() > math.MaxInt32 {
+		return fmt.Sprintf("timestamp %s is out of range", value)
+	}
+	return ""
+}
+
+// Check the string value, the control characters are junk, and the
+// value of the utf8 or utf8mb4 column should be valid utf8.
+func CheckStringValue(value string, charset uint64) string {
+	if IsUTF8Collation(charset) && !utf8.ValidString(value) {
+		return "is not valid utf8"
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return fmt.Sprintf("have control character 0x%02x", c)
+		}
+	}
+	return ""
+}
+
+// The collation id of utf8 and utf8mb4.
+// Reference mysql-8.0/strings/ctype-utf8.cc and ctype-uca.cc
+func IsUTF8Collation(id uint64) bool {
+	switch {
+	case id == 33 || id == 45 || id == 46 || id == 76 || id == 83:
+		return true
+	case id >= 192 && id <= 247:
+		return true
+	case id >= 255 && id <= 323:
+		return true
+	}
+	return false
+}
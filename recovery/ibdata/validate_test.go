@@ -0,0 +1,73 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+func TestScoreRecord(t *testing.T) {
+	id := Columns{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4}
+	c := func(column Columns, value interface{}) Columns {
+		column.FieldValue = value
+		return column
+	}
+	date := Columns{FieldName: "d", FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_DATE, IsNUll: true}
+	dt := Columns{FieldName: "dt", FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_DATETIME}
+	ts := Columns{FieldName: "ts", FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_TIMESTAMP}
+	f := Columns{FieldName: "f", FieldType: utils.DATA_DOUBLE}
+	s := Columns{FieldName: "s", FieldType: utils.DATA_VARMYSQL, Charset: 255}
+	enum := Columns{FieldName: "e", FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_ENUM,
+		Elements: []string{"a", "b"}}
+	set := Columns{FieldName: "st", FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_SET,
+		Elements: []string{"r", "w"}}
+	internal := Columns{FieldName: "DB_TRX_ID", FieldType: utils.DATA_SYS}
+
+	// The max timestamp in the local time.
+	MaxTimestamp := time.Unix(math.MaxInt32, 0).Format("2006-01-02 15:04:05")
+
+	for _, r := range []struct {
+		columns    []Columns
+		confidence float64
+		reasons    string
+	}{
+		{[]Columns{c(id, 1), c(date, "2019-01-02"), c(dt, "2019-01-02 03:04:05"), c(ts, MaxTimestamp),
+			c(f, 1.5), c(s, "abc"), c(enum, "b"), c(set, "r,w")}, 1, "[]"},
+		// The internal columns are not checked.
+		{[]Columns{c(internal, nil)}, 1, "[]"},
+		{[]Columns{c(id, nil), c(date, nil), c(internal, nil)}, 0.5, "[id is NULL in NOT NULL column]"},
+		{[]Columns{c(date, "0000-01-02"), c(dt, "2019-13-02 03:04:05")}, 0,
+			"[d date 0000-01-02 is invalid dt date 2019-13-02 03:04:05 is invalid]"},
+		{[]Columns{c(dt, "2019-01-02 24:00:00"), c(ts, "0000-00-00 00:00:00")}, 0.5,
+			"[dt time 2019-01-02 24:00:00 is invalid]"},
+		{[]Columns{c(ts, "2100-01-01 00:00:00"), c(f, math.NaN())}, 0,
+			"[ts timestamp 2100-01-01 00:00:00 is out of range f is not a number]"},
+		{[]Columns{c(s, "a\x01b"), c(s, "\xff")}, 0,
+			"[s have control character 0x01 s is not valid utf8]"},
+		// The value out of the labels is the number.
+		{[]Columns{c(enum, uint64(3)), c(set, uint64(4)), c(enum, ""), c(set, "")}, 0.5,
+			"[e enum index 3 is out of the 2 labels st set bits 0x4 are out of the 2 labels]"},
+	} {
+		confidence, reasons := ScoreRecord(r.columns)
+		if confidence != r.confidence || fmt.Sprint(reasons) != r.reasons {
+			t.Errorf("the score is %v %v, expected %v %s", confidence, reasons, r.confidence, r.reasons)
+		}
+	}
+}
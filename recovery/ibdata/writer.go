@@ -0,0 +1,142 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ibdata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The operation of the row written to the RowWriter.
+const (
+	// The row read from the data file, it should be inserted.
+	RowInsert string = "insert"
+
+	// The undo record of the update, the columns are the old values,
+	// the keys identify the row.
+	RowUpdate string = "update"
+
+	// The row identified by the keys should be deleted.
+	RowDelete string = "delete"
+)
+
+// Where the row is read from. The redo file only give the old values of
+// the update in the undo records written by MLOG_UNDO_INSERT, so its rows
+// are from the undo.
+const (
+	RowSourceData string = "data"
+	RowSourceUndo string = "undo"
+)
+
+// Store a recovered row which is written to the RowWriter.
+type Rows struct {
+	Op     string
+	Source string
+
+	// The file which the row is read from, the redo rows have all the
+	// redo log files.
+	File string
+
+	DBName    string
+	TableName string
+
+	// The table struct from the data dict, it provide the column info.
+	Table Tables
+
+	// The record columns and where the record come from, the columns may
+	// be a part of the table columns, such as the secondary index record.
+	Record Records
+
+	// The primary key columns which identify the row to update or delete.
+	Keys []Columns
+
+	// The label of the record, it is written as the comment.
+	Label string
+}
+
+// The RowWriter write the recovered rows in some format, such as the sql
+// statement, CSV or JSON Lines. The Close should be called at the end to
+// flush the buffered rows.
+type RowWriter interface {
+	WriteRow(row Rows) error
+	Close() error
+}
+
+// Whether the column is written to the output, the internal columns, the
+// columns dropped by instant drop column and the virtual generated columns
+// read from the secondary index are not, the server compute the virtual
+// column by itself.
+func IsOutputColumn(column Columns) bool {
+	if column.FieldName == "DB_ROW_ID" || column.FieldName == "DB_TRX_ID" ||
+		column.FieldName == "DB_ROLL_PTR" || column.IsVirtual {
+		return false
+	}
+	return column.VersionDropped == 0
+}
+
+// Get the columns which are written to the output.
+func OutputColumns(columns []Columns) []Columns {
+	var OutColumns []Columns
+	for _, column := range columns {
+		if IsOutputColumn(column) {
+			OutColumns = append(OutColumns, column)
+		}
+	}
+	return OutColumns
+}
+
+// Write the records to the Writer, every record is labeled with its state.
+func (P *ParseIB) WriteRecords(AllRecords []Records, table string, database string) {
+	if P.Writer == nil {
+		logs.Error("the row writer is not set, skip ", len(AllRecords), " records")
+		return
+	}
+
+	t, _ := P.GetTableFromDict(database, table)
+	for _, r := range AllRecords {
+		row := Rows{
+			Op:        RowInsert,
+			Source:    RowSourceData,
+			File:      P.File,
+			DBName:    database,
+			TableName: table,
+			Table:     t,
+			Record:    r,
+			Label:     P.MakeRecordLabel(r)}
+		if err := P.Writer.WriteRow(row); err != nil {
+			logs.Error("write the record failed, the error is ", err)
+		}
+	}
+}
+
+// Write the rejected record to the RejectedWriter, the label have the reasons.
+func (P *ParseIB) WriteRejectedRecord(r Records, table string, database string) {
+	t, _ := P.GetTableFromDict(database, table)
+	row := Rows{
+		Op:        RowInsert,
+		Source:    RowSourceData,
+		File:      P.File,
+		DBName:    database,
+		TableName: table,
+		Table:     t,
+		Record:    r,
+		Label: fmt.Sprintf("%s, page %d, offset %d: %s", P.MakeRecordLabel(r),
+			r.PageNo, r.Offset, strings.Join(r.Reasons, "; "))}
+	if err := P.RejectedWriter.WriteRow(row); err != nil {
+		logs.Error("write the rejected record failed, the error is ", err)
+	}
+}
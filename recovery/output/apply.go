@@ -0,0 +1,332 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The MySQL errors which roll back the transaction, the transaction is retried.
+// Reference mysql-5.7.19/include/mysqld_error.h
+const (
+	// #define ER_LOCK_WAIT_TIMEOUT 1205
+	ErLockWaitTimeout uint16 = 1205
+
+	// #define ER_LOCK_DEADLOCK 1213
+	ErLockDeadlock uint16 = 1213
+)
+
+// The statement applied to the target server, the key identify the row
+// in the logs when the statement failed. The statement is done when it is
+// committed, or prepared in the dry run.
+type ApplyStatements struct {
+	Query string
+	Key   string
+	Err   error
+	Done  bool
+}
+
+// Execute the statements on the target server through the database/sql.
+// The statements are executed in the transaction of every TxnStatements
+// statements, or one by one in autocommit when it is not set. When the
+// transaction is rolled back by the deadlock or the lock wait timeout, it
+// is retried from the first statement. The other failed statements don't
+// stop the transaction, every statement is logged with the result and the
+// row key. The DryRun only prepare the statements to check them. All
+// statements are executed on one pinned connection which have the session
+// variables, when it is lost, the apply stop instead of using a new
+// connection without them.
+type ApplyWriter struct {
+	db   *sql.DB
+	conn *sql.Conn
+	ctx  context.Context
+
+	Options Options
+	DryRun  bool
+	Retries int
+
+	// Make the statement of the row.
+	gen *SQLWriter
+
+	started bool
+	pending []ApplyStatements
+
+	Applied uint64
+	Failed  uint64
+}
+
+// Open the target server by the DSN, like user:password@tcp(127.0.0.1:3306)/.
+func OpenApplyWriter(dsn string, DryRun bool, retries int, opts Options) (*ApplyWriter, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		logs.Error("open the target server failed, the error is ", err)
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		logs.Error("connect to the target server failed, the error is ", err)
+		return nil, err
+	}
+	return NewApplyWriter(db, DryRun, retries, opts)
+}
+
+// Create the apply writer on the opened db, it is closed by the writer.
+// Use the database/sql driver of the local mysqld or a stand-in to test it.
+func NewApplyWriter(db *sql.DB, DryRun bool, retries int, opts Options) (*ApplyWriter, error) {
+	if opts.Dialect == DialectPostgres || opts.Format == FormatCopy {
+		db.Close()
+		ErrMsg := fmt.Sprintf("the statements applied to the MySQL server can't use the %s dialect",
+			DialectPostgres)
+		logs.Error(ErrMsg)
+		return nil, fmt.Errorf(ErrMsg)
+	}
+
+	verb, ok := SQLVerb(opts.Format)
+	if !ok {
+		verb, _ = SQLVerb(FormatReplace)
+	}
+
+	// The session variables are set on the pinned connection.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		logs.Error("get the connection of the target server failed, the error is ", err)
+		return nil, err
+	}
+
+	return &ApplyWriter{
+		db:      db,
+		conn:    conn,
+		ctx:     ctx,
+		Options: opts,
+		DryRun:  DryRun,
+		Retries: retries,
+		gen:     NewSQLWriter(ioutil.Discard, verb, opts)}, nil
+}
+
+func (A *ApplyWriter) WriteRow(row ibdata.Rows) error {
+	if err := A.Begin(); err != nil {
+		return err
+	}
+
+	// The statement is executed without the semicolon.
+	query := strings.TrimSuffix(A.gen.MakeSQL(row), ";")
+	A.pending = append(A.pending, ApplyStatements{Query: query, Key: RowKey(row)})
+	if len(A.pending) >= A.Options.TxnStatements {
+		return A.Flush()
+	}
+	return nil
+}
+
+func (A *ApplyWriter) Close() error {
+	FlushErr := A.Flush()
+	if A.started && A.Options.DisableChecks && !A.DryRun {
+		if _, err := A.conn.ExecContext(A.ctx, "SET unique_checks=1, foreign_key_checks=1"); err != nil {
+			logs.Error("restore the checks failed, the error is ", err)
+		}
+	}
+	A.conn.Close()
+	A.db.Close()
+
+	logs.Info("apply ", A.Applied, " statements, ", A.Failed, " statements failed")
+	if FlushErr != nil {
+		return FlushErr
+	}
+	if A.Failed != 0 {
+		return fmt.Errorf("%d of %d statements failed, see the logs for the statements and keys",
+			A.Failed, A.Applied+A.Failed)
+	}
+	return nil
+}
+
+// Set the session variables before the first statement.
+func (A *ApplyWriter) Begin() error {
+	if A.started {
+		return nil
+	}
+	A.started = true
+	if A.Options.DisableChecks && !A.DryRun {
+		if _, err := A.conn.ExecContext(A.ctx, "SET unique_checks=0, foreign_key_checks=0"); err != nil {
+			logs.Error("disable the checks failed, the error is ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply the pending statements, and retry when the transaction is rolled back.
+func (A *ApplyWriter) Flush() error {
+	if len(A.pending) == 0 {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		for i := range A.pending {
+			if !A.pending[i].Done {
+				A.pending[i].Err = nil
+			}
+		}
+
+		err = A.ApplyPending()
+		if !IsRetryableError(err) || attempt >= A.Retries {
+			break
+		}
+		logs.Warn("the transaction is rolled back, retry it, the attempt is ", attempt+1,
+			" the error is ", err)
+		time.Sleep(time.Duration(100<<uint(attempt)) * time.Millisecond)
+	}
+
+	for _, s := range A.pending {
+		// The statement is not committed when the error stop the apply,
+		// such as the transaction can't begin or commit.
+		if s.Err == nil && !s.Done {
+			s.Err = err
+		}
+		if s.Err != nil {
+			A.Failed++
+			logs.Error("apply the statement failed, the key is ", s.Key, " the error is ", s.Err,
+				" the statement is ", s.Query)
+			continue
+		}
+		A.Applied++
+		logs.Info("apply the statement succeed, the key is ", s.Key)
+		logs.Debug("the statement is ", s.Query)
+	}
+	A.pending = A.pending[:0]
+
+	// Only the connection error stop the apply.
+	if err != nil && !IsRetryableError(err) {
+		return err
+	}
+	return nil
+}
+
+// Execute or prepare the pending statements, return the error which stop
+// them, the error of every statement is stored with it.
+func (A *ApplyWriter) ApplyPending() error {
+	if A.DryRun {
+		for i := range A.pending {
+			stmt, err := A.conn.PrepareContext(A.ctx, A.pending[i].Query)
+			if err != nil {
+				A.pending[i].Err = err
+				continue
+			}
+			stmt.Close()
+			A.pending[i].Done = true
+		}
+		return nil
+	}
+
+	// The autocommit statements which are done are not executed again in the retry.
+	if A.Options.TxnStatements <= 1 {
+		for i := range A.pending {
+			if A.pending[i].Done || A.pending[i].Err != nil {
+				continue
+			}
+			if _, err := A.conn.ExecContext(A.ctx, A.pending[i].Query); err != nil {
+				if IsRetryableError(err) || IsConnError(err) {
+					return err
+				}
+				A.pending[i].Err = err
+				continue
+			}
+			A.pending[i].Done = true
+		}
+		return nil
+	}
+
+	tx, err := A.conn.BeginTx(A.ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i := range A.pending {
+		if _, err := tx.Exec(A.pending[i].Query); err != nil {
+			if IsRetryableError(err) || IsConnError(err) {
+				tx.Rollback()
+				return err
+			}
+			A.pending[i].Err = err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i := range A.pending {
+		A.pending[i].Done = A.pending[i].Err == nil
+	}
+	return nil
+}
+
+// Whether the transaction is rolled back by the deadlock or the lock wait timeout.
+func IsRetryableError(err error) bool {
+	if e, ok := err.(*mysql.MySQLError); ok {
+		return e.Number == ErLockDeadlock || e.Number == ErLockWaitTimeout
+	}
+	return false
+}
+
+// Whether the pinned connection is lost, the statements after it can't be
+// executed.
+func IsConnError(err error) bool {
+	return err == driver.ErrBadConn || err == sql.ErrConnDone
+}
+
+// Make the key of the row for the logs, the primary key columns of the
+// inserted row, or the keys of the updated and deleted row.
+func RowKey(row ibdata.Rows) string {
+	columns := row.Keys
+	if row.Op == ibdata.RowInsert {
+		var names []string
+		for _, idx := range row.Table.Indexes {
+			if idx.Name == "PRIMARY" {
+				for _, f := range idx.Fields {
+					names = append(names, f.ColumnName)
+				}
+			}
+		}
+		for _, name := range names {
+			for _, c := range row.Record.Columns {
+				if c.FieldName == name {
+					columns = append(columns, c)
+				}
+			}
+		}
+	}
+
+	var keys []string
+	for _, c := range columns {
+		keys = append(keys, QuoteMySQLName(c.FieldName)+"="+SQLLiteral(c))
+	}
+	key := QuoteMySQLName(row.DBName) + "." + QuoteMySQLName(row.TableName)
+	if len(keys) != 0 {
+		key += " " + strings.Join(keys, " and ")
+	}
+	if row.Record.PageNo != 0 {
+		key += fmt.Sprintf(" (page %d, offset %d)", row.Record.PageNo, row.Record.Offset)
+	}
+	return key
+}
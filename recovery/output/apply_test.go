@@ -0,0 +1,290 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// The stand-in database/sql driver, it records the committed statements,
+// and fails the begin, the commit or the statements which contain the text.
+type ApplyTestServer struct {
+	mu sync.Mutex
+
+	BeginErr  error
+	CommitErr error
+
+	// The statement which contain the key fail with the error, the
+	// times is how many times it fails, 0 means always.
+	ExecErr   map[string]error
+	ExecTimes map[string]int
+
+	Executed  []string
+	Committed []string
+
+	// The connections opened by the database/sql.
+	Conns int
+}
+
+type ApplyTestConn struct {
+	server  *ApplyTestServer
+	pending []string
+	InTx    bool
+}
+
+type ApplyTestStmt struct {
+	conn  *ApplyTestConn
+	query string
+}
+
+func (s *ApplyTestServer) Connect(ctx context.Context) (driver.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Conns++
+	return &ApplyTestConn{server: s}, nil
+}
+
+func (s *ApplyTestServer) Driver() driver.Driver {
+	return nil
+}
+
+func (c *ApplyTestConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.Contains(query, "syntax error") {
+		return nil, &mysql.MySQLError{Number: 1064, Message: "syntax error"}
+	}
+	return &ApplyTestStmt{conn: c, query: query}, nil
+}
+
+func (c *ApplyTestConn) Close() error {
+	return nil
+}
+
+func (c *ApplyTestConn) Begin() (driver.Tx, error) {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if c.server.BeginErr != nil {
+		return nil, c.server.BeginErr
+	}
+	c.InTx = true
+	c.pending = nil
+	return c, nil
+}
+
+func (c *ApplyTestConn) Commit() error {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	c.InTx = false
+	if c.server.CommitErr != nil {
+		return c.server.CommitErr
+	}
+	c.server.Committed = append(c.server.Committed, c.pending...)
+	return nil
+}
+
+func (c *ApplyTestConn) Rollback() error {
+	c.InTx = false
+	c.pending = nil
+	return nil
+}
+
+func (s *ApplyTestStmt) Close() error {
+	return nil
+}
+
+func (s *ApplyTestStmt) NumInput() int {
+	return -1
+}
+
+func (s *ApplyTestStmt) Exec(args []driver.Value) (driver.Result, error) {
+	server := s.conn.server
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.Executed = append(server.Executed, s.query)
+	for key, err := range server.ExecErr {
+		if !strings.Contains(s.query, key) {
+			continue
+		}
+		if times, ok := server.ExecTimes[key]; ok {
+			if times == 0 {
+				continue
+			}
+			server.ExecTimes[key] = times - 1
+		}
+		return nil, err
+	}
+
+	if s.conn.InTx {
+		s.conn.pending = append(s.conn.pending, s.query)
+	} else if !strings.HasPrefix(s.query, "SET ") {
+		server.Committed = append(server.Committed, s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *ApplyTestStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("query is not supported")
+}
+
+// Make the inserted row of the table test.t1 (id INT PRIMARY KEY).
+func MakeApplyTestRow(id int) ibdata.Rows {
+	column := ibdata.Columns{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4, FieldValue: id}
+	return ibdata.Rows{
+		Op:        ibdata.RowInsert,
+		Source:    ibdata.RowSourceData,
+		DBName:    "test",
+		TableName: "t1",
+		Table: ibdata.Tables{DBName: "test", TableName: "t1", Columns: []ibdata.Columns{column},
+			Indexes: map[uint64]ibdata.Indexes{1: {Name: "PRIMARY", Fields: []*ibdata.Fields{{ColumnName: "id"}}}}},
+		Record: ibdata.Records{Columns: []ibdata.Columns{column}},
+	}
+}
+
+// Apply the rows with the stand-in server, and return the writer after it is closed.
+func ApplyTestRows(t *testing.T, server *ApplyTestServer, DryRun bool, opts Options, n int) (*ApplyWriter, error) {
+	w, err := NewApplyWriter(sql.OpenDB(server), DryRun, 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		if err := w.WriteRow(MakeApplyTestRow(i)); err != nil {
+			w.Close()
+			return w, err
+		}
+	}
+	return w, w.Close()
+}
+
+func TestApplyTransaction(t *testing.T) {
+	server := &ApplyTestServer{}
+	w, err := ApplyTestRows(t, server, false, Options{Format: FormatInsert, TxnStatements: 2}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Applied != 3 || w.Failed != 0 || len(server.Committed) != 3 {
+		t.Errorf("applied %d, failed %d, committed %v", w.Applied, w.Failed, server.Committed)
+	}
+	if server.Committed[0] != "insert into `test`.`t1` (`id`) values (1)" {
+		t.Errorf("the statement is %s", server.Committed[0])
+	}
+}
+
+// The statements are failed when the transaction can't begin or commit.
+func TestApplyTransactionFailed(t *testing.T) {
+	for _, server := range []*ApplyTestServer{
+		{BeginErr: errors.New("bad connection")},
+		{CommitErr: &mysql.MySQLError{Number: 1180, Message: "got error during COMMIT"}},
+	} {
+		w, err := ApplyTestRows(t, server, false, Options{Format: FormatInsert, TxnStatements: 2}, 2)
+		if err == nil {
+			t.Error("the failed transaction is not reported")
+		}
+		if w.Applied != 0 || w.Failed != 2 || len(server.Committed) != 0 {
+			t.Errorf("applied %d, failed %d, committed %v", w.Applied, w.Failed, server.Committed)
+		}
+	}
+}
+
+// The transaction rolled back by the deadlock is retried from the first statement.
+func TestApplyRetryDeadlock(t *testing.T) {
+	server := &ApplyTestServer{
+		ExecErr:   map[string]error{"(2)": &mysql.MySQLError{Number: ErLockDeadlock}},
+		ExecTimes: map[string]int{"(2)": 1},
+	}
+	w, err := ApplyTestRows(t, server, false, Options{Format: FormatInsert, TxnStatements: 2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Applied != 2 || w.Failed != 0 || len(server.Committed) != 2 || len(server.Executed) != 4 {
+		t.Errorf("applied %d, failed %d, committed %v, executed %v",
+			w.Applied, w.Failed, server.Committed, server.Executed)
+	}
+}
+
+// The failed autocommit statement don't stop the others, and the done
+// statements are not executed again in the retry.
+func TestApplyAutocommit(t *testing.T) {
+	server := &ApplyTestServer{
+		ExecErr: map[string]error{
+			"(2)": &mysql.MySQLError{Number: 1062, Message: "duplicate entry"},
+			"(3)": &mysql.MySQLError{Number: ErLockWaitTimeout}},
+		ExecTimes: map[string]int{"(3)": 1},
+	}
+	w, err := ApplyTestRows(t, server, false, Options{Format: FormatInsert, TxnStatements: 1}, 3)
+	if err == nil {
+		t.Error("the failed statement is not reported")
+	}
+	if w.Applied != 2 || w.Failed != 1 {
+		t.Errorf("applied %d, failed %d", w.Applied, w.Failed)
+	}
+	// The statement 1 is not executed again after the lock wait timeout.
+	if fmt.Sprint(server.Committed) != "[insert into `test`.`t1` (`id`) values (1) insert into `test`.`t1` (`id`) values (3)]" {
+		t.Errorf("committed %v", server.Committed)
+	}
+}
+
+// The dry run only prepare the statements.
+func TestApplyDryRun(t *testing.T) {
+	server := &ApplyTestServer{}
+	w, err := ApplyTestRows(t, server, true, Options{Format: FormatInsert, TxnStatements: 2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Applied != 2 || len(server.Executed) != 0 {
+		t.Errorf("applied %d, executed %v", w.Applied, server.Executed)
+	}
+}
+
+func TestApplyPostgresDialect(t *testing.T) {
+	if _, err := NewApplyWriter(sql.OpenDB(&ApplyTestServer{}), false, 1,
+		Options{Format: FormatInsert, Dialect: DialectPostgres}); err == nil {
+		t.Error("the postgres dialect is not rejected")
+	}
+}
+
+// The lost connection is not replaced by a new connection without the
+// session variables, the apply stop at the statement.
+func TestApplyPinnedConnection(t *testing.T) {
+	server := &ApplyTestServer{
+		ExecErr:   map[string]error{"(2)": driver.ErrBadConn},
+		ExecTimes: map[string]int{"(2)": 1}}
+	w, err := ApplyTestRows(t, server, false, Options{DisableChecks: true}, 3)
+	if err != driver.ErrBadConn {
+		t.Errorf("the error is %v, expected the bad connection", err)
+	}
+	if server.Conns != 1 || w.Applied != 1 || w.Failed != 1 {
+		t.Errorf("open %d connections, apply %d statements, %d statements failed",
+			server.Conns, w.Applied, w.Failed)
+	}
+	expected := []string{
+		"SET unique_checks=0, foreign_key_checks=0",
+		"replace into `test`.`t1` (`id`) values (1)",
+		"replace into `test`.`t1` (`id`) values (2)",
+	}
+	if fmt.Sprint(server.Executed) != fmt.Sprint(expected) {
+		t.Errorf("the executed statements are %q, expected %q", server.Executed, expected)
+	}
+}
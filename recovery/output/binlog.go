@@ -0,0 +1,515 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+)
+
+// The binlog event types which are written.
+// Reference mysql-5.7.19/libbinlogevents/include/binlog_event.h
+const (
+	QUERY_EVENT              byte = 2
+	FORMAT_DESCRIPTION_EVENT byte = 15
+	XID_EVENT                byte = 16
+	TABLE_MAP_EVENT          byte = 19
+	WRITE_ROWS_EVENT         byte = 30
+	UPDATE_ROWS_EVENT        byte = 31
+	DELETE_ROWS_EVENT        byte = 32
+)
+
+const (
+	// The binlog file begin with the magic number.
+	BinlogMagic string = "\xfebin"
+
+	// The binlog version 4 is used since MySQL 5.0.
+	BinlogVersion uint16 = 4
+
+	// The server version in the format description event, the version
+	// after 5.6.1 tell the reader that the events have the checksum.
+	BinlogServerVersion string = "5.7.19-log"
+
+	// The common header of every event.
+	// timestamp(4) type(1) server_id(4) event_size(4) log_pos(4) flags(2)
+	LOG_EVENT_HEADER_LEN int = 19
+
+	// The events end with the CRC32 checksum.
+	BINLOG_CHECKSUM_ALG_CRC32 byte = 1
+	BINLOG_CHECKSUM_LEN       int  = 4
+)
+
+// The flags of the table map and rows events.
+// Reference mysql-5.7.19/libbinlogevents/include/rows_event.h
+const (
+	TM_BIT_LEN_EXACT_F uint16 = 1
+
+	STMT_END_F              uint16 = 1
+	NO_FOREIGN_KEY_CHECKS_F uint16 = 2
+	RELAXED_UNIQUE_CHECKS_F uint16 = 4
+)
+
+// The optional metadata of the table map event, added by MySQL 8.0.1,
+// the readers of the older versions ignore it.
+const (
+	TM_SIGNEDNESS  byte = 1
+	TM_COLUMN_NAME byte = 4
+)
+
+// The post header length of every event type in the format description
+// event, it is the same as MySQL 5.7 write.
+var BinlogPostHeaderLen = []byte{
+	56, 13, 0, 8, 0, 18, 0, 4, 4, 4, 4, 18, 0, 0, 95, 0, 4, 26, 8, 0, 0, 0, 8, 8, 8, 2, 0, 0, 0,
+	10, 10, 10, 42, 42, 0, 18, 52, 0}
+
+// Write the rows as the MySQL row based binlog version 4, the file can be
+// read by mysqlbinlog and replayed by mysqlbinlog | mysql, or read by the
+// CDC tools. Every rows event is preceded by the table map event, the rows
+// of the same table and operation are put in one rows event up to the
+// BatchRows and the MaxStatementBytes, and the rows events are put in the
+// transaction of BEGIN and XID every TxnStatements events.
+// Reference mysql-5.7.19/libbinlogevents/src/control_events.cpp
+// Reference mysql-5.7.19/libbinlogevents/src/rows_event.cpp
+type BinlogWriter struct {
+	w *bufio.Writer
+
+	Options Options
+
+	ServerID  uint32
+	Timestamp uint32
+
+	// Whether the magic number and the format description event have been written.
+	started bool
+
+	// The position of the next event in the file.
+	pos uint32
+
+	// The tables which have been mapped, the key is db.table.
+	Tables      map[string]*BinlogTables
+	NextTableID uint64
+
+	// The rows event which is not written.
+	table     *BinlogTables
+	EventType byte
+	before    []bool
+	after     []bool
+	rows      []byte
+	RowCount  int
+
+	// The rows events in the current transaction.
+	InTxn  bool
+	events int
+	xid    uint64
+}
+
+// The table of the table map event, the columns are all output columns of
+// the table in the order of the table definition.
+type BinlogTables struct {
+	ID        uint64
+	DBName    string
+	TableName string
+	Columns   []BinlogColumns
+}
+
+func NewBinlogWriter(w io.Writer, opts Options) *BinlogWriter {
+	return &BinlogWriter{
+		w:           bufio.NewWriter(w),
+		Options:     opts,
+		ServerID:    1,
+		Timestamp:   uint32(time.Now().Unix()),
+		Tables:      make(map[string]*BinlogTables),
+		NextTableID: 1}
+}
+
+func (B *BinlogWriter) WriteRow(row ibdata.Rows) error {
+	if err := B.Begin(); err != nil {
+		return err
+	}
+
+	table, err := B.GetTable(row)
+	if err != nil {
+		return err
+	}
+
+	// The insert have the after image, the delete have the before image
+	// with the keys, and the update have both.
+	var EventType byte
+	var BeforeColumns, AfterColumns []ibdata.Columns
+	switch row.Op {
+	case ibdata.RowInsert:
+		EventType = WRITE_ROWS_EVENT
+		AfterColumns = ibdata.OutputColumns(row.Record.Columns)
+	case ibdata.RowUpdate:
+		EventType = UPDATE_ROWS_EVENT
+		BeforeColumns = row.Keys
+		AfterColumns = ibdata.OutputColumns(row.Record.Columns)
+	case ibdata.RowDelete:
+		EventType = DELETE_ROWS_EVENT
+		BeforeColumns = row.Keys
+	default:
+		return fmt.Errorf("unknown row operation %s", row.Op)
+	}
+
+	var before, after []bool
+	var image []byte
+	if EventType != WRITE_ROWS_EVENT {
+		if before, image, err = table.MakeRowImage(image, BeforeColumns); err != nil {
+			return err
+		}
+	}
+	if EventType != DELETE_ROWS_EVENT {
+		if after, image, err = table.MakeRowImage(image, AfterColumns); err != nil {
+			return err
+		}
+	}
+
+	if B.table != nil && (B.table != table || B.EventType != EventType ||
+		!SameBitmap(B.before, before) || !SameBitmap(B.after, after) ||
+		B.RowCount >= B.Options.BatchRows ||
+		(B.Options.MaxStatementBytes > 0 && len(B.rows)+len(image) > B.Options.MaxStatementBytes)) {
+		if err := B.FlushRows(); err != nil {
+			return err
+		}
+	}
+
+	B.table, B.EventType, B.before, B.after = table, EventType, before, after
+	B.rows = append(B.rows, image...)
+	B.RowCount++
+	return nil
+}
+
+func (B *BinlogWriter) Close() error {
+	if err := B.Begin(); err != nil {
+		return err
+	}
+	if err := B.FlushRows(); err != nil {
+		return err
+	}
+	if B.InTxn {
+		if err := B.WriteXID(); err != nil {
+			return err
+		}
+	}
+	return B.w.Flush()
+}
+
+// Write the magic number and the format description event.
+func (B *BinlogWriter) Begin() error {
+	if B.started {
+		return nil
+	}
+	B.started = true
+
+	if _, err := B.w.WriteString(BinlogMagic); err != nil {
+		return err
+	}
+	B.pos = uint32(len(BinlogMagic))
+
+	// binlog_version(2) server_version(50) create_timestamp(4) header_length(1)
+	// post_header_len(38) checksum_alg(1)
+	body := make([]byte, 0, 96)
+	body = AppendUint(body, uint64(BinlogVersion), 2)
+	version := make([]byte, 50)
+	copy(version, BinlogServerVersion)
+	body = append(body, version...)
+	body = AppendUint(body, uint64(B.Timestamp), 4)
+	body = append(body, byte(LOG_EVENT_HEADER_LEN))
+	body = append(body, BinlogPostHeaderLen...)
+	body = append(body, BINLOG_CHECKSUM_ALG_CRC32)
+	return B.WriteEvent(FORMAT_DESCRIPTION_EVENT, body)
+}
+
+// Get the mapped table of the row, the columns are from the table of the
+// data dictionary, or from the row when the table is unknown.
+func (B *BinlogWriter) GetTable(row ibdata.Rows) (*BinlogTables, error) {
+	key := row.DBName + "." + row.TableName
+	if table, ok := B.Tables[key]; ok {
+		return table, nil
+	}
+
+	columns := ibdata.OutputColumns(row.Table.Columns)
+	if len(columns) == 0 {
+		columns = RowColumns(row)
+	}
+
+	table := &BinlogTables{ID: B.NextTableID, DBName: row.DBName, TableName: row.TableName}
+	for _, c := range columns {
+		bc, err := MakeBinlogColumn(c)
+		if err != nil {
+			return nil, fmt.Errorf("map the column %s of %s failed, %v", c.FieldName, key, err)
+		}
+		table.Columns = append(table.Columns, bc)
+	}
+
+	B.NextTableID++
+	B.Tables[key] = table
+	return table, nil
+}
+
+// Write the pending rows event, and the table map event before it.
+func (B *BinlogWriter) FlushRows() error {
+	if B.table == nil {
+		return nil
+	}
+
+	if !B.InTxn {
+		if err := B.WriteQuery("BEGIN"); err != nil {
+			return err
+		}
+		B.InTxn = true
+	}
+	if err := B.WriteTableMap(B.table); err != nil {
+		return err
+	}
+
+	// table_id(6) flags(2) extra_data_len(2) width columns_before columns_after rows
+	flags := STMT_END_F
+	if B.Options.DisableChecks {
+		flags |= NO_FOREIGN_KEY_CHECKS_F | RELAXED_UNIQUE_CHECKS_F
+	}
+	body := AppendUint(nil, B.table.ID, 6)
+	body = AppendUint(body, uint64(flags), 2)
+	body = AppendUint(body, 2, 2)
+	body = AppendLengthEncoded(body, uint64(len(B.table.Columns)))
+	if B.EventType != WRITE_ROWS_EVENT {
+		body = append(body, MakeBitmap(B.before)...)
+	}
+	if B.EventType != DELETE_ROWS_EVENT {
+		body = append(body, MakeBitmap(B.after)...)
+	}
+	body = append(body, B.rows...)
+	if err := B.WriteEvent(B.EventType, body); err != nil {
+		return err
+	}
+
+	B.table, B.before, B.after, B.rows, B.RowCount = nil, nil, nil, B.rows[:0], 0
+
+	B.events++
+	if B.events >= B.Options.TxnStatements {
+		return B.WriteXID()
+	}
+	return nil
+}
+
+// Write the query event, it is only used for the BEGIN.
+func (B *BinlogWriter) WriteQuery(query string) error {
+	// thread_id(4) exec_time(4) db_len(1) error_code(2) status_vars_len(2) db 0x00 query
+	body := AppendUint(nil, 1, 4)
+	body = AppendUint(body, 0, 4)
+	body = append(body, 0)
+	body = AppendUint(body, 0, 2)
+	body = AppendUint(body, 0, 2)
+	body = append(body, 0)
+	body = append(body, query...)
+	return B.WriteEvent(QUERY_EVENT, body)
+}
+
+// Write the XID event which commit the transaction.
+func (B *BinlogWriter) WriteXID() error {
+	B.xid++
+	if err := B.WriteEvent(XID_EVENT, AppendUint(nil, B.xid, 8)); err != nil {
+		return err
+	}
+	B.InTxn = false
+	B.events = 0
+	return nil
+}
+
+// Write the table map event of the table.
+func (B *BinlogWriter) WriteTableMap(table *BinlogTables) error {
+	// table_id(6) flags(2) db_len(1) db 0x00 table_len(1) table 0x00 width
+	// column_types metadata_len metadata null_bitmap optional_metadata
+	body := AppendUint(nil, table.ID, 6)
+	body = AppendUint(body, uint64(TM_BIT_LEN_EXACT_F), 2)
+	body = append(body, byte(len(table.DBName)))
+	body = append(body, table.DBName...)
+	body = append(body, 0)
+	body = append(body, byte(len(table.TableName)))
+	body = append(body, table.TableName...)
+	body = append(body, 0)
+	body = AppendLengthEncoded(body, uint64(len(table.Columns)))
+
+	var meta []byte
+	nulls := make([]bool, len(table.Columns))
+	for i, c := range table.Columns {
+		body = append(body, c.Type)
+		meta = append(meta, c.Meta...)
+		nulls[i] = c.Column.IsNUll
+	}
+	body = AppendLengthEncoded(body, uint64(len(meta)))
+	body = append(body, meta...)
+	body = append(body, MakeBitmap(nulls)...)
+
+	// The signedness of the numeric columns, the highest bit first.
+	var signs []byte
+	n := 0
+	for _, c := range table.Columns {
+		if !IsBinlogNumeric(c.Type) {
+			continue
+		}
+		if n%8 == 0 {
+			signs = append(signs, 0)
+		}
+		if c.Column.IsUnsigned {
+			signs[n/8] |= 0x80 >> uint(n%8)
+		}
+		n++
+	}
+	if len(signs) != 0 {
+		body = append(body, TM_SIGNEDNESS)
+		body = AppendLengthEncoded(body, uint64(len(signs)))
+		body = append(body, signs...)
+	}
+
+	var names []byte
+	for _, c := range table.Columns {
+		names = AppendLengthEncoded(names, uint64(len(c.Column.FieldName)))
+		names = append(names, c.Column.FieldName...)
+	}
+	body = append(body, TM_COLUMN_NAME)
+	body = AppendLengthEncoded(body, uint64(len(names)))
+	body = append(body, names...)
+
+	return B.WriteEvent(TABLE_MAP_EVENT, body)
+}
+
+// Write the event with the common header and the checksum.
+func (B *BinlogWriter) WriteEvent(EventType byte, body []byte) error {
+	size := uint32(LOG_EVENT_HEADER_LEN + len(body) + BINLOG_CHECKSUM_LEN)
+	event := make([]byte, 0, size)
+	event = AppendUint(event, uint64(B.Timestamp), 4)
+	event = append(event, EventType)
+	event = AppendUint(event, uint64(B.ServerID), 4)
+	event = AppendUint(event, uint64(size), 4)
+	event = AppendUint(event, uint64(B.pos+size), 4)
+	event = AppendUint(event, 0, 2)
+	event = append(event, body...)
+	event = AppendUint(event, uint64(crc32.ChecksumIEEE(event)), 4)
+
+	if _, err := B.w.Write(event); err != nil {
+		return err
+	}
+	B.pos += size
+	return nil
+}
+
+// Make the row image of the columns, return the bitmap of the columns
+// in the image and append the image to buf. The image is the null bitmap
+// of the columns in the image, followed by the values which are not NULL.
+func (T *BinlogTables) MakeRowImage(buf []byte, columns []ibdata.Columns) ([]bool, []byte, error) {
+	present := make([]bool, len(T.Columns))
+	values := make([]*ibdata.Columns, len(T.Columns))
+	for i := range columns {
+		pos := T.ColumnPos(columns[i].FieldName)
+		if pos < 0 {
+			return nil, buf, fmt.Errorf("the column %s is not in the table %s.%s",
+				columns[i].FieldName, T.DBName, T.TableName)
+		}
+		present[pos] = true
+		values[pos] = &columns[i]
+	}
+
+	var nulls []bool
+	for i := range T.Columns {
+		if present[i] {
+			nulls = append(nulls, IsNull(*values[i]))
+		}
+	}
+	buf = append(buf, MakeBitmap(nulls)...)
+
+	var err error
+	for i, c := range T.Columns {
+		if !present[i] || IsNull(*values[i]) {
+			continue
+		}
+		if buf, err = c.AppendValue(buf, *values[i]); err != nil {
+			return nil, buf, fmt.Errorf("the value of the column %s in %s.%s is invalid, %v",
+				c.Column.FieldName, T.DBName, T.TableName, err)
+		}
+	}
+	return present, buf, nil
+}
+
+// Get the position of the column in the table, -1 if not found.
+func (T *BinlogTables) ColumnPos(name string) int {
+	for i, c := range T.Columns {
+		if c.Column.FieldName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Make the bitmap, the first bit is the lowest bit of the first byte.
+func MakeBitmap(bits []bool) []byte {
+	bitmap := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return bitmap
+}
+
+// Whether the bitmaps are the same.
+func SameBitmap(a []bool, b []bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Append the little endian unsigned integer of n bytes.
+func AppendUint(buf []byte, v uint64, n int) []byte {
+	for i := 0; i < n; i++ {
+		buf = append(buf, byte(v>>(uint(i)*8)))
+	}
+	return buf
+}
+
+// Append the big endian unsigned integer of n bytes.
+func AppendUintBE(buf []byte, v uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(uint(i)*8)))
+	}
+	return buf
+}
+
+// Append the length encoded integer.
+// Reference mysql-5.7.19/libbinlogevents/src/binary_log_funcs.cpp
+func AppendLengthEncoded(buf []byte, v uint64) []byte {
+	switch {
+	case v < 251:
+		return append(buf, byte(v))
+	case v < 1<<16:
+		return AppendUint(append(buf, 0xFC), v, 2)
+	case v < 1<<24:
+		return AppendUint(append(buf, 0xFD), v, 3)
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(append(buf, 0xFE), b[:]...)
+}
@@ -0,0 +1,450 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The column of the table map event, the type and the metadata are
+// converted from the InnoDB type of the column.
+// Reference mysql-5.7.19/sql/field.cc save_field_metadata
+type BinlogColumns struct {
+	Column ibdata.Columns
+	Type   byte
+	Meta   []byte
+}
+
+// Warn once for every ENUM/SET column which can't be told apart.
+var WarnedBinlogElements sync.Map
+
+// Convert the column to the binlog column type and the metadata. The
+// temporal columns use the MySQL 5.6 format, the fractional seconds are
+// told by the bytes, the DECIMAL column need the precision and scale
+// from the table struct.
+func MakeBinlogColumn(c ibdata.Columns) (BinlogColumns, error) {
+	bc := BinlogColumns{Column: c}
+
+	switch c.FieldType {
+	case utils.DATA_INT:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_DATE:
+			bc.Type = byte(utils.MYSQL_TYPE_DATE)
+		case utils.MYSQL_TYPE_YEAR:
+			bc.Type = byte(utils.MYSQL_TYPE_YEAR)
+		case utils.MYSQL_TYPE_ENUM, utils.MYSQL_TYPE_SET, utils.MYSQL_TYPE_STRING:
+			// The ENUM and SET are logged as the string type, the real
+			// type and the pack length are in the metadata.
+			RealType := byte(utils.MYSQL_TYPE_ENUM)
+			if c.MySQLType == utils.MYSQL_TYPE_SET {
+				RealType = byte(utils.MYSQL_TYPE_SET)
+			} else if c.MySQLType == utils.MYSQL_TYPE_STRING {
+				key := fmt.Sprintf("%d.%s", c.TableID, c.FieldName)
+				if _, warned := WarnedBinlogElements.LoadOrStore(key, true); !warned {
+					logs.Warn("the column ", c.FieldName, " may be ENUM or SET, log it as ENUM, ",
+						"identify the table struct to tell them apart.")
+				}
+			}
+			bc.Type = byte(utils.MYSQL_TYPE_STRING)
+			bc.Meta = []byte{RealType, byte(c.FieldLen)}
+		default:
+			switch c.FieldLen {
+			case 1:
+				bc.Type = byte(utils.MYSQL_TYPE_TINY)
+			case 2:
+				bc.Type = byte(utils.MYSQL_TYPE_SHORT)
+			case 3:
+				bc.Type = byte(utils.MYSQL_TYPE_INT24)
+			case 4:
+				bc.Type = byte(utils.MYSQL_TYPE_LONG)
+			case 8:
+				bc.Type = byte(utils.MYSQL_TYPE_LONGLONG)
+			default:
+				return bc, fmt.Errorf("the integer length %d is invalid", c.FieldLen)
+			}
+		}
+
+	case utils.DATA_FLOAT:
+		bc.Type = byte(utils.MYSQL_TYPE_FLOAT)
+		bc.Meta = []byte{4}
+
+	case utils.DATA_DOUBLE:
+		bc.Type = byte(utils.MYSQL_TYPE_DOUBLE)
+		bc.Meta = []byte{8}
+
+	case utils.DATA_FIXBINARY:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_TIME:
+			bc.Type = byte(utils.MYSQL_TYPE_TIME2)
+			bc.Meta = []byte{FracDigits(c.FieldLen, 3)}
+		case utils.MYSQL_TYPE_TIMESTAMP:
+			bc.Type = byte(utils.MYSQL_TYPE_TIMESTAMP2)
+			bc.Meta = []byte{FracDigits(c.FieldLen, 4)}
+		case utils.MYSQL_TYPE_DATETIME:
+			bc.Type = byte(utils.MYSQL_TYPE_DATETIME2)
+			bc.Meta = []byte{FracDigits(c.FieldLen, 5)}
+		case utils.MYSQL_TYPE_BIT:
+			// The bits are unknown, log all bits of the bytes.
+			bc.Type = byte(utils.MYSQL_TYPE_BIT)
+			bc.Meta = []byte{0, byte(c.FieldLen)}
+		case utils.MYSQL_TYPE_NEWDECIMAL:
+			if c.Precision == 0 {
+				return bc, fmt.Errorf("the DECIMAL precision is unknown, identify the table struct")
+			}
+			bc.Type = byte(utils.MYSQL_TYPE_NEWDECIMAL)
+			bc.Meta = []byte{byte(c.Precision), byte(c.Scale)}
+		default:
+			bc.Type, bc.Meta = byte(utils.MYSQL_TYPE_STRING), StringMeta(c.FieldLen)
+		}
+
+	case utils.DATA_CHAR, utils.DATA_MYSQL:
+		bc.Type, bc.Meta = byte(utils.MYSQL_TYPE_STRING), StringMeta(c.FieldLen)
+
+	case utils.DATA_VARCHAR, utils.DATA_VARMYSQL, utils.DATA_BINARY:
+		MaxLen := c.MaxLen
+		if MaxLen == 0 {
+			MaxLen = c.FieldLen
+		}
+		if MaxLen == 0 || MaxLen > math.MaxUint16 {
+			MaxLen = math.MaxUint16
+		}
+		bc.Type = byte(utils.MYSQL_TYPE_VARCHAR)
+		bc.Meta = AppendUint(nil, MaxLen, 2)
+
+	case utils.DATA_BLOB:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_JSON:
+			bc.Type, bc.Meta = byte(utils.MYSQL_TYPE_JSON), []byte{4}
+		case utils.MYSQL_TYPE_GEOMETRY:
+			bc.Type, bc.Meta = byte(utils.MYSQL_TYPE_GEOMETRY), []byte{4}
+		default:
+			// The length of the blob in the dictionary is the bytes of
+			// the length and the 8 bytes pointer.
+			PackLen := byte(4)
+			if c.FieldLen > 8 && c.FieldLen <= 12 {
+				PackLen = byte(c.FieldLen - 8)
+			}
+			bc.Type, bc.Meta = byte(utils.MYSQL_TYPE_BLOB), []byte{PackLen}
+		}
+
+	case utils.DATA_GEOMETRY, utils.DATA_VAR_POINT, utils.DATA_POINT:
+		bc.Type, bc.Meta = byte(utils.MYSQL_TYPE_GEOMETRY), []byte{4}
+
+	default:
+		return bc, fmt.Errorf("the InnoDB type %d is not supported", c.FieldType)
+	}
+	return bc, nil
+}
+
+// The fractional seconds digits of the temporal column, the extra bytes
+// after the integer part store 2 digits per byte.
+func FracDigits(FieldLen uint64, IntLen uint64) byte {
+	if FieldLen <= IntLen || FieldLen > IntLen+3 {
+		return 0
+	}
+	return byte((FieldLen - IntLen) * 2)
+}
+
+// The metadata of the string column, the real type and the length, the
+// high bits of the length are stored in the real type byte.
+// Reference mysql-5.7.19/sql/field.cc Field_string::do_save_field_metadata
+func StringMeta(length uint64) []byte {
+	return []byte{byte(utils.MYSQL_TYPE_STRING) ^ byte((length&0x300)>>4), byte(length & 0xFF)}
+}
+
+// The max length of the string column in the metadata.
+func StringMetaLen(meta []byte) uint64 {
+	if meta[0]&0x30 != 0x30 {
+		return uint64(meta[1]) | uint64((meta[0]&0x30)^0x30)<<4
+	}
+	return uint64(meta[1])
+}
+
+// Whether the binlog column type is numeric, it have the signedness.
+func IsBinlogNumeric(t byte) bool {
+	switch uint64(t) {
+	case utils.MYSQL_TYPE_TINY, utils.MYSQL_TYPE_SHORT, utils.MYSQL_TYPE_INT24, utils.MYSQL_TYPE_LONG,
+		utils.MYSQL_TYPE_LONGLONG, utils.MYSQL_TYPE_FLOAT, utils.MYSQL_TYPE_DOUBLE,
+		utils.MYSQL_TYPE_NEWDECIMAL:
+		return true
+	}
+	return false
+}
+
+// Append the value of the column in the binlog row format.
+// Reference mysql-5.7.19/libbinlogevents/src/value.cpp
+func (bc BinlogColumns) AppendValue(buf []byte, c ibdata.Columns) ([]byte, error) {
+	switch uint64(bc.Type) {
+	case utils.MYSQL_TYPE_TINY, utils.MYSQL_TYPE_SHORT, utils.MYSQL_TYPE_INT24, utils.MYSQL_TYPE_LONG,
+		utils.MYSQL_TYPE_LONGLONG:
+		v, err := IntBits(c.FieldValue)
+		if err != nil {
+			return buf, err
+		}
+		return AppendUint(buf, v, int(bc.Column.FieldLen)), nil
+
+	case utils.MYSQL_TYPE_YEAR:
+		v, err := IntBits(c.FieldValue)
+		if err != nil {
+			return buf, err
+		}
+		if v >= 1900 {
+			v -= 1900
+		}
+		return append(buf, byte(v)), nil
+
+	case utils.MYSQL_TYPE_DATE:
+		var year, month, day uint64
+		if _, err := fmt.Sscanf(TextValue(c), "%d-%d-%d", &year, &month, &day); err != nil {
+			return buf, err
+		}
+		return AppendUint(buf, day|month<<5|year<<9, 3), nil
+
+	case utils.MYSQL_TYPE_FLOAT:
+		f, err := FloatValue(c.FieldValue)
+		if err != nil {
+			return buf, err
+		}
+		return AppendUint(buf, uint64(math.Float32bits(float32(f))), 4), nil
+
+	case utils.MYSQL_TYPE_DOUBLE:
+		f, err := FloatValue(c.FieldValue)
+		if err != nil {
+			return buf, err
+		}
+		return AppendUint(buf, math.Float64bits(f), 8), nil
+
+	case utils.MYSQL_TYPE_TIME2:
+		// The integer part is hour<<12 | minute<<6 | second with the offset
+		// 0x800000, the fractional part is always 0.
+		s := TextValue(c)
+		negative := strings.HasPrefix(s, "-")
+		var hour, minute, second int64
+		if _, err := fmt.Sscanf(strings.TrimPrefix(s, "-"), "%d:%d:%d", &hour, &minute, &second); err != nil {
+			return buf, err
+		}
+		v := hour<<12 | minute<<6 | second
+		if negative {
+			v = -v
+		}
+		buf = AppendUintBE(buf, uint64(0x800000+v), 3)
+		return append(buf, make([]byte, (bc.Meta[0]+1)/2)...), nil
+
+	case utils.MYSQL_TYPE_TIMESTAMP2:
+		var seconds int64
+		if s := TextValue(c); s != "0000-00-00 00:00:00" {
+			t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+			if err != nil {
+				return buf, err
+			}
+			seconds = t.Unix()
+		}
+		buf = AppendUintBE(buf, uint64(seconds), 4)
+		return append(buf, make([]byte, (bc.Meta[0]+1)/2)...), nil
+
+	case utils.MYSQL_TYPE_DATETIME2:
+		// year*13+month, day, hour, minute and second are packed in 40 bits
+		// with the offset 0x8000000000, the fractional part is always 0.
+		var year, month, day, hour, minute, second uint64
+		if _, err := fmt.Sscanf(TextValue(c), "%d-%d-%d %d:%d:%d",
+			&year, &month, &day, &hour, &minute, &second); err != nil {
+			return buf, err
+		}
+		ymd := (year*13+month)<<5 | day
+		hms := hour<<12 | minute<<6 | second
+		buf = AppendUintBE(buf, 0x8000000000+(ymd<<17|hms), 5)
+		return append(buf, make([]byte, (bc.Meta[0]+1)/2)...), nil
+
+	case utils.MYSQL_TYPE_BIT:
+		v, err := IntBits(c.FieldValue)
+		if err != nil {
+			return buf, err
+		}
+		return AppendUintBE(buf, v, int(bc.Meta[1])), nil
+
+	case utils.MYSQL_TYPE_NEWDECIMAL:
+		if c.IsBinary {
+			return BytesValue(buf, c)
+		}
+		d, err := utils.MakeDecimal(TextValue(c), int(bc.Meta[0]), int(bc.Meta[1]))
+		if err != nil {
+			return buf, err
+		}
+		return append(buf, d...), nil
+
+	case utils.MYSQL_TYPE_STRING:
+		switch uint64(bc.Meta[0]) {
+		case utils.MYSQL_TYPE_ENUM, utils.MYSQL_TYPE_SET:
+			v, err := ElementsValue(c)
+			if err != nil {
+				return buf, err
+			}
+			return AppendUint(buf, v, int(bc.Meta[1])), nil
+		}
+		return AppendString(buf, c, StringMetaLen(bc.Meta))
+
+	case utils.MYSQL_TYPE_VARCHAR:
+		return AppendString(buf, c, uint64(bc.Meta[0])|uint64(bc.Meta[1])<<8)
+
+	case utils.MYSQL_TYPE_BLOB:
+		data, err := BytesValue(nil, c)
+		if err != nil {
+			return buf, err
+		}
+		buf = AppendUint(buf, uint64(len(data)), int(bc.Meta[0]))
+		return append(buf, data...), nil
+
+	case utils.MYSQL_TYPE_JSON:
+		data, err := BytesValue(nil, c)
+		if err != nil {
+			return buf, err
+		}
+		if !c.IsBinary {
+			// The JSON is decoded to text, make it back to the binary JSON.
+			if data, err = utils.MakeJSON(string(data)); err != nil {
+				return buf, err
+			}
+		}
+		buf = AppendUint(buf, uint64(len(data)), 4)
+		return append(buf, data...), nil
+
+	case utils.MYSQL_TYPE_GEOMETRY:
+		var data []byte
+		if g, ok := c.FieldValue.(utils.Geometry); ok {
+			data = g.Bytes()
+		} else {
+			var err error
+			if data, err = BytesValue(nil, c); err != nil {
+				return buf, err
+			}
+		}
+		buf = AppendUint(buf, uint64(len(data)), 4)
+		return append(buf, data...), nil
+	}
+	return buf, fmt.Errorf("the binlog type %d is not supported", bc.Type)
+}
+
+// Append the string with the length of 1 byte, or 2 bytes when the max
+// length is larger than 255.
+func AppendString(buf []byte, c ibdata.Columns, MaxLen uint64) ([]byte, error) {
+	data, err := BytesValue(nil, c)
+	if err != nil {
+		return buf, err
+	}
+	if uint64(len(data)) > MaxLen {
+		return buf, fmt.Errorf("the value length %d is larger than %d", len(data), MaxLen)
+	}
+	if MaxLen > 255 {
+		buf = AppendUint(buf, uint64(len(data)), 2)
+	} else {
+		buf = append(buf, byte(len(data)))
+	}
+	return append(buf, data...), nil
+}
+
+// Append the bytes of the value, the binary value is the hex string made by the parser.
+func BytesValue(buf []byte, c ibdata.Columns) ([]byte, error) {
+	s := TextValue(c)
+	if !c.IsBinary {
+		return append(buf, s...), nil
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, data...), nil
+}
+
+// Get the bits of the integer value, the negative value is two's complement.
+func IntBits(value interface{}) (uint64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.String:
+		if i, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return uint64(i), nil
+		}
+		return strconv.ParseUint(v.String(), 10, 64)
+	}
+	return 0, fmt.Errorf("the value %v is not integer", value)
+}
+
+// Get the float value.
+func FloatValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("the value %v is not float", value)
+}
+
+// Get the number of the ENUM/SET value, the value is the number or the
+// labels when the table struct provide them, the ENUM index begin with 1
+// and the SET is the bits of the labels.
+func ElementsValue(c ibdata.Columns) (uint64, error) {
+	s, ok := c.FieldValue.(string)
+	if !ok || len(c.Elements) == 0 {
+		return IntBits(c.FieldValue)
+	}
+	if s == "" {
+		return 0, nil
+	}
+
+	if c.MySQLType == utils.MYSQL_TYPE_SET {
+		var v uint64
+		for _, label := range strings.Split(s, ",") {
+			i := ElementIndex(c.Elements, label)
+			if i < 0 {
+				return 0, fmt.Errorf("the SET label %s is unknown", label)
+			}
+			v |= 1 << uint(i)
+		}
+		return v, nil
+	}
+
+	i := ElementIndex(c.Elements, s)
+	if i < 0 {
+		return 0, fmt.Errorf("the ENUM label %s is unknown", s)
+	}
+	return uint64(i + 1), nil
+}
+
+// Get the index of the label, -1 if not found.
+func ElementIndex(elements []string, label string) int {
+	for i, e := range elements {
+		if e == label {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+type BinlogTestEvents struct {
+	Type byte
+	Body []byte
+}
+
+// Read the events of the binlog file, check the common header and the checksum.
+func ReadBinlogTestEvents(t *testing.T, data []byte, timestamp uint32) []BinlogTestEvents {
+	if !bytes.HasPrefix(data, []byte(BinlogMagic)) {
+		t.Fatalf("the binlog don't begin with the magic number, %X", data)
+	}
+
+	var events []BinlogTestEvents
+	pos := uint32(len(BinlogMagic))
+	for int(pos) < len(data) {
+		header := data[pos:]
+		size := binary.LittleEndian.Uint32(header[9:])
+		if len(header) < int(size) || int(size) < LOG_EVENT_HEADER_LEN+BINLOG_CHECKSUM_LEN {
+			t.Fatalf("the event at %d have the invalid size %d", pos, size)
+		}
+		event := header[:size]
+		if binary.LittleEndian.Uint32(event) != timestamp || binary.LittleEndian.Uint32(event[5:]) != 1 {
+			t.Errorf("the event at %d have the timestamp %X and the server id %X", pos, event[:4], event[5:9])
+		}
+		if next := binary.LittleEndian.Uint32(event[13:]); next != pos+size {
+			t.Errorf("the event at %d have the log_pos %d, expected %d", pos, next, pos+size)
+		}
+		crc := binary.LittleEndian.Uint32(event[size-4:])
+		if crc != crc32.ChecksumIEEE(event[:size-4]) {
+			t.Errorf("the event at %d have the wrong checksum %X", pos, crc)
+		}
+		events = append(events, BinlogTestEvents{Type: event[4],
+			Body: event[LOG_EVENT_HEADER_LEN : size-uint32(BINLOG_CHECKSUM_LEN)]})
+		pos += size
+	}
+	return events
+}
+
+func TestBinlogWriter(t *testing.T) {
+	// The table test.t1 (id INT PRIMARY KEY, name VARCHAR(10)).
+	id := ibdata.Columns{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4}
+	name := ibdata.Columns{FieldName: "name", FieldType: utils.DATA_VARMYSQL, FieldLen: 10, IsNUll: true}
+	table := ibdata.Tables{DBName: "test", TableName: "t1", Columns: []ibdata.Columns{id, name}}
+	MakeRow := func(op string, IdValue interface{}, NameValue interface{}) ibdata.Rows {
+		i, n := id, name
+		i.FieldValue, n.FieldValue = IdValue, NameValue
+		row := ibdata.Rows{Op: op, DBName: "test", TableName: "t1", Table: table,
+			Record: ibdata.Records{Columns: []ibdata.Columns{i, n}}}
+		if op != ibdata.RowInsert {
+			row.Keys = []ibdata.Columns{i}
+		}
+		return row
+	}
+
+	var buf bytes.Buffer
+	w := NewBinlogWriter(&buf, Options{Format: FormatBinlog, BatchRows: 100, TxnStatements: 10})
+	w.Timestamp = 0x5D000000
+	for _, row := range []ibdata.Rows{
+		MakeRow(ibdata.RowInsert, 1, "a"),
+		MakeRow(ibdata.RowInsert, 2, nil),
+		MakeRow(ibdata.RowDelete, 1, nil),
+		MakeRow(ibdata.RowUpdate, 2, "b"),
+	} {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// binlog_version, the server version padded to 50 bytes, the timestamp,
+	// the header length, the post header length of 38 types and the checksum.
+	version := make([]byte, 50)
+	copy(version, "5.7.19-log")
+	FormatDescription := fmt.Sprintf("0400%X0000005D13%X01", version, BinlogPostHeaderLen)
+
+	// table_id, flags, db, table, width, types, metadata, null bitmap,
+	// the signedness and the column names.
+	TableMap := "010000000000" + "0100" + "047465737400" + "02743100" + "02" + "030F" + "020A00" + "02" +
+		"010100" + "0408" + "026964" + "046E616D65"
+
+	events := ReadBinlogTestEvents(t, buf.Bytes(), w.Timestamp)
+	expected := []struct {
+		Type byte
+		Body string
+	}{
+		{FORMAT_DESCRIPTION_EVENT, FormatDescription},
+		// thread_id, exec_time, db_len, error_code, status_vars_len, db and the query.
+		{QUERY_EVENT, "01000000" + "00000000" + "00" + "0000" + "0000" + "00" + "424547494E"},
+		{TABLE_MAP_EVENT, TableMap},
+		// table_id, flags, extra_data_len, width, the after bitmap,
+		// and the rows (1, 'a'), (2, NULL).
+		{WRITE_ROWS_EVENT, "010000000000" + "0100" + "0200" + "02" + "03" +
+			"00" + "01000000" + "0161" + "02" + "02000000"},
+		{TABLE_MAP_EVENT, TableMap},
+		// The before image only have the primary key.
+		{DELETE_ROWS_EVENT, "010000000000" + "0100" + "0200" + "02" + "01" +
+			"00" + "01000000"},
+		{TABLE_MAP_EVENT, TableMap},
+		{UPDATE_ROWS_EVENT, "010000000000" + "0100" + "0200" + "02" + "01" + "03" +
+			"00" + "02000000" + "00" + "02000000" + "0162"},
+		{XID_EVENT, "0100000000000000"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("the binlog have %d events, expected %d", len(events), len(expected))
+	}
+	for i, c := range expected {
+		if events[i].Type != c.Type || fmt.Sprintf("%X", events[i].Body) != c.Body {
+			t.Errorf("the event %d is %d %X, expected %d %s", i, events[i].Type, events[i].Body, c.Type, c.Body)
+		}
+	}
+}
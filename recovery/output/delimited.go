@@ -0,0 +1,190 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+)
+
+// The leading columns of every row, the row operation, the record state
+// and the transaction id. The state and the trx id are NULL when unknown.
+var DelimitedRowColumns = []string{"_op", "_state", "_trx_id"}
+
+// Write the rows of one table as delimited text, one row per line. The
+// header line with the column names is written before the first row, the
+// columns are the leading columns and the columns of the table. The update
+// row only have the keys and the changed columns, and the delete row only
+// have the keys, the other columns are NULL. The binary value is written as
+// the hex string, the geometry value as WKT.
+type DelimitedWriter struct {
+	w *bufio.Writer
+
+	Delimiter string
+	LineEnd   string
+
+	// Encode the field value, and the column name of the header.
+	EncodeValue func(column ibdata.Columns) string
+	EncodeName  func(name string) string
+
+	DBName    string
+	TableName string
+	Columns   []string
+}
+
+// The RFC 4180 CSV writer, the lines end with CRLF. The NULL is the empty
+// field, and the empty string is quoted as "" to tell it from the NULL.
+func NewCSVWriter(w io.Writer) *DelimitedWriter {
+	return &DelimitedWriter{
+		w:         bufio.NewWriter(w),
+		Delimiter: ",",
+		LineEnd:   "\r\n",
+		EncodeValue: func(column ibdata.Columns) string {
+			if IsNull(column) {
+				return ""
+			}
+			return CSVQuote(TextValue(column))
+		},
+		EncodeName: CSVQuote}
+}
+
+// The TSV writer, the same as the mysql client batch output. The NULL is \N,
+// and the tab, newline and backslash in the value are escaped by backslash.
+func NewTSVWriter(w io.Writer) *DelimitedWriter {
+	return &DelimitedWriter{
+		w:         bufio.NewWriter(w),
+		Delimiter: "\t",
+		LineEnd:   "\n",
+		EncodeValue: func(column ibdata.Columns) string {
+			if IsNull(column) {
+				return `\N`
+			}
+			return TSVEscape(TextValue(column))
+		},
+		EncodeName: TSVEscape}
+}
+
+func (D *DelimitedWriter) WriteRow(row ibdata.Rows) error {
+	if D.Columns == nil {
+		if err := D.Begin(row); err != nil {
+			return err
+		}
+	} else if D.DBName != row.DBName || D.TableName != row.TableName {
+		return fmt.Errorf("the row of %s.%s can't be written to the file of %s.%s, "+
+			"use the OutputDir to write every table to its own file",
+			row.DBName, row.TableName, D.DBName, D.TableName)
+	}
+
+	// The columns which the row don't have are NULL.
+	null := D.EncodeValue(ibdata.Columns{})
+	values := make([]string, len(D.Columns))
+	for i := range values {
+		values[i] = null
+	}
+	for _, c := range RowColumns(row) {
+		pos := -1
+		for i, name := range D.Columns {
+			if name == c.FieldName {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			return fmt.Errorf("the column %s is not in the header of %s.%s",
+				c.FieldName, row.DBName, row.TableName)
+		}
+		values[pos] = D.EncodeValue(c)
+	}
+
+	state, TrxId := null, null
+	if row.Record.State != "" {
+		state = D.EncodeName(row.Record.State)
+	}
+	if row.Record.TrxId != 0 {
+		TrxId = strconv.FormatUint(row.Record.TrxId, 10)
+	}
+	fields := append([]string{D.EncodeName(row.Op), state, TrxId}, values...)
+	if _, err := D.w.WriteString(strings.Join(fields, D.Delimiter) + D.LineEnd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Write the header line by the columns of the table, or the columns of the
+// row when the table is unknown.
+func (D *DelimitedWriter) Begin(row ibdata.Rows) error {
+	columns := ibdata.OutputColumns(row.Table.Columns)
+	if len(columns) == 0 {
+		columns = RowColumns(row)
+	}
+
+	D.DBName, D.TableName = row.DBName, row.TableName
+	D.Columns = []string{}
+	var names []string
+	for _, name := range DelimitedRowColumns {
+		names = append(names, D.EncodeName(name))
+	}
+	for _, c := range columns {
+		D.Columns = append(D.Columns, c.FieldName)
+		names = append(names, D.EncodeName(c.FieldName))
+	}
+	_, err := D.w.WriteString(strings.Join(names, D.Delimiter) + D.LineEnd)
+	return err
+}
+
+func (D *DelimitedWriter) Close() error {
+	return D.w.Flush()
+}
+
+// Quote the CSV field when it have the comma, double quote or line break,
+// or it is empty. The double quote in the field is doubled.
+// Reference RFC 4180 section 2.
+func CSVQuote(field string) string {
+	if field != "" && !strings.ContainsAny(field, ",\"\r\n") {
+		return field
+	}
+	return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+}
+
+// Escape the TSV field by backslash.
+func TSVEscape(field string) string {
+	if !strings.ContainsAny(field, "\\\t\n\r\x00") {
+		return field
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(field); i++ {
+		switch field[i] {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case 0:
+			buf.WriteString(`\0`)
+		default:
+			buf.WriteByte(field[i])
+		}
+	}
+	return buf.String()
+}
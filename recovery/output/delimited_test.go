@@ -0,0 +1,108 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// Make the rows of the table test.t1 (id INT PRIMARY KEY, c1 VARCHAR(10), c2 BLOB):
+// (1, ”, 0x00FF) live with trx id 10, (2, NULL, NULL), (3, 'a,"b"\r\n', ”),
+// the update of c1 to 'x' for id 1 and the delete of id 2.
+func MakeWriterTestRows() []ibdata.Rows {
+	id := ibdata.Columns{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4}
+	c1 := ibdata.Columns{FieldName: "c1", FieldType: utils.DATA_VARMYSQL, IsNUll: true}
+	c2 := ibdata.Columns{FieldName: "c2", FieldType: utils.DATA_BLOB, IsBinary: true, IsNUll: true}
+	table := ibdata.Tables{DBName: "test", TableName: "t1", Columns: []ibdata.Columns{id, c1, c2}}
+
+	Values := func(values ...interface{}) []ibdata.Columns {
+		var columns []ibdata.Columns
+		for i, c := range table.Columns[:len(values)] {
+			c.FieldValue = values[i]
+			columns = append(columns, c)
+		}
+		return columns
+	}
+	Insert := func(record ibdata.Records) ibdata.Rows {
+		return ibdata.Rows{Op: ibdata.RowInsert, Source: ibdata.RowSourceData, DBName: "test",
+			TableName: "t1", Table: table, Record: record}
+	}
+
+	update := Values(nil, "x")[1:]
+	return []ibdata.Rows{
+		Insert(ibdata.Records{Columns: Values(1, "", "00FF"), State: ibdata.RecordLive, TrxId: 10}),
+		Insert(ibdata.Records{Columns: Values(2, nil, nil)}),
+		Insert(ibdata.Records{Columns: Values(3, "a,\"b\"\r\n", "")}),
+		{Op: ibdata.RowUpdate, Source: ibdata.RowSourceUndo, DBName: "test", TableName: "t1", Table: table,
+			Record: ibdata.Records{Columns: update}, Keys: Values(1)},
+		{Op: ibdata.RowDelete, Source: ibdata.RowSourceUndo, DBName: "test", TableName: "t1", Table: table,
+			Keys: Values(2)},
+	}
+}
+
+func TestDelimitedWriter(t *testing.T) {
+	for _, c := range []struct {
+		format    string
+		NewWriter func(w io.Writer) *DelimitedWriter
+		expected  []string
+		LineEnd   string
+	}{
+		{FormatCSV, NewCSVWriter, []string{
+			"_op,_state,_trx_id,id,c1,c2",
+			`insert,live,10,1,"",00FF`,
+			"insert,,,2,,",
+			"insert,,,3,\"a,\"\"b\"\"\r\n\",\"\"",
+			"update,,,1,x,",
+			"delete,,,2,,",
+		}, "\r\n"},
+		{FormatTSV, NewTSVWriter, []string{
+			"_op\t_state\t_trx_id\tid\tc1\tc2",
+			"insert\tlive\t10\t1\t\t00FF",
+			"insert\t\\N\t\\N\t2\t\\N\t\\N",
+			"insert\t\\N\t\\N\t3\ta,\"b\"\\r\\n\t",
+			"update\t\\N\t\\N\t1\tx\t\\N",
+			"delete\t\\N\t\\N\t2\t\\N\t\\N",
+		}, "\n"},
+	} {
+		var buf bytes.Buffer
+		w := c.NewWriter(&buf)
+		for _, row := range MakeWriterTestRows() {
+			if err := w.WriteRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Only one table and one header in the file.
+		other := MakeWriterTestRows()[0]
+		other.TableName = "t2"
+		if err := w.WriteRow(other); err == nil {
+			t.Errorf("the %s row of the other table is not rejected", c.format)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := strings.Join(c.expected, c.LineEnd) + c.LineEnd
+		if buf.String() != expected {
+			t.Errorf("the %s is %q, expected %q", c.format, buf.String(), expected)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The compression of the output files.
+const (
+	CompressNone string = "none"
+	CompressGzip string = "gzip"
+	CompressZstd string = "zstd"
+)
+
+// The manifest file written to the output directory at the end.
+const ManifestFile string = "manifest.json"
+
+// Store the info of a written output file in the manifest.
+type ManifestFiles struct {
+	File     string `json:"file"`
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Source   string `json:"source"`
+	Part     int    `json:"part"`
+	Format   string `json:"format"`
+	Compress string `json:"compress"`
+	Rows     uint64 `json:"rows"`
+	Bytes    uint64 `json:"bytes"`
+	RawBytes uint64 `json:"raw_bytes"`
+	Sha256   string `json:"sha256"`
+}
+
+// Count the bytes written to the writer.
+type CountWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (C *CountWriter) Write(p []byte) (int, error) {
+	n, err := C.w.Write(p)
+	C.n += uint64(n)
+	return n, err
+}
+
+// The output file of a table and a source, the rows are written by the
+// format writer, then compressed, and the file checksum is computed
+// while writing.
+type TableFiles struct {
+	DBName    string
+	TableName string
+	Source    string
+	Part      int
+
+	path string
+	f    *os.File
+	sum  hash.Hash
+
+	// The bytes written to the file and the bytes before compression.
+	FileCount *CountWriter
+	RawCount  *CountWriter
+
+	compressor io.WriteCloser
+
+	// The format writer use the buf directly, the buffered bytes are
+	// counted when checking the file size.
+	buf  *bufio.Writer
+	w    ibdata.RowWriter
+	Rows uint64
+}
+
+// Write the rows to the output directory, one file per database, table and
+// source. When MaxFileSize is set, the file is rotated after its size before
+// compression reach it, the rows are not split across files. The size only
+// count the bytes the format writer have written to the file buffer, the
+// rows it still hold are not counted, such as the sql writer's own buffer
+// and batch, and the Parquet row group, so the file can be larger than the
+// MaxFileSize by them. The manifest with the files, row counts and checksums
+// is written when it is closed.
+type DirWriter struct {
+	Dir         string
+	Compress    string
+	MaxFileSize uint64
+
+	// The options of the format writer of every file.
+	Options Options
+
+	files    map[string]*TableFiles
+	parts    map[string]int
+	Manifest []ManifestFiles
+}
+
+func NewDirWriter(dir string, compress string, MaxFileSize uint64, opts Options) (*DirWriter, error) {
+	if opts.Format == "" {
+		opts.Format = FormatReplace
+	}
+	if compress == "" {
+		compress = CompressNone
+	}
+	if compress != CompressNone && compress != CompressGzip && compress != CompressZstd {
+		ErrMsg := fmt.Sprintf("unknown compression %s, the compression can be none,gzip,zstd", compress)
+		logs.Error(ErrMsg)
+		return nil, fmt.Errorf(ErrMsg)
+	}
+
+	// The LOAD DATA statement can't read the compressed file, and the
+	// Parquet file is compressed by itself.
+	if (opts.Format == FormatLoadData || opts.Format == FormatParquet) && compress != CompressNone {
+		ErrMsg := fmt.Sprintf("the %s format can't be compressed", opts.Format)
+		logs.Error(ErrMsg)
+		return nil, fmt.Errorf(ErrMsg)
+	}
+
+	// Check the format before any file is created.
+	if _, err := NewRowWriter(ioutil.Discard, opts); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logs.Error("create the output directory failed, the error is ", err)
+		return nil, err
+	}
+
+	return &DirWriter{
+		Dir:         dir,
+		Compress:    compress,
+		MaxFileSize: MaxFileSize,
+		Options:     opts,
+		files:       make(map[string]*TableFiles),
+		parts:       make(map[string]int)}, nil
+}
+
+func (D *DirWriter) WriteRow(row ibdata.Rows) error {
+	key := row.Source + "\x00" + row.DBName + "\x00" + row.TableName
+
+	tf, ok := D.files[key]
+	if !ok {
+		D.parts[key]++
+		var err error
+		tf, err = D.OpenTableFile(row.DBName, row.TableName, row.Source, D.parts[key])
+		if err != nil {
+			return err
+		}
+		D.files[key] = tf
+	}
+
+	if err := tf.w.WriteRow(row); err != nil {
+		return err
+	}
+	tf.Rows++
+
+	// Rotate the file, the next row open the next part. The bytes which
+	// the format writer buffer by itself are not counted.
+	if D.MaxFileSize != 0 && tf.RawCount.n+uint64(tf.buf.Buffered()) >= D.MaxFileSize {
+		delete(D.files, key)
+		return D.CloseTableFile(tf)
+	}
+	return nil
+}
+
+// Close all files and write the manifest.
+func (D *DirWriter) Close() error {
+	var CloseErr error
+	for key, tf := range D.files {
+		delete(D.files, key)
+		if err := D.CloseTableFile(tf); err != nil {
+			CloseErr = err
+		}
+	}
+
+	sort.Slice(D.Manifest, func(i, j int) bool {
+		return D.Manifest[i].File < D.Manifest[j].File
+	})
+
+	data, err := json.MarshalIndent(D.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(D.Dir, ManifestFile)
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		logs.Error("write the manifest failed, the error is ", err)
+		return err
+	}
+	logs.Info("write ", len(D.Manifest), " files to ", D.Dir)
+	return CloseErr
+}
+
+// Make the file name like db/table.source.ext, every database have its own
+// directory, so the names with the dot don't collide, and the part number is
+// added when the file is rotated.
+func (D *DirWriter) FileName(DBName string, TableName string, source string, part int) string {
+	name := SafeFileName(TableName) + "." + source
+	if D.MaxFileSize != 0 {
+		name += fmt.Sprintf(".%04d", part)
+	}
+	name += "." + FormatExt(D.Options.Format)
+
+	switch D.Compress {
+	case CompressGzip:
+		name += ".gz"
+	case CompressZstd:
+		name += ".zst"
+	}
+	return filepath.Join(SafeFileName(DBName), name)
+}
+
+func (D *DirWriter) OpenTableFile(DBName string, TableName string, source string, part int) (*TableFiles, error) {
+	tf := &TableFiles{
+		DBName:    DBName,
+		TableName: TableName,
+		Source:    source,
+		Part:      part,
+		path:      D.FileName(DBName, TableName, source, part),
+		sum:       sha256.New()}
+
+	if err := os.MkdirAll(filepath.Join(D.Dir, filepath.Dir(tf.path)), 0755); err != nil {
+		logs.Error("create the database directory failed, the error is ", err)
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(D.Dir, tf.path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		logs.Error("create the output file failed, the error is ", err)
+		return nil, err
+	}
+	tf.f = f
+	tf.FileCount = &CountWriter{w: io.MultiWriter(f, tf.sum)}
+
+	switch D.Compress {
+	case CompressGzip:
+		tf.compressor = gzip.NewWriter(tf.FileCount)
+	case CompressZstd:
+		tf.compressor, err = zstd.NewWriter(tf.FileCount)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	default:
+		tf.compressor = NopWriteCloser{tf.FileCount}
+	}
+	tf.RawCount = &CountWriter{w: tf.compressor}
+	tf.buf = bufio.NewWriter(tf.RawCount)
+
+	tf.w, err = NewRowWriter(tf.buf, D.Options)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	logs.Info("write the rows of ", DBName, ".", TableName, " to ", tf.path)
+	return tf, nil
+}
+
+// Flush and close the file, and add it to the manifest.
+func (D *DirWriter) CloseTableFile(tf *TableFiles) error {
+	if err := tf.w.Close(); err != nil {
+		tf.f.Close()
+		return err
+	}
+	// The format writer may leave the rows in the buf, flush them before
+	// the compressor write its trailer.
+	if err := tf.buf.Flush(); err != nil {
+		tf.f.Close()
+		return err
+	}
+	if err := tf.compressor.Close(); err != nil {
+		tf.f.Close()
+		return err
+	}
+	if err := tf.f.Close(); err != nil {
+		return err
+	}
+
+	// Write the LOAD DATA statement of the data file.
+	if lw, ok := tf.w.(*LoadDataWriter); ok {
+		name := strings.TrimSuffix(tf.path, "."+FormatExt(FormatLoadData)) + ".sql"
+		DataFile, err := filepath.Abs(filepath.Join(D.Dir, tf.path))
+		if err != nil {
+			return err
+		}
+		query := lw.MakeLoadDataSQL(DataFile)
+		if err := ioutil.WriteFile(filepath.Join(D.Dir, name), []byte(query), 0644); err != nil {
+			logs.Error("write the load data statement failed, the error is ", err)
+			return err
+		}
+	}
+
+	D.Manifest = append(D.Manifest, ManifestFiles{
+		File:     tf.path,
+		Database: tf.DBName,
+		Table:    tf.TableName,
+		Source:   tf.Source,
+		Part:     tf.Part,
+		Format:   D.Options.Format,
+		Compress: D.Compress,
+		Rows:     tf.Rows,
+		Bytes:    tf.FileCount.n,
+		RawBytes: tf.RawCount.n,
+		Sha256:   hex.EncodeToString(tf.sum.Sum(nil))})
+	return nil
+}
+
+// The writer without compression.
+type NopWriteCloser struct {
+	io.Writer
+}
+
+func (NopWriteCloser) Close() error {
+	return nil
+}
+
+// The file extension of the output format.
+func FormatExt(format string) string {
+	switch format {
+	case FormatCSV, FormatTSV, FormatJSONL:
+		return format
+	case FormatLoadData:
+		return "txt"
+	case FormatBinlog:
+		return "binlog"
+	case FormatParquet:
+		return "parquet"
+	}
+	return "sql"
+}
+
+// Replace the path separators in the database or table name, the name
+// can't be the current or parent directory.
+func SafeFileName(name string) string {
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(name)
+}
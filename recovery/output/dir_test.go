@@ -0,0 +1,178 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Read the output file back without the compression.
+func ReadDirTestFile(t *testing.T, path string, compress string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch compress {
+	case CompressGzip:
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case CompressZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %s failed: %v", path, err)
+	}
+	return data
+}
+
+func TestDirWriter(t *testing.T) {
+	for _, compress := range []string{CompressNone, CompressGzip, CompressZstd} {
+		dir, err := ioutil.TempDir("", "dir_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// Every JSON line is larger than 40 bytes, so the file is rotated
+		// after every row.
+		w, err := NewDirWriter(dir, compress, 40, Options{Format: FormatJSONL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := MakeWriterTestRows()
+
+		// The db a.b and table c, and the db a and table b.c don't collide.
+		for _, name := range [][2]string{{"a.b", "c"}, {"a", "b.c"}, {"..", "t"}} {
+			row := rows[0]
+			row.DBName, row.TableName = name[0], name[1]
+			rows = append(rows, row)
+		}
+		for _, row := range rows {
+			if err := w.WriteRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest []ManifestFiles
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatal(err)
+		}
+
+		ext := map[string]string{CompressNone: "", CompressGzip: ".gz", CompressZstd: ".zst"}[compress]
+		var files []string
+		for _, m := range manifest {
+			files = append(files, m.File)
+
+			path := filepath.Join(dir, m.File)
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(raw)
+			if m.Sha256 != hex.EncodeToString(sum[:]) || m.Bytes != uint64(len(raw)) {
+				t.Errorf("%s manifest sha256 %s bytes %d, the file sha256 %x bytes %d",
+					m.File, m.Sha256, m.Bytes, sum, len(raw))
+			}
+
+			content := ReadDirTestFile(t, path, compress)
+			if m.RawBytes != uint64(len(content)) || m.Rows != 1 ||
+				bytes.Count(content, []byte("\n")) != 1 {
+				t.Errorf("%s have %d rows and %d raw bytes, the content is %q",
+					m.File, m.Rows, m.RawBytes, content)
+			}
+			if !strings.HasSuffix(m.File, ".jsonl"+ext) || m.Compress != compress {
+				t.Errorf("%s is compressed by %s", m.File, m.Compress)
+			}
+		}
+
+		expected := strings.Replace(`[_/t.data.0001.jsonl a.b/c.data.0001.jsonl a/b.c.data.0001.jsonl `+
+			`test/t1.data.0001.jsonl test/t1.data.0002.jsonl test/t1.data.0003.jsonl `+
+			`test/t1.undo.0001.jsonl test/t1.undo.0002.jsonl]`, ".jsonl", ".jsonl"+ext, -1)
+		if fmt.Sprint(files) != expected {
+			t.Errorf("the files are %v, expected %s", files, expected)
+		}
+	}
+}
+
+func TestDirWriterWithoutRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewDirWriter(dir, CompressGzip, 0, Options{Format: FormatCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range MakeWriterTestRows()[:3] {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.Manifest) != 1 || w.Manifest[0].File != filepath.Join("test", "t1.data.csv.gz") ||
+		w.Manifest[0].Rows != 3 {
+		t.Fatalf("the manifest is %v", w.Manifest)
+	}
+	content := ReadDirTestFile(t, filepath.Join(dir, w.Manifest[0].File), CompressGzip)
+	if lines := strings.Count(string(content), "\r\n"); lines != 5 {
+		t.Errorf("the file have %d lines, expected the header and 3 rows with a line break in the value: %q",
+			lines, content)
+	}
+
+	// The LOAD DATA and Parquet files can't be compressed.
+	if _, err := NewDirWriter(dir, CompressGzip, 0, Options{Format: FormatLoadData}); err == nil {
+		t.Error("the compressed LOAD DATA file is not rejected")
+	}
+	if _, err := NewDirWriter(dir, "lz4", 0, Options{}); err == nil {
+		t.Error("the unknown compression is not rejected")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// The JSON object of a row, the row and keys are objects which keep
+// the column order of the table.
+type JSONRows struct {
+	Op         string          `json:"op"`
+	Source     string          `json:"source"`
+	Database   string          `json:"database"`
+	Table      string          `json:"table"`
+	State      string          `json:"state,omitempty"`
+	PageNo     uint64          `json:"page,omitempty"`
+	Offset     uint64          `json:"offset,omitempty"`
+	TrxId      uint64          `json:"trx_id,omitempty"`
+	LSN        uint64          `json:"lsn,omitempty"`
+	Confidence float64         `json:"confidence,omitempty"`
+	Reasons    []string        `json:"reasons,omitempty"`
+	Keys       json.RawMessage `json:"keys,omitempty"`
+	Row        json.RawMessage `json:"row"`
+}
+
+// Write the rows as JSON Lines, one JSON object per line. The NULL is null,
+// the binary value is the base64 string, the geometry value is GeoJSON and
+// the JSON column value is embedded as it is.
+type JSONWriter struct {
+	w *bufio.Writer
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: bufio.NewWriter(w)}
+}
+
+func (J *JSONWriter) WriteRow(row ibdata.Rows) error {
+	r := JSONRows{
+		Op:         row.Op,
+		Source:     row.Source,
+		Database:   row.DBName,
+		Table:      row.TableName,
+		State:      row.Record.State,
+		PageNo:     row.Record.PageNo,
+		Offset:     row.Record.Offset,
+		TrxId:      row.Record.TrxId,
+		LSN:        row.Record.LSN,
+		Confidence: row.Record.Confidence,
+		Reasons:    row.Record.Reasons,
+		Row:        JSONObject(ibdata.OutputColumns(row.Record.Columns))}
+	if len(row.Keys) != 0 {
+		r.Keys = JSONObject(row.Keys)
+	}
+
+	line, err := MarshalJSON(r)
+	if err != nil {
+		return err
+	}
+	if _, err := J.w.Write(line); err != nil {
+		return err
+	}
+	return J.w.WriteByte('\n')
+}
+
+func (J *JSONWriter) Close() error {
+	return J.w.Flush()
+}
+
+// Make the columns to the JSON object in the column order.
+func JSONObject(columns []ibdata.Columns) json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, c := range columns {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := MarshalJSON(c.FieldName)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(JSONValue(c))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// Make the column value to the JSON value.
+func JSONValue(column ibdata.Columns) json.RawMessage {
+	if IsNull(column) {
+		return json.RawMessage("null")
+	}
+
+	var value interface{} = column.FieldValue
+	switch v := column.FieldValue.(type) {
+	case utils.Geometry:
+		return json.RawMessage(v.GeoJSON())
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			value = TextValue(column)
+		}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			value = TextValue(column)
+		}
+	case string:
+		if column.IsBinary {
+			// The parser made the binary value to the hex string,
+			// the []byte is marshaled to the base64 string.
+			if data, err := hex.DecodeString(v); err == nil {
+				value = data
+			}
+		} else if column.MySQLType == utils.MYSQL_TYPE_JSON && json.Valid([]byte(v)) {
+			return json.RawMessage(v)
+		}
+	}
+
+	data, err := MarshalJSON(value)
+	if err != nil {
+		data, _ = MarshalJSON(TextValue(column))
+	}
+	return data
+}
+
+// Marshal the value without escaping the HTML characters.
+func MarshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
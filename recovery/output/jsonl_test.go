@@ -0,0 +1,46 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONWriter(&buf)
+	for _, row := range MakeWriterTestRows() {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The binary value is base64, the empty string is not null.
+	expected := strings.Join([]string{
+		`{"op":"insert","source":"data","database":"test","table":"t1","state":"live","trx_id":10,"row":{"id":1,"c1":"","c2":"AP8="}}`,
+		`{"op":"insert","source":"data","database":"test","table":"t1","row":{"id":2,"c1":null,"c2":null}}`,
+		`{"op":"insert","source":"data","database":"test","table":"t1","row":{"id":3,"c1":"a,\"b\"\r\n","c2":""}}`,
+		`{"op":"update","source":"undo","database":"test","table":"t1","keys":{"id":1},"row":{"c1":"x"}}`,
+		`{"op":"delete","source":"undo","database":"test","table":"t1","keys":{"id":2},"row":{}}`,
+	}, "\n") + "\n"
+	if buf.String() != expected {
+		t.Errorf("the JSON Lines is\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
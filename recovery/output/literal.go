@@ -0,0 +1,131 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Render the column value to the sql literal by the column type, it is used
+// by all sql statements, so the insert, update and delete rows get the same
+// values:
+// 1.The NULL is NULL.
+// 2.The integer, float and decimal are not quoted, the decimal which
+// can't be decoded is NULL.
+// 3.The binary is 0x hex, and the BIT is bits like b'101'.
+// 4.The date, time and string are quoted and escaped.
+// 5.The geometry is the hex of the MySQL storage format.
+func SQLLiteral(column ibdata.Columns) string {
+	if IsNull(column) {
+		return "NULL"
+	}
+
+	switch v := column.FieldValue.(type) {
+	case utils.Geometry:
+		return v.SQL()
+
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		number := fmt.Sprintf("%d", v)
+		switch column.MySQLType {
+		case utils.MYSQL_TYPE_BIT:
+			n, _ := strconv.ParseUint(number, 10, 64)
+			return "b'" + strconv.FormatUint(n, 2) + "'"
+		case utils.MYSQL_TYPE_YEAR:
+			// The year 0000 is stored as 0, the parser add 1900 to it.
+			if number == "1900" {
+				return "0"
+			}
+		}
+		return number
+
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return "NULL"
+		}
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "NULL"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+
+	case string:
+		// The decimal is decoded to the exact digits. It is the hex of the
+		// binary decimal when the precision is unknown, which is not the
+		// value of the column even if it is all digits, so it is NULL.
+		if column.MySQLType == utils.MYSQL_TYPE_NEWDECIMAL {
+			if !column.IsBinary && IsDecimal(v) {
+				return v
+			}
+			WarnSQLValue(column, "the decimal which can't be decoded is NULL, "+
+				"use --TableStructFile to provide the precision and scale")
+			return "NULL"
+		}
+		if column.IsBinary {
+			// The parser made the binary value to the hex string.
+			if v == "" {
+				return "X''"
+			}
+			return "0x" + strings.ToUpper(v)
+		}
+		return QuoteString(v)
+	}
+	return QuoteString(TextValue(column))
+}
+
+// The columns which have been warned, the key is the table id and the column name.
+var WarnedSQLValues sync.Map
+
+// Warn once for every column which have the values the sql can't render.
+func WarnSQLValue(c ibdata.Columns, msg string) {
+	key := fmt.Sprintf("%d.%s", c.TableID, c.FieldName)
+	if _, warned := WarnedSQLValues.LoadOrStore(key, true); !warned {
+		logs.Warn("the column ", c.FieldName, " have the value ", TextValue(c),
+			" which the sql can't render, ", msg)
+	}
+}
+
+// Quote and escape the string literal.
+func QuoteString(s string) string {
+	return "'" + utils.EscapeValue(s) + "'"
+}
+
+// Whether the string is the decimal number, like -123.45.
+func IsDecimal(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" || s[0] == '.' || s[len(s)-1] == '.' {
+		return false
+	}
+	dot := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '.' && !dot {
+			dot = true
+			continue
+		}
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+func TestSQLLiteralDecimal(t *testing.T) {
+	decimal := ibdata.Columns{FieldName: "d", FieldType: utils.DATA_FIXBINARY,
+		MySQLType: utils.MYSQL_TYPE_NEWDECIMAL, FieldLen: 5, Precision: 10, Scale: 2}
+
+	for _, c := range []struct {
+		value    string
+		IsBinary bool
+		expected string
+	}{
+		{"-123.45", false, "-123.45"},
+		{"0.00", false, "0.00"},
+		// The precision is unknown, the value is the hex of the binary decimal.
+		{"8000007B2D", true, "NULL"},
+		{"800000", true, "NULL"},
+	} {
+		column := decimal
+		column.FieldValue = c.value
+		column.IsBinary = c.IsBinary
+		if c.IsBinary {
+			column.Precision, column.Scale = 0, 0
+		}
+		if literal := SQLLiteral(column); literal != c.expected {
+			t.Errorf("the literal of %s is %s, expected %s", c.value, literal, c.expected)
+		}
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	for _, c := range []struct {
+		column   ibdata.Columns
+		expected string
+	}{
+		{ibdata.Columns{FieldType: utils.DATA_INT, FieldValue: nil}, "NULL"},
+		// The string NULL is not the NULL.
+		{ibdata.Columns{FieldType: utils.DATA_VARMYSQL, FieldValue: "NULL"}, "'NULL'"},
+		{ibdata.Columns{FieldType: utils.DATA_INT, FieldValue: int64(-42)}, "-42"},
+		{ibdata.Columns{FieldType: utils.DATA_INT, FieldValue: uint64(18446744073709551615)}, "18446744073709551615"},
+		{ibdata.Columns{FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_BIT, FieldValue: uint64(5)}, "b'101'"},
+		{ibdata.Columns{FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_YEAR, FieldValue: 1900}, "0"},
+		{ibdata.Columns{FieldType: utils.DATA_INT, MySQLType: utils.MYSQL_TYPE_YEAR, FieldValue: 2019}, "2019"},
+		{ibdata.Columns{FieldType: utils.DATA_FLOAT, FieldValue: float32(1.1)}, "1.1"},
+		{ibdata.Columns{FieldType: utils.DATA_DOUBLE, FieldValue: 0.1}, "0.1"},
+		{ibdata.Columns{FieldType: utils.DATA_DOUBLE, FieldValue: math.NaN()}, "NULL"},
+		{ibdata.Columns{FieldType: utils.DATA_BLOB, IsBinary: true, FieldValue: "00ff"}, "0x00FF"},
+		{ibdata.Columns{FieldType: utils.DATA_BLOB, IsBinary: true, FieldValue: ""}, "X''"},
+		{ibdata.Columns{FieldType: utils.DATA_VARMYSQL, FieldValue: "it's \"a\"\n\\\x00\x1a"},
+			`'it\'s \"a\"\n\\\0\Z'`},
+		{ibdata.Columns{FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_DATETIME,
+			FieldValue: "2019-01-02 03:04:05"}, "'2019-01-02 03:04:05'"},
+		{ibdata.Columns{FieldType: utils.DATA_GEOMETRY, MySQLType: utils.MYSQL_TYPE_GEOMETRY,
+			FieldValue: utils.Geometry{SRID: 4326, Type: utils.WKB_POINT, Point: [2]float64{116.4, 39.9}}},
+			"0xE610000001010000009A99999999195D403333333333F34340"},
+	} {
+		if literal := SQLLiteral(c.column); literal != c.expected {
+			t.Errorf("the literal of %#v is %s, expected %s", c.column.FieldValue, literal, c.expected)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// Write the rows as the LOAD DATA INFILE data file, with the default field
+// and line terminators. The NULL is \N, the special characters are escaped
+// by backslash, and the binary and geometry values are the hex strings which
+// are decoded by the SET clause of the LOAD DATA statement. Only the inserted rows of one
+// table with the same columns can be written to a data file.
+// Reference mysql-5.7.19/sql/sql_load.cc
+type LoadDataWriter struct {
+	w *bufio.Writer
+
+	DBName    string
+	TableName string
+	Columns   []ibdata.Columns
+}
+
+func NewLoadDataWriter(w io.Writer) *LoadDataWriter {
+	return &LoadDataWriter{w: bufio.NewWriter(w)}
+}
+
+func (L *LoadDataWriter) WriteRow(row ibdata.Rows) error {
+	if row.Op != ibdata.RowInsert {
+		return fmt.Errorf("the %s row of %s.%s can't be loaded by LOAD DATA",
+			row.Op, row.DBName, row.TableName)
+	}
+
+	columns := ibdata.OutputColumns(row.Record.Columns)
+	if L.Columns == nil {
+		L.DBName = row.DBName
+		L.TableName = row.TableName
+		L.Columns = columns
+	} else if !SameColumnNames(L.Columns, columns) || L.DBName != row.DBName || L.TableName != row.TableName {
+		return fmt.Errorf("the row of %s.%s at page %d offset %d have the different columns "+
+			"from the data file", row.DBName, row.TableName, row.Record.PageNo, row.Record.Offset)
+	}
+
+	var values []string
+	for i, c := range columns {
+		// The NULL value don't tell whether the column is binary.
+		if c.IsBinary {
+			L.Columns[i].IsBinary = true
+		}
+		if IsNull(c) {
+			values = append(values, `\N`)
+			continue
+		}
+		// The geometry is the hex of the storage format, the WKT lose the
+		// SRID and the axis order of the geographic SRS in MySQL 8.0.
+		if g, ok := c.FieldValue.(utils.Geometry); ok {
+			values = append(values, fmt.Sprintf("%X", g.Bytes()))
+			continue
+		}
+		values = append(values, TSVEscape(TextValue(c)))
+	}
+
+	if _, err := L.w.WriteString(strings.Join(values, "\t") + "\n"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (L *LoadDataWriter) Close() error {
+	return L.w.Flush()
+}
+
+// Make the LOAD DATA statement of the data file. The binary, bit and geometry
+// columns are read into the user variables and converted by the SET clause,
+// the functions return NULL for the \N.
+func (L *LoadDataWriter) MakeLoadDataSQL(DataFile string) string {
+	var names, sets []string
+	for _, c := range L.Columns {
+		name := QuoteMySQLName(c.FieldName)
+		variable := "@" + name
+
+		var expr string
+		switch {
+		case c.IsBinary || c.FieldType == utils.DATA_GEOMETRY || c.FieldType == utils.DATA_VAR_POINT ||
+			c.FieldType == utils.DATA_POINT || c.MySQLType == utils.MYSQL_TYPE_GEOMETRY:
+			expr = fmt.Sprintf("UNHEX(%s)", variable)
+		case c.MySQLType == utils.MYSQL_TYPE_BIT:
+			expr = fmt.Sprintf("CAST(%s AS UNSIGNED)", variable)
+		}
+
+		if expr == "" {
+			names = append(names, name)
+			continue
+		}
+		names = append(names, variable)
+		sets = append(sets, fmt.Sprintf("%s=%s", name, expr))
+	}
+
+	query := fmt.Sprintf("LOAD DATA LOCAL INFILE '%s' INTO TABLE %s.%s CHARACTER SET %s\n"+
+		"FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\'\nLINES TERMINATED BY '\\n'\n(%s)",
+		utils.EscapeValue(DataFile), QuoteMySQLName(L.DBName), QuoteMySQLName(L.TableName), LoadDataCharset(L.Columns), strings.Join(names, ", "))
+	if len(sets) != 0 {
+		query += "\nSET " + strings.Join(sets, ", ")
+	}
+	return query + ";\n"
+}
+
+// Whether the columns have the same names in the same order.
+func SameColumnNames(a []ibdata.Columns, b []ibdata.Columns) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].FieldName != b[i].FieldName {
+			return false
+		}
+	}
+	return true
+}
+
+// The character set of the data file, the text values are written as they
+// are stored, so all character columns should have the same character set,
+// otherwise the binary is used to load the bytes without conversion.
+func LoadDataCharset(columns []ibdata.Columns) string {
+	var charset string
+	for _, c := range columns {
+		if c.IsBinary || c.Charset == 0 {
+			continue
+		}
+		switch c.FieldType {
+		case utils.DATA_VARCHAR, utils.DATA_CHAR, utils.DATA_VARMYSQL, utils.DATA_MYSQL, utils.DATA_BLOB:
+		default:
+			continue
+		}
+
+		name := CollationCharset(c.Charset)
+		if charset == "" {
+			charset = name
+		} else if charset != name {
+			return "binary"
+		}
+	}
+	if charset == "" {
+		return "utf8mb4"
+	}
+	return charset
+}
+
+// Get the character set name of the collation id.
+// Reference mysql-8.0/strings/ctype-*.cc
+func CollationCharset(id uint64) string {
+	switch {
+	case id == 33 || id == 83 || (id >= 192 && id <= 215) || id == 223:
+		return "utf8"
+	case id == 45 || id == 46 || (id >= 224 && id <= 247) || (id >= 255 && id <= 323):
+		return "utf8mb4"
+	case id == 5 || id == 8 || id == 15 || id == 31 || id == 47 || id == 48 || id == 49 || id == 94:
+		return "latin1"
+	case id == 28 || id == 87:
+		return "gbk"
+	case id == 24 || id == 86:
+		return "gb2312"
+	case id == 1 || id == 84:
+		return "big5"
+	case id == 11 || id == 65:
+		return "ascii"
+	case id == 63:
+		return "binary"
+	}
+	return "binary"
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+func TestLoadDataWriter(t *testing.T) {
+	columns := []ibdata.Columns{
+		{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4, FieldValue: 1},
+		{FieldName: "c1", FieldType: utils.DATA_VARMYSQL, FieldValue: "a\tb\nc\\d\x00e"},
+		{FieldName: "c2", FieldType: utils.DATA_BLOB, IsBinary: true, FieldValue: "00FF"},
+		{FieldName: "c3", FieldType: utils.DATA_GEOMETRY, MySQLType: utils.MYSQL_TYPE_GEOMETRY,
+			FieldValue: utils.Geometry{SRID: 4326, Type: utils.WKB_POINT, Point: [2]float64{116.4, 39.9}}},
+		{FieldName: "c4", FieldType: utils.DATA_VARMYSQL, FieldValue: nil},
+		// The string NULL is read as the string, the FIELDS ENCLOSED BY is empty.
+		// The backtick in the names is doubled.
+		{FieldName: "c`5", FieldType: utils.DATA_VARMYSQL, FieldValue: "NULL"},
+	}
+	row := ibdata.Rows{Op: ibdata.RowInsert, DBName: "test", TableName: "t`1",
+		Record: ibdata.Records{Columns: columns}}
+
+	var buf bytes.Buffer
+	w := NewLoadDataWriter(&buf)
+	if err := w.WriteRow(row); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The special characters are escaped as the LOAD DATA default ESCAPED BY '\\'.
+	expected := "1\ta\\tb\\nc\\\\d\\0e\t00FF\tE610000001010000009A99999999195D403333333333F34340\t\\N\tNULL\n"
+	if buf.String() != expected {
+		t.Errorf("the data file is %q, expected %q", buf.String(), expected)
+	}
+
+	query := w.MakeLoadDataSQL("t1.txt")
+	if !strings.Contains(query, "INTO TABLE `test`.`t``1` ") ||
+		!strings.Contains(query, "(`id`, `c1`, @`c2`, @`c3`, `c4`, `c``5`)\nSET `c2`=UNHEX(@`c2`), `c3`=UNHEX(@`c3`);") {
+		t.Errorf("the LOAD DATA statement is %s", query)
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The default bytes of the row group which is buffered in memory.
+const DefaultRowGroupSize int64 = 64 * 1024 * 1024
+
+// Write the rows of one table as the Parquet file, the columns are mapped
+// to the Parquet types by the InnoDB and MySQL types. The rows are buffered
+// until the row group is full, then the row group is written, so only one
+// row group is in memory. The footer is written by Close. Only the inserted
+// rows of one table can be written to a Parquet file.
+// Reference https://github.com/apache/parquet-format/blob/master/LogicalTypes.md
+type ParquetWriter struct {
+	w  *bufio.Writer
+	pw *writer.CSVWriter
+
+	Options Options
+
+	DBName    string
+	TableName string
+	Columns   []ParquetColumns
+}
+
+// The Parquet column of the table column. The Type is the type name of
+// the parquet-go schema tag, it tell how to convert the value.
+type ParquetColumns struct {
+	Column   ibdata.Columns
+	Type     string
+	BaseType string
+	Length   int32
+
+	// The logical type which the schema tag can't tell.
+	LogicalType   *parquet.LogicalType
+	ConvertedType *parquet.ConvertedType
+}
+
+// Warn once for every column which have the values Parquet can't store.
+var WarnedParquetValues sync.Map
+
+func NewParquetWriter(w io.Writer, opts Options) *ParquetWriter {
+	return &ParquetWriter{w: bufio.NewWriter(w), Options: opts}
+}
+
+func (P *ParquetWriter) WriteRow(row ibdata.Rows) error {
+	if row.Op != ibdata.RowInsert {
+		return fmt.Errorf("the %s row of %s.%s can't be written to Parquet",
+			row.Op, row.DBName, row.TableName)
+	}
+
+	if P.pw == nil {
+		if err := P.Begin(row); err != nil {
+			return err
+		}
+	} else if P.DBName != row.DBName || P.TableName != row.TableName {
+		return fmt.Errorf("the row of %s.%s can't be written to the Parquet file of %s.%s, "+
+			"use the OutputDir to write every table to its own file",
+			row.DBName, row.TableName, P.DBName, P.TableName)
+	}
+
+	// The partial row only have some columns, the others are NULL.
+	values := make([]interface{}, len(P.Columns))
+	for _, c := range ibdata.OutputColumns(row.Record.Columns) {
+		for i := range P.Columns {
+			if P.Columns[i].Column.FieldName != c.FieldName {
+				continue
+			}
+			v, err := P.Columns[i].Value(c)
+			if err != nil {
+				return fmt.Errorf("the value of the column %s in %s.%s is invalid, %v",
+					c.FieldName, row.DBName, row.TableName, err)
+			}
+			values[i] = v
+		}
+	}
+	return P.pw.Write(values)
+}
+
+func (P *ParquetWriter) Close() error {
+	if P.pw != nil {
+		if err := P.pw.WriteStop(); err != nil {
+			return err
+		}
+	}
+	return P.w.Flush()
+}
+
+// Make the schema by the columns of the table, and write the file header.
+func (P *ParquetWriter) Begin(row ibdata.Rows) error {
+	columns := ibdata.OutputColumns(row.Table.Columns)
+	if len(columns) == 0 {
+		columns = ibdata.OutputColumns(row.Record.Columns)
+	}
+
+	var tags []string
+	for _, c := range columns {
+		pc := MakeParquetColumn(c)
+		tag := fmt.Sprintf("name=%s, type=%s", c.FieldName, pc.Type)
+		if pc.Type == "DECIMAL" {
+			tag += fmt.Sprintf(", basetype=%s, precision=%d, scale=%d", pc.BaseType,
+				c.Precision, c.Scale)
+			if pc.BaseType == "FIXED_LEN_BYTE_ARRAY" {
+				tag += fmt.Sprintf(", length=%d", pc.Length)
+			}
+		}
+		tags = append(tags, tag)
+		P.Columns = append(P.Columns, pc)
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(tags, P.w, 1)
+	if err != nil {
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	pw.RowGroupSize = P.Options.RowGroupSize
+	if pw.RowGroupSize <= 0 {
+		pw.RowGroupSize = DefaultRowGroupSize
+	}
+
+	// The schema elements are shared with the footer, set the logical types.
+	for i, pc := range P.Columns {
+		schema := pw.SchemaHandler.SchemaElements[i+1]
+		if pc.LogicalType != nil {
+			schema.LogicalType = pc.LogicalType
+			schema.ConvertedType = pc.ConvertedType
+		}
+	}
+
+	P.pw = pw
+	P.DBName = row.DBName
+	P.TableName = row.TableName
+	return nil
+}
+
+// Map the column to the Parquet type:
+// 1.The integer is INT32 or INT64 with the signed or unsigned bit width.
+// 2.The DECIMAL is INT32, INT64 or FIXED_LEN_BYTE_ARRAY by the precision,
+// it is the binary when the precision is unknown.
+// 3.The DATETIME and TIMESTAMP are the timestamp in microseconds, the
+// DATETIME is local time and the TIMESTAMP is adjusted to UTC.
+// 4.The DATE is the date, the TIME is the string like 838:59:59.
+// 5.The string of the UTF-8 character sets is the UTF-8 string, the binary
+// string and the other character sets are the binary.
+// 6.The JSON is the JSON string, the GEOMETRY is the WKB binary.
+func MakeParquetColumn(c ibdata.Columns) ParquetColumns {
+	pc := ParquetColumns{Column: c, Type: "UTF8"}
+
+	switch c.FieldType {
+	case utils.DATA_INT:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_DATE:
+			pc.Type = "DATE"
+		case utils.MYSQL_TYPE_YEAR:
+			pc.Type = "INT_16"
+		case utils.MYSQL_TYPE_ENUM, utils.MYSQL_TYPE_SET, utils.MYSQL_TYPE_STRING:
+			pc.Type = "UTF8"
+		default:
+			bits := map[uint64]string{1: "8", 2: "16", 3: "32", 4: "32", 8: "64"}[c.FieldLen]
+			if bits == "" {
+				bits = "64"
+			}
+			pc.Type = "INT_" + bits
+			if c.IsUnsigned {
+				pc.Type = "UINT_" + bits
+			}
+		}
+
+	case utils.DATA_FLOAT:
+		pc.Type = "FLOAT"
+
+	case utils.DATA_DOUBLE:
+		pc.Type = "DOUBLE"
+
+	case utils.DATA_FIXBINARY:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_TIME:
+			pc.Type = "UTF8"
+		case utils.MYSQL_TYPE_DATETIME:
+			pc.Type = "TIMESTAMP_MICROS"
+			pc.LogicalType = TimestampLogicalType(false)
+		case utils.MYSQL_TYPE_TIMESTAMP:
+			pc.Type = "TIMESTAMP_MICROS"
+			pc.LogicalType = TimestampLogicalType(true)
+			pc.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MICROS)
+		case utils.MYSQL_TYPE_BIT:
+			pc.Type = "UINT_64"
+		case utils.MYSQL_TYPE_NEWDECIMAL:
+			pc.Type = "BYTE_ARRAY"
+			if c.Precision > 0 {
+				pc.Type = "DECIMAL"
+				switch {
+				case c.Precision <= 9:
+					pc.BaseType = "INT32"
+				case c.Precision <= 18:
+					pc.BaseType = "INT64"
+				default:
+					pc.BaseType = "FIXED_LEN_BYTE_ARRAY"
+					pc.Length = DecimalBytes(c.Precision)
+				}
+			}
+		default:
+			pc.Type = "BYTE_ARRAY"
+		}
+
+	case utils.DATA_BINARY:
+		pc.Type = "BYTE_ARRAY"
+
+	case utils.DATA_VARCHAR, utils.DATA_CHAR, utils.DATA_VARMYSQL, utils.DATA_MYSQL:
+		if !IsUTF8Charset(c.Charset) {
+			pc.Type = "BYTE_ARRAY"
+		}
+
+	case utils.DATA_BLOB:
+		switch {
+		case c.MySQLType == utils.MYSQL_TYPE_JSON:
+			pc.Type = "UTF8"
+			pc.LogicalType = &parquet.LogicalType{JSON: parquet.NewJsonType()}
+			pc.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_JSON)
+		case c.MySQLType == utils.MYSQL_TYPE_GEOMETRY || c.IsBinary || !IsUTF8Charset(c.Charset):
+			pc.Type = "BYTE_ARRAY"
+		}
+
+	case utils.DATA_GEOMETRY, utils.DATA_VAR_POINT, utils.DATA_POINT:
+		pc.Type = "BYTE_ARRAY"
+	}
+	return pc
+}
+
+// The timestamp logical type in microseconds.
+func TimestampLogicalType(IsAdjustedToUTC bool) *parquet.LogicalType {
+	unit := parquet.NewTimeUnit()
+	unit.MICROS = parquet.NewMicroSeconds()
+	return &parquet.LogicalType{TIMESTAMP: &parquet.TimestampType{IsAdjustedToUTC: IsAdjustedToUTC, Unit: unit}}
+}
+
+// The bytes of the FIXED_LEN_BYTE_ARRAY which can store the decimal digits.
+func DecimalBytes(precision int) int32 {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	n := int32(1)
+	for new(big.Int).Lsh(big.NewInt(1), uint(8*n-1)).Cmp(max) < 0 {
+		n++
+	}
+	return n
+}
+
+// Whether the collation is the UTF-8 character set, 0 means unknown.
+func IsUTF8Charset(collation uint64) bool {
+	if collation == 0 {
+		return true
+	}
+	switch CollationCharset(collation) {
+	case "utf8", "utf8mb4", "ascii":
+		return true
+	}
+	return false
+}
+
+// Convert the column value to the Go type of the Parquet type. The value
+// which the Parquet type can't store, such as the zero date, is NULL.
+func (pc ParquetColumns) Value(c ibdata.Columns) (interface{}, error) {
+	if IsNull(c) {
+		return nil, nil
+	}
+
+	switch pc.Type {
+	case "INT_8", "INT_16", "INT_32", "UINT_8", "UINT_16", "UINT_32":
+		v, err := IntBits(c.FieldValue)
+		if err != nil {
+			return nil, err
+		}
+		// The year 0000 is stored as 0, the parser add 1900 to it.
+		if c.MySQLType == utils.MYSQL_TYPE_YEAR && v == 1900 {
+			v = 0
+		}
+		return int32(v), nil
+
+	case "INT_64", "UINT_64":
+		v, err := IntBits(c.FieldValue)
+		if err != nil {
+			return nil, err
+		}
+		return int64(v), nil
+
+	case "FLOAT":
+		f, err := FloatValue(c.FieldValue)
+		if err != nil {
+			return nil, err
+		}
+		return float32(f), nil
+
+	case "DOUBLE":
+		return FloatValue(c.FieldValue)
+
+	case "DATE":
+		t, err := time.Parse("2006-01-02", TextValue(c))
+		if err != nil {
+			WarnParquetValue(c, "the invalid date is NULL")
+			return nil, nil
+		}
+		return int32(t.Unix() / 86400), nil
+
+	case "TIMESTAMP_MICROS":
+		location := time.UTC
+		if c.MySQLType == utils.MYSQL_TYPE_TIMESTAMP {
+			location = time.Local
+		}
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", TextValue(c), location)
+		if err != nil {
+			WarnParquetValue(c, "the invalid datetime is NULL")
+			return nil, nil
+		}
+		return t.Unix()*1000000 + int64(t.Nanosecond()/1000), nil
+
+	case "DECIMAL":
+		if c.IsBinary {
+			return nil, fmt.Errorf("the decimal value is not decoded")
+		}
+		return DecimalValue(TextValue(c), pc.Column.Scale, pc.BaseType, pc.Length)
+
+	case "BYTE_ARRAY":
+		if g, ok := c.FieldValue.(utils.Geometry); ok {
+			return string(g.Bytes()[4:]), nil
+		}
+		data, err := BytesValue(nil, c)
+		if err != nil {
+			return nil, err
+		}
+		if pc.Column.FieldType == utils.DATA_GEOMETRY || pc.Column.FieldType == utils.DATA_VAR_POINT ||
+			pc.Column.FieldType == utils.DATA_POINT || pc.Column.MySQLType == utils.MYSQL_TYPE_GEOMETRY {
+			// The geometry can't be parsed, strip the SRID of the stored value.
+			if len(data) >= 4 {
+				data = data[4:]
+			}
+		}
+		return string(data), nil
+	}
+
+	// The JSON which can't be decoded is the hex of the binary JSON.
+	if c.MySQLType == utils.MYSQL_TYPE_JSON && c.IsBinary {
+		WarnParquetValue(c, "the binary JSON which can't be decoded is NULL")
+		return nil, nil
+	}
+	return TextValue(c), nil
+}
+
+// Convert the decimal string to the unscaled integer of the base type.
+func DecimalValue(s string, scale int, BaseType string, length int32) (interface{}, error) {
+	digits := s
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		frac := s[i+1:]
+		if len(frac) > scale {
+			frac = frac[:scale]
+		}
+		digits = s[:i] + frac + strings.Repeat("0", scale-len(frac))
+	} else {
+		digits += strings.Repeat("0", scale)
+	}
+
+	v, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("the decimal %s is invalid", s)
+	}
+
+	switch BaseType {
+	case "INT32":
+		return int32(v.Int64()), nil
+	case "INT64":
+		return v.Int64(), nil
+	}
+
+	// The big endian two's complement of the length bytes.
+	if v.Sign() < 0 {
+		v.Add(v, new(big.Int).Lsh(big.NewInt(1), uint(8*length)))
+	}
+	b := v.Bytes()
+	if int32(len(b)) > length {
+		return nil, fmt.Errorf("the decimal %s is out of range", s)
+	}
+	return strings.Repeat("\x00", int(length)-len(b)) + string(b), nil
+}
+
+// Warn once for every column which have the values Parquet can't store.
+func WarnParquetValue(c ibdata.Columns, msg string) {
+	key := fmt.Sprintf("%d.%s", c.TableID, c.FieldName)
+	if _, warned := WarnedParquetValues.LoadOrStore(key, true); !warned {
+		logs.Warn("the column ", c.FieldName, " have the value ", TextValue(c),
+			" which Parquet can't store, ", msg)
+	}
+}
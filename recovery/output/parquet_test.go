@@ -0,0 +1,154 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// The Parquet file in memory for the reader.
+type ParquetTestFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (f *ParquetTestFile) Open(name string) (source.ParquetFile, error) {
+	return &ParquetTestFile{Reader: bytes.NewReader(f.data), data: f.data}, nil
+}
+
+func (f *ParquetTestFile) Create(name string) (source.ParquetFile, error) {
+	return nil, fmt.Errorf("the Parquet test file is read only")
+}
+
+func (f *ParquetTestFile) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("the Parquet test file is read only")
+}
+
+func (f *ParquetTestFile) Close() error {
+	return nil
+}
+
+func TestDecimalValue(t *testing.T) {
+	for _, c := range []struct {
+		value    string
+		scale    int
+		BaseType string
+		length   int32
+		expected interface{}
+	}{
+		{"-12.34", 2, "INT32", 0, int32(-1234)},
+		{"12.3", 2, "INT32", 0, int32(1230)},
+		{"12.345", 2, "INT32", 0, int32(1234)},
+		{"123456789012.345678", 6, "INT64", 0, int64(123456789012345678)},
+		{"7", 0, "INT64", 0, int64(7)},
+		{"-1.5", 4, "FIXED_LEN_BYTE_ARRAY", 9, "\xff\xff\xff\xff\xff\xff\xff\xc5\x68"},
+		{"1.5", 4, "FIXED_LEN_BYTE_ARRAY", 9, "\x00\x00\x00\x00\x00\x00\x00\x3a\x98"},
+	} {
+		v, err := DecimalValue(c.value, c.scale, c.BaseType, c.length)
+		if err != nil || v != c.expected {
+			t.Errorf("decimal %s is %#v, expected %#v, the error is %v", c.value, v, c.expected, err)
+		}
+	}
+
+	if _, err := DecimalValue("1000", 0, "FIXED_LEN_BYTE_ARRAY", 1); err == nil {
+		t.Error("the decimal out of range is not rejected")
+	}
+	if n := DecimalBytes(20); n != 9 {
+		t.Errorf("the DECIMAL(20) bytes is %d, expected 9", n)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	// The table test.t1 (id INT, d DECIMAL(5,2), d2 DECIMAL(20,4), dt DATETIME(6), ts TIMESTAMP).
+	table := ibdata.Tables{DBName: "test", TableName: "t1", Columns: []ibdata.Columns{
+		{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4},
+		{FieldName: "d", FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_NEWDECIMAL,
+			FieldLen: 3, Precision: 5, Scale: 2, IsNUll: true},
+		{FieldName: "d2", FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_NEWDECIMAL,
+			FieldLen: 10, Precision: 20, Scale: 4, IsNUll: true},
+		{FieldName: "dt", FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_DATETIME,
+			FieldLen: 8, IsNUll: true},
+		{FieldName: "ts", FieldType: utils.DATA_FIXBINARY, MySQLType: utils.MYSQL_TYPE_TIMESTAMP,
+			FieldLen: 4, IsNUll: true},
+	}}
+	MakeRow := func(values ...interface{}) ibdata.Rows {
+		var columns []ibdata.Columns
+		for i, c := range table.Columns {
+			c.FieldValue = values[i]
+			columns = append(columns, c)
+		}
+		return ibdata.Rows{Op: ibdata.RowInsert, DBName: "test", TableName: "t1", Table: table,
+			Record: ibdata.Records{Columns: columns}}
+	}
+
+	var buf bytes.Buffer
+	w := NewParquetWriter(&buf, Options{Format: FormatParquet})
+	for _, row := range []ibdata.Rows{
+		MakeRow(1, "-12.34", "-1.5", "2019-01-02 03:04:05.123456", "2019-01-02 03:04:05"),
+		// The zero date can't be stored, it is NULL.
+		MakeRow(2, nil, nil, "0000-00-00 00:00:00", nil),
+	} {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := reader.NewParquetColumnReader(&ParquetTestFile{Reader: bytes.NewReader(buf.Bytes()),
+		data: buf.Bytes()}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if pr.GetNumRows() != 2 {
+		t.Fatalf("the Parquet file have %d rows, expected 2", pr.GetNumRows())
+	}
+
+	// The DATETIME is the local time and the TIMESTAMP is adjusted to UTC.
+	schema := pr.Footer.Schema
+	if schema[2].GetPrecision() != 5 || schema[2].GetScale() != 2 || schema[3].GetTypeLength() != 9 {
+		t.Errorf("the decimal schema is %v, %v", schema[2], schema[3])
+	}
+	if schema[4].LogicalType.TIMESTAMP.IsAdjustedToUTC || !schema[5].LogicalType.TIMESTAMP.IsAdjustedToUTC {
+		t.Errorf("the timestamp schema is %v, %v", schema[4].LogicalType, schema[5].LogicalType)
+	}
+
+	ts := time.Date(2019, 1, 2, 3, 4, 5, 0, time.Local).Unix() * 1000000
+	for i, expected := range [][]interface{}{
+		{int32(1), int32(2)},
+		{int32(-1234), nil},
+		{"\xff\xff\xff\xff\xff\xff\xff\xc5\x68", nil},
+		{int64(1546398245123456), nil},
+		{ts, nil},
+	} {
+		values, _, _, err := pr.ReadColumnByIndex(int64(i), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%#v", values) != fmt.Sprintf("%#v", expected) {
+			t.Errorf("the column %s is %#v, expected %#v", table.Columns[i].FieldName, values, expected)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Quote the PostgreSQL identifier.
+func QuotePostgresName(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// The MySQL database is the PostgreSQL schema.
+func PostgresTableName(DBName string, TableName string) string {
+	return QuotePostgresName(DBName) + "." + QuotePostgresName(TableName)
+}
+
+// Map the column to the PostgreSQL type:
+// 1.The integer is widened when the unsigned values don't fit the signed
+// type, the BIGINT UNSIGNED is numeric(20).
+// 2.The BIT(1) is boolean, the other BIT is bit(M).
+// 3.The DECIMAL is numeric, it is bytea when the precision is unknown.
+// 4.The TIME is interval, it can be larger than 24 hours.
+// 5.The binary string and BLOB are bytea, the JSON is jsonb.
+// 6.The other strings, ENUM, SET and GEOMETRY are text, the GEOMETRY is WKT.
+func PostgresType(c ibdata.Columns) string {
+	switch c.FieldType {
+	case utils.DATA_INT:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_DATE:
+			return "date"
+		case utils.MYSQL_TYPE_YEAR:
+			return "smallint"
+		case utils.MYSQL_TYPE_ENUM, utils.MYSQL_TYPE_SET, utils.MYSQL_TYPE_STRING:
+			return "text"
+		}
+		switch c.FieldLen {
+		case 1:
+			return "smallint"
+		case 2:
+			if c.IsUnsigned {
+				return "integer"
+			}
+			return "smallint"
+		case 3:
+			return "integer"
+		case 4:
+			if c.IsUnsigned {
+				return "bigint"
+			}
+			return "integer"
+		}
+		if c.IsUnsigned {
+			return "numeric(20)"
+		}
+		return "bigint"
+
+	case utils.DATA_FLOAT:
+		return "real"
+
+	case utils.DATA_DOUBLE:
+		return "double precision"
+
+	case utils.DATA_FIXBINARY:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_TIME:
+			return "interval"
+		case utils.MYSQL_TYPE_DATETIME:
+			return "timestamp"
+		case utils.MYSQL_TYPE_TIMESTAMP:
+			return "timestamp with time zone"
+		case utils.MYSQL_TYPE_BIT:
+			if IsPostgresBool(c) {
+				return "boolean"
+			}
+			return fmt.Sprintf("bit(%d)", BitWidth(c))
+		case utils.MYSQL_TYPE_NEWDECIMAL:
+			if c.Precision > 0 {
+				return fmt.Sprintf("numeric(%d,%d)", c.Precision, c.Scale)
+			}
+		}
+		return "bytea"
+
+	case utils.DATA_BINARY:
+		return "bytea"
+
+	case utils.DATA_BLOB:
+		switch {
+		case c.MySQLType == utils.MYSQL_TYPE_JSON:
+			return "jsonb"
+		case c.MySQLType == utils.MYSQL_TYPE_GEOMETRY:
+			return "text"
+		case c.IsBinary:
+			return "bytea"
+		}
+	}
+	return "text"
+}
+
+// Whether the column is BIT(1), which is the boolean in PostgreSQL.
+func IsPostgresBool(c ibdata.Columns) bool {
+	return c.MySQLType == utils.MYSQL_TYPE_BIT && c.Precision == 1
+}
+
+// Get the bits of the BIT column, all bits of the bytes when the table
+// struct is not identified.
+func BitWidth(c ibdata.Columns) int {
+	if c.Precision > 0 {
+		return c.Precision
+	}
+	return int(c.FieldLen) * 8
+}
+
+// Get the text of the column value which PostgreSQL can read as the column
+// type, and whether it is quoted in the sql, false when it is NULL. The
+// binary value is the bytea hex like \x00ff, the zero date and the binary
+// JSON which can't be decoded are NULL.
+func PostgresText(c ibdata.Columns) (string, bool, bool) {
+	if IsNull(c) {
+		return "", false, false
+	}
+
+	switch v := c.FieldValue.(type) {
+	case utils.Geometry:
+		return v.WKT(), true, true
+
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		number := fmt.Sprintf("%d", v)
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_BIT:
+			n, _ := strconv.ParseUint(number, 10, 64)
+			if IsPostgresBool(c) {
+				return strconv.FormatBool(n != 0), false, true
+			}
+			bits := strconv.FormatUint(n, 2)
+			if width := BitWidth(c); len(bits) < width {
+				bits = strings.Repeat("0", width-len(bits)) + bits
+			}
+			return bits, true, true
+		case utils.MYSQL_TYPE_YEAR:
+			// The year 0000 is stored as 0, the parser add 1900 to it.
+			if number == "1900" {
+				return "0", false, true
+			}
+		}
+		return number, false, true
+
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return "", false, false
+		}
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), false, true
+
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", false, false
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), false, true
+
+	case string:
+		// The text of PostgreSQL can't have the NUL character.
+		if !c.IsBinary && strings.Contains(v, "\x00") {
+			WarnPostgresValue(c, "the NUL characters are removed")
+			v = strings.Replace(v, "\x00", "", -1)
+		}
+		if c.IsBinary {
+			if c.MySQLType == utils.MYSQL_TYPE_JSON {
+				WarnPostgresValue(c, "the binary JSON which can't be decoded is NULL")
+				return "", false, false
+			}
+			// The parser made the binary value to the hex string.
+			return `\x` + v, true, true
+		}
+		if c.MySQLType == utils.MYSQL_TYPE_NEWDECIMAL && IsDecimal(v) {
+			return v, false, true
+		}
+		if !IsPostgresDate(c, v) {
+			WarnPostgresValue(c, "the invalid date is NULL")
+			return "", false, false
+		}
+		return v, true, true
+	}
+	return TextValue(c), true, true
+}
+
+// Whether the date value is valid in PostgreSQL, the zero date is not.
+func IsPostgresDate(c ibdata.Columns, value string) bool {
+	var err error
+	switch c.MySQLType {
+	case utils.MYSQL_TYPE_DATE:
+		_, err = time.Parse("2006-01-02", value)
+	case utils.MYSQL_TYPE_DATETIME, utils.MYSQL_TYPE_TIMESTAMP:
+		_, err = time.Parse("2006-01-02 15:04:05", value)
+	}
+	return err == nil
+}
+
+// Render the column value to the PostgreSQL literal, the string is quoted
+// by the standard conforming strings, which don't escape the backslash.
+func PostgresLiteral(c ibdata.Columns) string {
+	text, quoted, ok := PostgresText(c)
+	if !ok {
+		return "NULL"
+	}
+	if quoted {
+		return "'" + strings.Replace(text, "'", "''", -1) + "'"
+	}
+	return text
+}
+
+// Render the column value to the field of the COPY text format.
+func PostgresCopyValue(c ibdata.Columns) string {
+	text, _, ok := PostgresText(c)
+	if !ok {
+		return `\N`
+	}
+	return CopyEscape(text)
+}
+
+// Escape the field of the COPY text format by backslash. It is not the
+// TSVEscape, because COPY read the \0 as the octal NUL which the text
+// can't have, the NUL is removed by PostgresText.
+// Reference PostgreSQL document COPY, File Formats, Text Format.
+func CopyEscape(field string) string {
+	if !strings.ContainsAny(field, "\\\t\n\r") {
+		return field
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(field); i++ {
+		switch field[i] {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			buf.WriteByte(field[i])
+		}
+	}
+	return buf.String()
+}
+
+// Get the primary key column names of the table.
+func PostgresPrimaryKey(table ibdata.Tables) []string {
+	var names []string
+	for _, idx := range table.Indexes {
+		if idx.Name != "PRIMARY" {
+			continue
+		}
+		for _, f := range idx.Fields {
+			names = append(names, f.ColumnName)
+		}
+	}
+	return names
+}
+
+// Make the CREATE TABLE statement of the row's table, the columns are from
+// the table struct, or the row when the table is unknown. The primary key
+// is the constraint when the unique is true, which the on conflict need.
+// Otherwise it is indexed without the unique constraint like the SQLite
+// table, because the COPY can't skip the many versions of the same row.
+func MakePostgresTable(row ibdata.Rows, unique bool) []string {
+	columns := ibdata.OutputColumns(row.Table.Columns)
+	if len(columns) == 0 {
+		columns = RowColumns(row)
+	}
+
+	var defs []string
+	for _, c := range columns {
+		def := QuotePostgresName(c.FieldName) + " " + PostgresType(c)
+		if !c.IsNUll && len(row.Table.Columns) != 0 {
+			def += " NOT NULL"
+		}
+		defs = append(defs, def)
+	}
+
+	var keys []string
+	for _, name := range PostgresPrimaryKey(row.Table) {
+		keys = append(keys, QuotePostgresName(name))
+	}
+	if len(keys) != 0 && unique {
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+
+	name := PostgresTableName(row.DBName, row.TableName)
+	queries := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n);", name, strings.Join(defs, ",\n  "))}
+	if len(keys) != 0 && !unique {
+		queries = append(queries, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s);",
+			QuotePostgresName(row.TableName+"_PRIMARY"), name, strings.Join(keys, ", ")))
+	}
+	return queries
+}
+
+// The columns which have been warned, the key is the table id and the column name.
+var WarnedPostgresValues sync.Map
+
+// Warn once for every column which have the values PostgreSQL can't store.
+func WarnPostgresValue(c ibdata.Columns, msg string) {
+	key := fmt.Sprintf("%d.%s", c.TableID, c.FieldName)
+	if _, warned := WarnedPostgresValues.LoadOrStore(key, true); !warned {
+		logs.Warn("the column ", c.FieldName, " have the value ", TextValue(c),
+			" which PostgreSQL can't store, ", msg)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+func TestPostgresCopyValue(t *testing.T) {
+	text := ibdata.Columns{FieldName: "c1", FieldType: utils.DATA_VARMYSQL, IsNUll: true}
+	bytea := ibdata.Columns{FieldName: "c2", FieldType: utils.DATA_BLOB, IsBinary: true, IsNUll: true}
+
+	for _, c := range []struct {
+		column   ibdata.Columns
+		value    interface{}
+		expected string
+	}{
+		{text, "a\tb\nc\rd\\e", `a\tb\nc\rd\\e`},
+		// The NUL is removed, COPY read \0 as the NUL and reject it.
+		{text, "a\x00b", "ab"},
+		{text, `\.`, `\\.`},
+		{text, nil, `\N`},
+		// The string NULL is not the NULL.
+		{text, "NULL", "NULL"},
+		// The bytea hex have the backslash escaped.
+		{bytea, "00FF", `\\x00FF`},
+	} {
+		column := c.column
+		column.FieldValue = c.value
+		if value := PostgresCopyValue(column); value != c.expected {
+			t.Errorf("the COPY value of %q is %q, expected %q", c.value, value, c.expected)
+		}
+	}
+}
+
+func TestPostgresCopyTable(t *testing.T) {
+	row := MakeApplyTestRow(1)
+	row.Record.Columns[0].FieldValue = 1
+
+	var buf bytes.Buffer
+	w := NewSQLWriter(&buf, "insert", Options{Format: FormatCopy, Dialect: DialectPostgres})
+	for i := 0; i < 2; i++ {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The two versions of the same row can be copied to the table.
+	expected := `CREATE SCHEMA IF NOT EXISTS "test";
+CREATE TABLE IF NOT EXISTS "test"."t1" (
+  "id" integer NOT NULL
+);
+CREATE INDEX IF NOT EXISTS "t1_PRIMARY" ON "test"."t1" ("id");
+COPY "test"."t1" ("id") FROM STDIN;
+1
+1
+\.
+`
+	if buf.String() != expected {
+		t.Errorf("the COPY output is:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	// The on conflict need the primary key constraint.
+	queries := MakePostgresTable(row, true)
+	if len(queries) != 1 || !strings.Contains(queries[0], `PRIMARY KEY ("id")`) {
+		t.Errorf("the table is %v", queries)
+	}
+}
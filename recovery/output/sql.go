@@ -0,0 +1,481 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// Write the rows as sql statements, the statement is followed by a comment
+// which label the record state. The read rows can be batched into the
+// multi-row insert statement, every row is on its own line with the label.
+// In the PostgreSQL dialect, the table is created before its first row,
+// the replace is the insert on conflict do update, and the read rows of
+// the copy format are written to the COPY blocks without the labels.
+type SQLWriter struct {
+	w *bufio.Writer
+
+	// The verb of the statement for the read rows, replace, insert or insert ignore.
+	Verb string
+
+	Options Options
+
+	// Whether the preamble have been written.
+	started bool
+
+	// The statements in the current transaction.
+	InTxn      bool
+	statements int
+
+	// The insert statement prefix and suffix, the row values and comments of the current batch.
+	prefix     string
+	suffix     string
+	batch      []string
+	comments   []string
+	BatchBytes int
+
+	// The primary keys in the batch, PostgreSQL can't update the same
+	// row twice in the insert on conflict do update.
+	BatchKeys map[string]bool
+
+	// The COPY statement and the rows of the current COPY block.
+	CopyHeader string
+	CopyRows   int
+
+	// The PostgreSQL schemas and tables which have been created.
+	schemas map[string]bool
+	tables  map[string]bool
+}
+
+func NewSQLWriter(w io.Writer, verb string, opts Options) *SQLWriter {
+	return &SQLWriter{w: bufio.NewWriter(w), Verb: verb, Options: opts,
+		BatchKeys: make(map[string]bool), schemas: make(map[string]bool), tables: make(map[string]bool)}
+}
+
+func (S *SQLWriter) WriteRow(row ibdata.Rows) error {
+	if err := S.Begin(); err != nil {
+		return err
+	}
+
+	if S.Options.Dialect == DialectPostgres {
+		if err := S.CreatePostgresTable(row); err != nil {
+			return err
+		}
+	}
+
+	var comment string
+	if row.Label != "" {
+		comment = " -- " + row.Label
+	}
+
+	if row.Op != ibdata.RowInsert {
+		if err := S.EndCopy(); err != nil {
+			return err
+		}
+		if err := S.FlushBatch(); err != nil {
+			return err
+		}
+		return S.WriteStatement(S.MakeSQL(row) + comment)
+	}
+
+	if S.Options.Format == FormatCopy {
+		return S.WriteCopyRow(row)
+	}
+
+	prefix, suffix, values := S.MakeInsertPrefix(row), S.MakeInsertSuffix(row), S.MakeInsertValues(row)
+	if S.Options.BatchRows <= 1 {
+		return S.WriteStatement(prefix + " " + values + suffix + ";" + comment)
+	}
+
+	// The batch is flushed when the table changed, or it is full, or the
+	// row make the statement larger than the max statement bytes.
+	size := len(values) + len(comment) + 2
+	if prefix != S.prefix {
+		if err := S.FlushBatch(); err != nil {
+			return err
+		}
+		S.prefix, S.suffix = prefix, suffix
+	}
+	if len(S.batch) != 0 && S.Options.MaxStatementBytes > 0 &&
+		len(S.prefix)+len(S.suffix)+S.BatchBytes+size > S.Options.MaxStatementBytes {
+		if err := S.FlushBatch(); err != nil {
+			return err
+		}
+	}
+	key := S.MakeBatchKey(row)
+	if key != "" && S.BatchKeys[key] {
+		if err := S.FlushBatch(); err != nil {
+			return err
+		}
+	}
+	if S.Options.MaxStatementBytes > 0 && len(S.prefix)+len(S.suffix)+size > S.Options.MaxStatementBytes {
+		logs.Warn("the row of ", row.TableName, " is larger than the max statement bytes ",
+			S.Options.MaxStatementBytes)
+	}
+
+	S.batch = append(S.batch, values)
+	S.comments = append(S.comments, comment)
+	S.BatchBytes += size
+	if key != "" {
+		S.BatchKeys[key] = true
+	}
+	if len(S.batch) >= S.Options.BatchRows {
+		return S.FlushBatch()
+	}
+	return nil
+}
+
+func (S *SQLWriter) Close() error {
+	if err := S.EndCopy(); err != nil {
+		return err
+	}
+	if err := S.FlushBatch(); err != nil {
+		return err
+	}
+	if S.InTxn {
+		if _, err := S.w.WriteString("COMMIT;\n"); err != nil {
+			return err
+		}
+		S.InTxn = false
+	}
+	if S.started && S.Options.DisableChecks {
+		query := "SET unique_checks=1, foreign_key_checks=1;\n"
+		if S.Options.Dialect == DialectPostgres {
+			query = "SET session_replication_role = DEFAULT;\n"
+		}
+		if _, err := S.w.WriteString(query); err != nil {
+			return err
+		}
+	}
+	return S.w.Flush()
+}
+
+// Write the preamble before the first statement.
+func (S *SQLWriter) Begin() error {
+	if S.started {
+		return nil
+	}
+	S.started = true
+	if S.Options.DisableChecks {
+		// The session_replication_role disable the foreign key triggers
+		// of PostgreSQL, it need the superuser.
+		query := "SET unique_checks=0, foreign_key_checks=0;\n"
+		if S.Options.Dialect == DialectPostgres {
+			query = "SET session_replication_role = replica;\n"
+		}
+		if _, err := S.w.WriteString(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the batched rows as one multi-row insert statement, every row
+// is on its own line, followed by the comma or the semicolon and the label.
+func (S *SQLWriter) FlushBatch() error {
+	if len(S.batch) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(S.prefix)
+	for i, values := range S.batch {
+		buf.WriteByte('\n')
+		buf.WriteString(values)
+		if i == len(S.batch)-1 {
+			buf.WriteString(S.suffix)
+			buf.WriteByte(';')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(S.comments[i])
+	}
+
+	S.batch = S.batch[:0]
+	S.comments = S.comments[:0]
+	S.BatchBytes = 0
+	for key := range S.BatchKeys {
+		delete(S.BatchKeys, key)
+	}
+	return S.WriteStatement(buf.String())
+}
+
+// Write the statement, and the BEGIN and COMMIT around every TxnStatements statements.
+func (S *SQLWriter) WriteStatement(query string) error {
+	logs.Debug("query is ", query)
+
+	if err := S.BeginTxn(); err != nil {
+		return err
+	}
+	if _, err := S.w.WriteString(query + "\n"); err != nil {
+		return err
+	}
+	return S.CountStatement()
+}
+
+// Write the BEGIN before the first statement of the transaction.
+func (S *SQLWriter) BeginTxn() error {
+	if S.Options.TxnStatements > 0 && !S.InTxn {
+		if _, err := S.w.WriteString("BEGIN;\n"); err != nil {
+			return err
+		}
+		S.InTxn = true
+	}
+	return nil
+}
+
+// Count the written statement, and write the COMMIT after TxnStatements statements.
+func (S *SQLWriter) CountStatement() error {
+	if S.Options.TxnStatements > 0 {
+		S.statements++
+		if S.statements >= S.Options.TxnStatements {
+			if _, err := S.w.WriteString("COMMIT;\n"); err != nil {
+				return err
+			}
+			S.InTxn = false
+			S.statements = 0
+		}
+	}
+	return nil
+}
+
+// Make the update or delete row to the sql statement.
+func (S *SQLWriter) MakeSQL(row ibdata.Rows) string {
+	switch row.Op {
+	case ibdata.RowUpdate:
+		return fmt.Sprintf("update %s set %s where %s;", S.TableName(row),
+			strings.Join(S.MakeAssignments(ibdata.OutputColumns(row.Record.Columns)), ", "),
+			strings.Join(S.MakeAssignments(row.Keys), " and "))
+	case ibdata.RowDelete:
+		return fmt.Sprintf("delete from %s where %s;", S.TableName(row),
+			strings.Join(S.MakeAssignments(row.Keys), " and "))
+	}
+	return S.MakeInsertPrefix(row) + " " + S.MakeInsertValues(row) + S.MakeInsertSuffix(row) + ";"
+}
+
+// Make the insert statement before the values, the rows of the same table
+// and the same columns can be batched. The column list is always identified,
+// the virtual column is omitted and the partial row only have some columns.
+func (S *SQLWriter) MakeInsertPrefix(row ibdata.Rows) string {
+	var names []string
+	for _, c := range ibdata.OutputColumns(row.Record.Columns) {
+		names = append(names, S.QuoteName(c.FieldName))
+	}
+
+	// PostgreSQL don't have the replace and insert ignore.
+	verb := S.Verb
+	if S.Options.Dialect == DialectPostgres {
+		verb = "insert"
+	}
+	return fmt.Sprintf("%s into %s (%s) values", verb, S.TableName(row), strings.Join(names, ","))
+}
+
+// Make the on conflict clause after the values in the PostgreSQL dialect,
+// the replace update the other columns of the conflict primary key, it is
+// the plain insert when the primary key is unknown like the MySQL replace.
+func (S *SQLWriter) MakeInsertSuffix(row ibdata.Rows) string {
+	if S.Options.Dialect != DialectPostgres {
+		return ""
+	}
+
+	switch S.Verb {
+	case "insert ignore":
+		return " on conflict do nothing"
+	case "replace":
+		keys := PostgresPrimaryKey(row.Table)
+		if len(keys) == 0 {
+			return ""
+		}
+		var names, assignments []string
+		for _, name := range keys {
+			names = append(names, QuotePostgresName(name))
+		}
+		for _, c := range ibdata.OutputColumns(row.Record.Columns) {
+			if !HaveName(keys, c.FieldName) {
+				name := QuotePostgresName(c.FieldName)
+				assignments = append(assignments, name+"=excluded."+name)
+			}
+		}
+		if len(assignments) == 0 {
+			return fmt.Sprintf(" on conflict (%s) do nothing", strings.Join(names, ","))
+		}
+		return fmt.Sprintf(" on conflict (%s) do update set %s", strings.Join(names, ","),
+			strings.Join(assignments, ", "))
+	}
+	return ""
+}
+
+// Make the primary key values of the read row in the PostgreSQL replace,
+// the rows of the same key are not in the same batch. It is empty for the
+// other statements.
+func (S *SQLWriter) MakeBatchKey(row ibdata.Rows) string {
+	if S.Options.Dialect != DialectPostgres || S.Verb != "replace" {
+		return ""
+	}
+	var values []string
+	for _, name := range PostgresPrimaryKey(row.Table) {
+		for _, c := range row.Record.Columns {
+			if c.FieldName == name {
+				values = append(values, PostgresLiteral(c))
+			}
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+// Make the values of the row, like (1,'a').
+func (S *SQLWriter) MakeInsertValues(row ibdata.Rows) string {
+	var values []string
+	for _, c := range ibdata.OutputColumns(row.Record.Columns) {
+		values = append(values, S.Literal(c))
+	}
+	return "(" + strings.Join(values, ",") + ")"
+}
+
+// Make the columns to `name`=value list, use it in set and where clauses.
+func (S *SQLWriter) MakeAssignments(columns []ibdata.Columns) []string {
+	var assignments []string
+	for _, c := range columns {
+		assignments = append(assignments, S.QuoteName(c.FieldName)+"="+S.Literal(c))
+	}
+	return assignments
+}
+
+// Quote the identifier by the dialect.
+func (S *SQLWriter) QuoteName(name string) string {
+	if S.Options.Dialect == DialectPostgres {
+		return QuotePostgresName(name)
+	}
+	return QuoteMySQLName(name)
+}
+
+// Quote the MySQL identifier, the backtick in it is doubled.
+func QuoteMySQLName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// Make the qualified table name of the row by the dialect.
+func (S *SQLWriter) TableName(row ibdata.Rows) string {
+	return S.QuoteName(row.DBName) + "." + S.QuoteName(row.TableName)
+}
+
+// Render the column value to the literal of the dialect.
+func (S *SQLWriter) Literal(c ibdata.Columns) string {
+	if S.Options.Dialect == DialectPostgres {
+		return PostgresLiteral(c)
+	}
+	return SQLLiteral(c)
+}
+
+// Write the schema and the table before the first row of the table in the
+// PostgreSQL dialect, they are created when they don't exist.
+func (S *SQLWriter) CreatePostgresTable(row ibdata.Rows) error {
+	key := row.DBName + "\x00" + row.TableName
+	if S.tables[key] {
+		return nil
+	}
+	S.tables[key] = true
+
+	// The DDL can't be in the COPY block or the batch.
+	if err := S.EndCopy(); err != nil {
+		return err
+	}
+	if err := S.FlushBatch(); err != nil {
+		return err
+	}
+
+	var queries []string
+	if !S.schemas[row.DBName] {
+		S.schemas[row.DBName] = true
+		queries = append(queries, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", QuotePostgresName(row.DBName)))
+	}
+	queries = append(queries, MakePostgresTable(row, S.Options.Format != FormatCopy)...)
+
+	for _, query := range queries {
+		if _, err := S.w.WriteString(query + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the read row to the COPY block of its table, the block is ended when
+// the table or the columns changed, or it have BatchRows rows.
+func (S *SQLWriter) WriteCopyRow(row ibdata.Rows) error {
+	columns := ibdata.OutputColumns(row.Record.Columns)
+
+	var names []string
+	for _, c := range columns {
+		names = append(names, QuotePostgresName(c.FieldName))
+	}
+	header := fmt.Sprintf("COPY %s (%s) FROM STDIN;", S.TableName(row), strings.Join(names, ", "))
+
+	if header != S.CopyHeader {
+		if err := S.EndCopy(); err != nil {
+			return err
+		}
+		if err := S.BeginTxn(); err != nil {
+			return err
+		}
+		if _, err := S.w.WriteString(header + "\n"); err != nil {
+			return err
+		}
+		S.CopyHeader = header
+	}
+
+	var values []string
+	for _, c := range columns {
+		values = append(values, PostgresCopyValue(c))
+	}
+	if _, err := S.w.WriteString(strings.Join(values, "\t") + "\n"); err != nil {
+		return err
+	}
+
+	S.CopyRows++
+	if S.Options.BatchRows > 1 && S.CopyRows >= S.Options.BatchRows {
+		return S.EndCopy()
+	}
+	return nil
+}
+
+// End the COPY block, the block is one statement in the transaction.
+func (S *SQLWriter) EndCopy() error {
+	if S.CopyHeader == "" {
+		return nil
+	}
+	if _, err := S.w.WriteString("\\.\n"); err != nil {
+		return err
+	}
+	S.CopyHeader = ""
+	S.CopyRows = 0
+	return S.CountStatement()
+}
+
+// Whether the names have the name.
+func HaveName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
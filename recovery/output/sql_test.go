@@ -0,0 +1,149 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// Make the insert rows of test.t1 (id INT) with the ids, and the delete of
+// the id when it is negative.
+func MakeSQLTestRows(ids ...int) []ibdata.Rows {
+	var rows []ibdata.Rows
+	for _, id := range ids {
+		row := ibdata.Rows{Op: ibdata.RowInsert, Source: ibdata.RowSourceData, DBName: "test", TableName: "t1",
+			Label: fmt.Sprintf("r%d", id)}
+		if id < 0 {
+			id = -id
+			row.Op, row.Source = ibdata.RowDelete, ibdata.RowSourceUndo
+		}
+		column := ibdata.Columns{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 4, FieldValue: id}
+		if row.Op == ibdata.RowInsert {
+			row.Record.Columns = []ibdata.Columns{column}
+		} else {
+			row.Keys = []ibdata.Columns{column}
+		}
+		row.Table = ibdata.Tables{DBName: "test", TableName: "t1", Columns: []ibdata.Columns{column}}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestSQLWriterBatch(t *testing.T) {
+	// The prefix is 38 bytes, every row is 11 bytes with the label comment,
+	// the comma and the line break.
+	prefix := "replace into `test`.`t1` (`id`) values"
+	for _, c := range []struct {
+		name     string
+		opts     Options
+		ids      []int
+		expected []string
+	}{
+		{"no batch", Options{}, []int{1, 2}, []string{
+			prefix + " (1); -- r1",
+			prefix + " (2); -- r2",
+		}},
+		{"batch rows", Options{BatchRows: 2}, []int{1, 2, 3, 4, 5}, []string{
+			prefix, "(1), -- r1", "(2); -- r2",
+			prefix, "(3), -- r3", "(4); -- r4",
+			prefix, "(5); -- r5",
+		}},
+		{"statement bytes", Options{BatchRows: 10, MaxStatementBytes: 38 + 2*11}, []int{1, 2, 3, 4, 5}, []string{
+			prefix, "(1), -- r1", "(2); -- r2",
+			prefix, "(3), -- r3", "(4); -- r4",
+			prefix, "(5); -- r5",
+		}},
+		{"row larger than the statement bytes", Options{BatchRows: 10, MaxStatementBytes: 10}, []int{1, 2}, []string{
+			prefix, "(1); -- r1",
+			prefix, "(2); -- r2",
+		}},
+		// The delete flush the batch, the COMMIT is after every 2 statements.
+		{"transactions", Options{BatchRows: 2, TxnStatements: 2, DisableChecks: true}, []int{1, 2, 3, -1, 4}, []string{
+			"SET unique_checks=0, foreign_key_checks=0;",
+			"BEGIN;",
+			prefix, "(1), -- r1", "(2); -- r2",
+			prefix, "(3); -- r3",
+			"COMMIT;",
+			"BEGIN;",
+			"delete from `test`.`t1` where `id`=1; -- r-1",
+			prefix, "(4); -- r4",
+			"COMMIT;",
+			"SET unique_checks=1, foreign_key_checks=1;",
+		}},
+		// The last transaction is committed when the writer is closed.
+		{"commit at close", Options{TxnStatements: 2}, []int{1, 2, 3}, []string{
+			"BEGIN;",
+			prefix + " (1); -- r1",
+			prefix + " (2); -- r2",
+			"COMMIT;",
+			"BEGIN;",
+			prefix + " (3); -- r3",
+			"COMMIT;",
+		}},
+		{"postgres", Options{Dialect: DialectPostgres, BatchRows: 2, DisableChecks: true}, []int{-1}, []string{
+			"SET session_replication_role = replica;",
+			`CREATE SCHEMA IF NOT EXISTS "test";`,
+		}},
+	} {
+		var buf bytes.Buffer
+		w := NewSQLWriter(&buf, "replace", c.opts)
+		for _, row := range MakeSQLTestRows(c.ids...) {
+			if err := w.WriteRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := strings.Join(c.expected, "\n") + "\n"
+		if c.opts.Dialect == DialectPostgres {
+			// Only check the preamble and the restore.
+			if !strings.HasPrefix(buf.String(), expected) ||
+				!strings.HasSuffix(buf.String(), "\nSET session_replication_role = DEFAULT;\n") {
+				t.Errorf("%s: the sql is %q", c.name, buf.String())
+			}
+			continue
+		}
+		if buf.String() != expected {
+			t.Errorf("%s: the sql is\n%s\nexpected\n%s", c.name, buf.String(), expected)
+		}
+	}
+
+	// Nothing is written without the rows.
+	var buf bytes.Buffer
+	w := NewSQLWriter(&buf, "replace", Options{DisableChecks: true, TxnStatements: 1})
+	if err := w.Close(); err != nil || buf.Len() != 0 {
+		t.Errorf("the sql without the rows is %q, the error is %v", buf.String(), err)
+	}
+}
+
+func TestQuoteMySQLName(t *testing.T) {
+	row := MakeSQLTestRows(1)[0]
+	row.DBName, row.TableName, row.Label = "a`b", "t1`", ""
+	row.Record.Columns[0].FieldName = "`id`"
+
+	w := NewSQLWriter(&bytes.Buffer{}, "replace", Options{})
+	expected := "replace into `a``b`.`t1``` (```id```) values (1);"
+	if query := w.MakeSQL(row); query != expected {
+		t.Errorf("the sql is %s, expected %s", query, expected)
+	}
+}
@@ -0,0 +1,397 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The rows per transaction when the TxnStatements is not set.
+const DefaultSQLiteTxnRows int = 10000
+
+// The provenance columns after the table columns, where the row come from.
+var SQLiteProvenanceColumns = []SQLiteColumns{
+	{Column: ibdata.Columns{FieldName: "_op"}, Type: "TEXT"},
+	{Column: ibdata.Columns{FieldName: "_source"}, Type: "TEXT"},
+	{Column: ibdata.Columns{FieldName: "_file"}, Type: "TEXT"},
+	{Column: ibdata.Columns{FieldName: "_page"}, Type: "INTEGER"},
+	{Column: ibdata.Columns{FieldName: "_offset"}, Type: "INTEGER"},
+	{Column: ibdata.Columns{FieldName: "_state"}, Type: "TEXT"},
+	{Column: ibdata.Columns{FieldName: "_trx_id"}, Type: "INTEGER"},
+}
+
+// The table of a recovered MySQL table in the SQLite database.
+type SQLiteTables struct {
+	Name    string
+	Columns []SQLiteColumns
+
+	stmt *sql.Stmt
+}
+
+// The column of the SQLite table and its type mapped from the MySQL column.
+type SQLiteColumns struct {
+	Column ibdata.Columns
+	Type   string
+}
+
+// Write the rows to the SQLite database file which can be queried at once.
+// Every MySQL table is created as the table named like db.table, the columns
+// are from the data dict with the provenance columns, and the primary key
+// columns are indexed without the unique constraint, because the recovered
+// rows may have many versions of the same key. The rows are inserted in the
+// transaction of every TxnStatements rows.
+type SQLiteWriter struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	Path string
+
+	Options Options
+	TxnRows int
+	pending int
+
+	Tables map[string]*SQLiteTables
+	Rows   uint64
+}
+
+// Create the SQLite database file, the existing file is replaced.
+func OpenSQLiteWriter(path string, opts Options) (*SQLiteWriter, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logs.Error("remove the SQLite file failed, the error is ", err)
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		logs.Error("open the SQLite file failed, the error is ", err)
+		return nil, err
+	}
+
+	// The pragmas are set on the only connection, the database is a
+	// scratch copy, so it is not synced.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA synchronous=OFF; PRAGMA journal_mode=MEMORY"); err != nil {
+		db.Close()
+		logs.Error("set the SQLite pragmas failed, the error is ", err)
+		return nil, err
+	}
+
+	TxnRows := opts.TxnStatements
+	if TxnRows <= 0 {
+		TxnRows = DefaultSQLiteTxnRows
+	}
+
+	return &SQLiteWriter{
+		db:      db,
+		Path:    path,
+		Options: opts,
+		TxnRows: TxnRows,
+		Tables:  make(map[string]*SQLiteTables)}, nil
+}
+
+func (S *SQLiteWriter) WriteRow(row ibdata.Rows) error {
+	key := row.DBName + "\x00" + row.TableName
+	t, ok := S.Tables[key]
+	if !ok {
+		// The table is created on the only connection, which is
+		// used by the transaction.
+		if err := S.Commit(); err != nil {
+			return err
+		}
+		var err error
+		if t, err = S.CreateTable(row); err != nil {
+			return err
+		}
+		S.Tables[key] = t
+	}
+
+	if S.tx == nil {
+		var err error
+		if S.tx, err = S.db.Begin(); err != nil {
+			return err
+		}
+	}
+
+	// The partial row only have some columns, the others are NULL.
+	columns := RowColumns(row)
+	values := make([]interface{}, 0, len(t.Columns)+len(SQLiteProvenanceColumns))
+	for _, sc := range t.Columns {
+		var v interface{}
+		for _, c := range columns {
+			if c.FieldName != sc.Column.FieldName {
+				continue
+			}
+			var err error
+			if v, err = SQLiteValue(c); err != nil {
+				return fmt.Errorf("the value of the column %s in %s.%s is invalid, %v",
+					c.FieldName, row.DBName, row.TableName, err)
+			}
+		}
+		values = append(values, v)
+	}
+	values = append(values, row.Op, row.Source, SQLiteOptional(row.File), SQLiteOptional(row.Record.PageNo),
+		SQLiteOptional(row.Record.Offset), SQLiteOptional(row.Record.State), SQLiteOptional(row.Record.TrxId))
+
+	if _, err := S.tx.Stmt(t.stmt).Exec(values...); err != nil {
+		return fmt.Errorf("insert the row of %s.%s to SQLite failed, %v", row.DBName, row.TableName, err)
+	}
+	S.Rows++
+
+	S.pending++
+	if S.pending >= S.TxnRows {
+		return S.Commit()
+	}
+	return nil
+}
+
+func (S *SQLiteWriter) Close() error {
+	CommitErr := S.Commit()
+	for _, t := range S.Tables {
+		t.stmt.Close()
+	}
+	if err := S.db.Close(); err != nil && CommitErr == nil {
+		CommitErr = err
+	}
+	logs.Info("write ", S.Rows, " rows of ", len(S.Tables), " tables to ", S.Path)
+	return CommitErr
+}
+
+// Commit the rows inserted in the transaction.
+func (S *SQLiteWriter) Commit() error {
+	if S.tx == nil {
+		return nil
+	}
+	err := S.tx.Commit()
+	S.tx = nil
+	S.pending = 0
+	if err != nil {
+		logs.Error("commit the SQLite transaction failed, the error is ", err)
+	}
+	return err
+}
+
+// Create the table of the row and the index of the primary key, and prepare
+// the insert statement.
+func (S *SQLiteWriter) CreateTable(row ibdata.Rows) (*SQLiteTables, error) {
+	t := &SQLiteTables{Name: row.DBName + "." + row.TableName}
+
+	// The table struct from the data dict have all columns, the rows
+	// of the unknown table only have their own columns.
+	columns := ibdata.OutputColumns(row.Table.Columns)
+	if len(columns) == 0 {
+		columns = RowColumns(row)
+	}
+
+	for _, c := range columns {
+		for _, pc := range SQLiteProvenanceColumns {
+			if strings.EqualFold(c.FieldName, pc.Column.FieldName) {
+				ErrMsg := fmt.Sprintf("the column %s of %s conflict with the provenance column",
+					c.FieldName, t.Name)
+				logs.Error(ErrMsg)
+				return nil, fmt.Errorf(ErrMsg)
+			}
+		}
+		t.Columns = append(t.Columns, SQLiteColumns{Column: c, Type: SQLiteType(c)})
+	}
+
+	var defs, names, marks []string
+	for _, sc := range append(append([]SQLiteColumns{}, t.Columns...), SQLiteProvenanceColumns...) {
+		defs = append(defs, QuoteSQLiteName(sc.Column.FieldName)+" "+sc.Type)
+		names = append(names, QuoteSQLiteName(sc.Column.FieldName))
+		marks = append(marks, "?")
+	}
+
+	queries := []string{fmt.Sprintf("CREATE TABLE %s (\n  %s\n)",
+		QuoteSQLiteName(t.Name), strings.Join(defs, ",\n  "))}
+
+	var keys []string
+	for _, idx := range row.Table.Indexes {
+		if idx.Name != "PRIMARY" {
+			continue
+		}
+		for _, f := range idx.Fields {
+			if HaveSQLiteColumn(t.Columns, f.ColumnName) {
+				keys = append(keys, QuoteSQLiteName(f.ColumnName))
+			}
+		}
+	}
+	if len(keys) != 0 {
+		queries = append(queries, fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+			QuoteSQLiteName(t.Name+".PRIMARY"), QuoteSQLiteName(t.Name), strings.Join(keys, ", ")))
+	}
+
+	for _, query := range queries {
+		if _, err := S.db.Exec(query); err != nil {
+			logs.Error("create the SQLite table failed, the error is ", err, " the statement is ", query)
+			return nil, err
+		}
+	}
+
+	var err error
+	t.stmt, err = S.db.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		QuoteSQLiteName(t.Name), strings.Join(names, ", "), strings.Join(marks, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	logs.Info("write the rows of ", row.DBName, ".", row.TableName, " to the SQLite table ", t.Name)
+	return t, nil
+}
+
+// Map the column to the SQLite column type, the type decide the affinity:
+// 1.The integer, YEAR and BIT are INTEGER, FLOAT and DOUBLE are REAL.
+// 2.The DECIMAL is TEXT to keep the exact digits.
+// 3.The date and time are TEXT like the SQLite date functions use.
+// 4.The string, ENUM, SET and JSON are TEXT, and the GEOMETRY is WKT.
+// 5.The binary string and BLOB are BLOB.
+func SQLiteType(c ibdata.Columns) string {
+	switch c.FieldType {
+	case utils.DATA_INT:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_DATE, utils.MYSQL_TYPE_ENUM, utils.MYSQL_TYPE_SET, utils.MYSQL_TYPE_STRING:
+			return "TEXT"
+		}
+		return "INTEGER"
+
+	case utils.DATA_FLOAT, utils.DATA_DOUBLE:
+		return "REAL"
+
+	case utils.DATA_FIXBINARY:
+		switch c.MySQLType {
+		case utils.MYSQL_TYPE_TIME, utils.MYSQL_TYPE_DATETIME, utils.MYSQL_TYPE_TIMESTAMP,
+			utils.MYSQL_TYPE_NEWDECIMAL:
+			return "TEXT"
+		case utils.MYSQL_TYPE_BIT:
+			return "INTEGER"
+		}
+		return "BLOB"
+
+	case utils.DATA_BINARY:
+		return "BLOB"
+
+	case utils.DATA_BLOB:
+		if c.IsBinary && c.MySQLType != utils.MYSQL_TYPE_JSON && c.MySQLType != utils.MYSQL_TYPE_GEOMETRY {
+			return "BLOB"
+		}
+	}
+	return "TEXT"
+}
+
+// Convert the column value to the value inserted to SQLite, the binary
+// value is the bytes, and the geometry is WKT.
+func SQLiteValue(c ibdata.Columns) (interface{}, error) {
+	if IsNull(c) {
+		return nil, nil
+	}
+
+	switch v := c.FieldValue.(type) {
+	case utils.Geometry:
+		return v.WKT(), nil
+
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, nil
+		}
+		return float64(v), nil
+
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, nil
+		}
+		return v, nil
+
+	case string:
+		if c.IsBinary {
+			// The parser made the binary value to the hex string.
+			return hex.DecodeString(v)
+		}
+		return v, nil
+	}
+
+	var n int64
+	v := reflect.ValueOf(c.FieldValue)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			WarnSQLiteValue(c, "it is stored as REAL")
+			return strconv.FormatUint(v.Uint(), 10), nil
+		}
+		n = int64(v.Uint())
+	default:
+		return TextValue(c), nil
+	}
+
+	// The year 0000 is stored as 0, the parser add 1900 to it.
+	if c.MySQLType == utils.MYSQL_TYPE_YEAR && n == 1900 {
+		n = 0
+	}
+	return n, nil
+}
+
+// The provenance is NULL when the row don't have it, such as the page and
+// the state of the redo rows.
+func SQLiteOptional(value interface{}) interface{} {
+	switch v := value.(type) {
+	case uint64:
+		if v != 0 {
+			return int64(v)
+		}
+	case string:
+		if v != "" {
+			return v
+		}
+	}
+	return nil
+}
+
+// Quote the SQLite identifier.
+func QuoteSQLiteName(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Whether the table have the column.
+func HaveSQLiteColumn(columns []SQLiteColumns, name string) bool {
+	for _, sc := range columns {
+		if sc.Column.FieldName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The columns which have been warned, the key is the table id and the column name.
+var WarnedSQLiteValues sync.Map
+
+// Warn once for every column which have the values SQLite can't store exactly.
+func WarnSQLiteValue(c ibdata.Columns, msg string) {
+	key := fmt.Sprintf("%d.%s", c.TableID, c.FieldName)
+	if _, warned := WarnedSQLiteValues.LoadOrStore(key, true); !warned {
+		logs.Warn("the column ", c.FieldName, " have the value ", TextValue(c),
+			" which SQLite can't store exactly, ", msg)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+)
+
+// Read the rows of the SQLite query, every row is the values joined by the "|".
+func ReadSQLiteTestRows(t *testing.T, db *sql.DB, query string) []string {
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("%s failed: %v", query, err)
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []string
+	for rows.Next() {
+		values := make([]interface{}, len(names))
+		pointers := make([]interface{}, len(names))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for _, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			s = append(s, fmt.Sprint(v))
+		}
+		all = append(all, strings.Join(s, "|"))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func TestSQLiteWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recovery.db")
+
+	// The table test.t2 (id BIGINT UNSIGNED PRIMARY KEY, b BLOB, c VARCHAR(10)).
+	id := ibdata.Columns{FieldName: "id", FieldType: utils.DATA_INT, FieldLen: 8, IsUnsigned: true}
+	b := ibdata.Columns{FieldName: "b", FieldType: utils.DATA_BLOB, IsBinary: true, IsNUll: true}
+	c := ibdata.Columns{FieldName: "c", FieldType: utils.DATA_VARMYSQL, IsNUll: true}
+	table := ibdata.Tables{DBName: "test", TableName: "t2", Columns: []ibdata.Columns{id, b, c},
+		Indexes: map[uint64]ibdata.Indexes{1: {Name: "PRIMARY", Fields: []*ibdata.Fields{{ColumnName: "id"}}}}}
+	Values := func(values ...interface{}) []ibdata.Columns {
+		var columns []ibdata.Columns
+		for i, c := range table.Columns {
+			c.FieldValue = values[i]
+			columns = append(columns, c)
+		}
+		return columns
+	}
+
+	rows := MakeSQLTestRows(1, 2)
+	rows = append(rows,
+		ibdata.Rows{Op: ibdata.RowInsert, Source: ibdata.RowSourceData, File: "t2.ibd", DBName: "test",
+			TableName: "t2", Table: table, Record: ibdata.Records{Columns: Values(uint64(math.MaxUint64),
+				"00FF", "NULL"), State: ibdata.RecordLive, PageNo: 4, Offset: 128, TrxId: 10}},
+		// The row of the redo file don't have the page, offset, state and trx id.
+		ibdata.Rows{Op: ibdata.RowInsert, Source: ibdata.RowSourceUndo, File: "ib_logfile0", DBName: "test",
+			TableName: "t2", Table: table, Record: ibdata.Records{Columns: Values(uint64(7), nil, "x")}},
+		MakeSQLTestRows(3)[0])
+
+	w, err := OpenSQLiteWriter(path, Options{TxnStatements: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Rows != 5 || len(w.Tables) != 2 {
+		t.Errorf("write %d rows of %d tables", w.Rows, len(w.Tables))
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, c := range []struct {
+		query    string
+		expected []string
+	}{
+		{`SELECT name, type FROM sqlite_master ORDER BY name`, []string{
+			"test.t1|table",
+			"test.t2|table",
+			"test.t2.PRIMARY|index",
+		}},
+		{`SELECT typeof(id), id, _op, _source, typeof(_page) FROM "test.t1" ORDER BY id`, []string{
+			"integer|1|insert|data|null",
+			"integer|2|insert|data|null",
+			"integer|3|insert|data|null",
+		}},
+		// The unsigned value above the MaxInt64 is REAL, the binary value is
+		// BLOB, and the string NULL is not the NULL.
+		{`SELECT typeof(id), id, typeof(b), hex(b), typeof(c), c,
+			_op, _source, _file, _page, _offset, _state, _trx_id FROM "test.t2" ORDER BY _source`, []string{
+			"real|1.8446744073709552e+19|blob|00FF|text|NULL|insert|data|t2.ibd|4|128|live|10",
+			"integer|7|null||text|x|insert|undo|ib_logfile0|<nil>|<nil>|<nil>|<nil>",
+		}},
+	} {
+		got := ReadSQLiteTestRows(t, db, c.query)
+		if fmt.Sprint(got) != fmt.Sprint(c.expected) {
+			t.Errorf("%s\nis %q\nexpected %q", c.query, got, c.expected)
+		}
+	}
+}
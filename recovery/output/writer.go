@@ -0,0 +1,202 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/zbdba/db-recovery/recovery/ibdata"
+	"github.com/zbdba/db-recovery/recovery/utils"
+	"github.com/zbdba/db-recovery/recovery/utils/logs"
+)
+
+// The output formats of the recovered rows.
+const (
+	// The sql statements, the read rows are written as replace into,
+	// insert into or insert ignore into statements.
+	FormatReplace      string = "replace"
+	FormatInsert       string = "insert"
+	FormatInsertIgnore string = "insert-ignore"
+
+	// The RFC 4180 CSV, the NULL is the empty field without quotes.
+	FormatCSV string = "csv"
+
+	// The tab separated values, the NULL is \N like the mysql client.
+	FormatTSV string = "tsv"
+
+	// One JSON object per line, with the row state and where it come from.
+	FormatJSONL string = "jsonl"
+
+	// The LOAD DATA INFILE data file, and the sql file with the LOAD DATA
+	// statement, it is only written to the output directory.
+	FormatLoadData string = "load-data"
+
+	// The MySQL row based binlog, it can be replayed by mysqlbinlog | mysql.
+	FormatBinlog string = "binlog"
+
+	// The Parquet file of one table, it is compressed by snappy.
+	FormatParquet string = "parquet"
+
+	// The PostgreSQL COPY FROM STDIN blocks which psql can run, the
+	// update and delete rows are the statements between the blocks.
+	FormatCopy string = "copy"
+)
+
+// All output formats, the first one is the default.
+var Formats = []string{FormatReplace, FormatInsert, FormatInsertIgnore, FormatCSV, FormatTSV, FormatJSONL,
+	FormatLoadData, FormatBinlog, FormatParquet, FormatCopy}
+
+// The sql dialects of the statements.
+const (
+	DialectMySQL string = "mysql"
+
+	// The identifiers are quoted by the double quotes, the tables are
+	// created by the DDL mapped from the table struct.
+	DialectPostgres string = "postgres"
+)
+
+// All sql dialects, the first one is the default.
+var Dialects = []string{DialectMySQL, DialectPostgres}
+
+// The options of the row writers.
+type Options struct {
+	Format  string
+	Dialect string
+
+	// Write many rows in one insert statement, the statement size is
+	// limited like the max_allowed_packet.
+	BatchRows         int
+	MaxStatementBytes int
+
+	// Write BEGIN and COMMIT around every N statements, 0 means no transaction.
+	TxnStatements int
+
+	// Write SET unique_checks=0, foreign_key_checks=0 before the statements.
+	DisableChecks bool
+
+	// The bytes of the Parquet row group which is buffered in memory.
+	RowGroupSize int64
+}
+
+// Create the row writer of the format, the rows are written to w.
+func NewRowWriter(w io.Writer, opts Options) (ibdata.RowWriter, error) {
+	switch opts.Dialect {
+	case DialectMySQL, "":
+	case DialectPostgres:
+		// The MySQL specific formats.
+		if opts.Format == FormatLoadData || opts.Format == FormatBinlog {
+			ErrMsg := fmt.Sprintf("the %s format can't use the %s dialect", opts.Format, opts.Dialect)
+			logs.Error(ErrMsg)
+			return nil, fmt.Errorf(ErrMsg)
+		}
+	default:
+		ErrMsg := fmt.Sprintf("unknown sql dialect %s, the dialect can be %v", opts.Dialect, Dialects)
+		logs.Error(ErrMsg)
+		return nil, fmt.Errorf(ErrMsg)
+	}
+
+	if verb, ok := SQLVerb(opts.Format); ok {
+		return NewSQLWriter(w, verb, opts), nil
+	}
+
+	switch opts.Format {
+	case FormatCSV:
+		return NewCSVWriter(w), nil
+	case FormatTSV:
+		return NewTSVWriter(w), nil
+	case FormatJSONL:
+		return NewJSONWriter(w), nil
+	case FormatLoadData:
+		return NewLoadDataWriter(w), nil
+	case FormatBinlog:
+		return NewBinlogWriter(w, opts), nil
+	case FormatParquet:
+		return NewParquetWriter(w, opts), nil
+	case FormatCopy:
+		// The COPY is only in PostgreSQL.
+		opts.Dialect = DialectPostgres
+		return NewSQLWriter(w, "insert", opts), nil
+	}
+
+	ErrMsg := fmt.Sprintf("unknown output format %s, the format can be %v", opts.Format, Formats)
+	logs.Error(ErrMsg)
+	return nil, fmt.Errorf(ErrMsg)
+}
+
+// Get the statement verb of the sql formats, false for the other formats.
+func SQLVerb(format string) (string, bool) {
+	switch format {
+	case FormatReplace, "":
+		return "replace", true
+	case FormatInsert:
+		return "insert", true
+	case FormatInsertIgnore:
+		return "insert ignore", true
+	}
+	return "", false
+}
+
+// Whether the column value is NULL, the parser use the nil, the string
+// "NULL" is the value of the column.
+func IsNull(column ibdata.Columns) bool {
+	return column.FieldValue == nil
+}
+
+// Format the column value to text, the binary value is the hex string
+// made by the parser, the geometry value is WKT.
+func TextValue(column ibdata.Columns) string {
+	switch v := column.FieldValue.(type) {
+	case string:
+		return v
+	case utils.Geometry:
+		return v.WKT()
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%v", column.FieldValue)
+}
+
+// Get the columns of the row which are written to the flat formats, the
+// update row have the keys and the changed columns, the delete row only
+// have the keys.
+func RowColumns(row ibdata.Rows) []ibdata.Columns {
+	switch row.Op {
+	case ibdata.RowDelete:
+		return row.Keys
+	case ibdata.RowUpdate:
+		columns := append([]ibdata.Columns{}, row.Keys...)
+		for _, c := range ibdata.OutputColumns(row.Record.Columns) {
+			if !HaveColumn(columns, c.FieldName) {
+				columns = append(columns, c)
+			}
+		}
+		return columns
+	}
+	return ibdata.OutputColumns(row.Record.Columns)
+}
+
+// Whether the columns have the column name.
+func HaveColumn(columns []ibdata.Columns, name string) bool {
+	for _, c := range columns {
+		if c.FieldName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -100,6 +100,12 @@ type ParseRedo struct {
 
 	// The database name which you want to recovery.
 	DBName string
+
+	// Write the recovered rows in the output format.
+	Writer ibdata.RowWriter
+
+	// The redo log files which are parsed, they are written with the rows.
+	File string
 }
 
 // Parse the redo log file
@@ -111,6 +117,8 @@ type ParseRedo struct {
 // And every parts is 512 bytes, there will be many
 // redo blocks which store the redo record.
 func (P *ParseRedo) Parse(LogFileList []string) error {
+	P.File = strings.Join(LogFileList, ",")
+
 	var data []byte
 	for _, LogFile := range LogFileList {
 		file, err := os.Open(LogFile)
@@ -524,7 +532,7 @@ func (P *ParseRedo) ReadCheckpoint(file *os.File) error {
 	return nil
 }
 
-func NewParseRedo(IbFilePath string, TableName string, DBName string, ) (*ParseRedo, error) {
+func NewParseRedo(IbFilePath string, TableName string, DBName string, StructFile string) (*ParseRedo, error) {
 	p := &ParseRedo{TableName:TableName, DBName:DBName}
 
 	// get data dict
@@ -533,6 +541,14 @@ func NewParseRedo(IbFilePath string, TableName string, DBName string, ) (*ParseR
 	if ParseDictErr != nil {
 		return nil, ParseDictErr
 	}
+
+	// get the info which data dict don't have from the table struct.
+	if StructFile != "" {
+		StructErr := I.GetTableFieldsFromStruct(StructFile, DBName)
+		if StructErr != nil {
+			return nil, StructErr
+		}
+	}
 	p.TableMap = I.TableMap
 
 	return p, nil
@@ -634,42 +650,45 @@ func (P *ParseRedo) GetTableBySpaceID(SpaceID uint64) (ibdata.Tables, error) {
 	return ibdata.Tables{}, fmt.Errorf("can't find table")
 }
 
-func (P *ParseRedo) MakeSQL(table ibdata.Tables, PrimaryColumns []*ibdata.Fields, columns []*ibdata.Columns) {
-
-	// TODO: deal with null value.
-
-	// update statement
-	var SetValues string
-	for i, c := range columns {
-		var SetValue string
-		if c.FieldValue != nil {
-			SetValue = fmt.Sprintf("`%s`='%v'", c.FieldName, c.FieldValue)
-		} else {
-			SetValue = fmt.Sprintf("`%s`=NULL", c.FieldName)
-		}
-		if i == (len(columns) - 1) {
-			SetValues += SetValue
-		} else {
-			SetValues += SetValue + " and "
-		}
+// Whether the rows of the table are written, write all rows when user
+// don't identify the table name and the database name.
+func (P *ParseRedo) IsOutputTable(table ibdata.Tables) bool {
+	if table.DBName == P.DBName {
+		return table.TableName == P.TableName || P.TableName == ""
 	}
+	if P.DBName == "" && P.TableName == "" {
+		return true
+	}
+	return P.TableName != "" && P.TableName == table.TableName
+}
 
-	var WhereConditions string
+// Write the undo record to the Writer, the primary key columns identify
+// the row. The update have the old values in the columns, and the insert
+// is undone by the delete which only have the keys.
+func (P *ParseRedo) WriteUndoRow(op string, table ibdata.Tables, keys []ibdata.Columns, columns []*ibdata.Columns) {
 
-	for j, v := range PrimaryColumns {
-		WhereCondition := fmt.Sprintf("`%s`='%v'", v.ColumnName, v.ColumnValue)
-		if j == (len(PrimaryColumns) - 1) {
-			WhereConditions += WhereCondition
-		} else {
-			WhereConditions += WhereCondition + " and "
-		}
+	var values []ibdata.Columns
+	for _, c := range columns {
+		values = append(values, *c)
 	}
 
-	Query := fmt.Sprintf("update `%s`.`%s` set %s where %s;", table.DBName,
-		table.TableName, SetValues, WhereConditions)
-
-	logs.Debug("query is ", Query)
-	fmt.Println( Query)
+	row := ibdata.Rows{
+		Op:        op,
+		Source:    ibdata.RowSourceUndo,
+		File:      P.File,
+		DBName:    table.DBName,
+		TableName: table.TableName,
+		Table:     table,
+		Record:    ibdata.Records{Columns: values},
+		Keys:      keys}
+
+	if P.Writer == nil {
+		logs.Error("the row writer is not set, skip the ", op, " of table ", table.TableName)
+		return
+	}
+	if err := P.Writer.WriteRow(row); err != nil {
+		logs.Error("write the ", op, " row failed, the error is ", err)
+	}
 }
 
 // Parse the undo record.
@@ -738,6 +757,7 @@ func (P *ParseRedo) MLOG_UNDO_INSERT (data []byte, pos *uint64) error {
 		return err
 	}
 
+	var KeyColumns []ibdata.Columns
 	for _, v := range PrimaryFields {
 		Column := P.GetColumnsByName(Table, v.ColumnName)
 		// get the unique key.
@@ -757,7 +777,10 @@ func (P *ParseRedo) MLOG_UNDO_INSERT (data []byte, pos *uint64) error {
 		}
 
 		logs.Debug("the table is ", Table.TableName, " table id is ", TableId, " unique value is ")
-		v.ColumnValue = value
+		value = ibdata.FormatDecimal(&Column, data[*pos:*pos+FiledLen], value)
+		v.ColumnValue = ibdata.FormatElements(Column, value)
+		Column.FieldValue = v.ColumnValue
+		KeyColumns = append(KeyColumns, Column)
 
 		*pos += FiledLen
 	}
@@ -804,32 +827,28 @@ func (P *ParseRedo) MLOG_UNDO_INSERT (data []byte, pos *uint64) error {
 				if err != nil {
 					return err
 				}
-
+				value = ibdata.FormatDecimal(c, data[*pos:*pos+Flen], value)
 			}
 
-			c.FieldValue = value
+			c.FieldValue = ibdata.FormatElements(*c, value)
 			columns = append(columns, c)
 			*pos += Flen
 
 			logs.Debug("values is ", value)
 		}
 
-		// Make data to sql statement, only for update statement.
-		// Print sql statement when user identify the table name or the database name,
-		// if user don't identify table and database name, print all sql statement.
-		if Table.DBName == P.DBName {
-			if Table.TableName == P.TableName {
-				P.MakeSQL(Table, PrimaryFields, columns)
-			} else if P.TableName == "" {
-				P.MakeSQL(Table, PrimaryFields, columns)
-			}
-		} else if P.DBName == "" && P.TableName == "" {
-			P.MakeSQL(Table, PrimaryFields, columns)
-		} else if P.TableName != "" && P.TableName == Table.TableName {
-			P.MakeSQL(Table, PrimaryFields, columns)
+		// Write the update row, only for update statement.
+		if P.IsOutputTable(Table) {
+			P.WriteUndoRow(ibdata.RowUpdate, Table, KeyColumns, columns)
 		}
 	}
 
+	// The insert undo record only have the primary key, undo the insert by
+	// deleting the row.
+	if UndoType == TRX_UNDO_INSERT_REC && P.IsOutputTable(Table) {
+		P.WriteUndoRow(ibdata.RowDelete, Table, KeyColumns, nil)
+	}
+
 	// TODO: confirm
 	//if (UndoType != TRX_UNDO_UPD_EXIST_REC) || ((CmplInfo&1) == 0) {
 	//	// get delete mark record.
@@ -0,0 +1,86 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"hash/crc32"
+)
+
+// The magic checksum when innodb_checksum_algorithm is none.
+// #define BUF_NO_CHECKSUM_MAGIC 0xDEADBEEFUL
+const BufNoChecksumMagic uint32 = 0xDEADBEEF
+
+// The fold hash random mask.
+// Reference mysql-5.7.19/storage/innobase/include/ut0rnd.h
+const (
+	UtHashRandomMask  uint64 = 1463735687
+	UtHashRandomMask2 uint64 = 1653893711
+)
+
+var Crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Reference mysql-5.7.19/storage/innobase/buf/buf0checksum.cc buf_calc_page_crc32
+func PageChecksumCrc32(page []byte) uint32 {
+	// FIL_PAGE_OFFSET ~ FIL_PAGE_FILE_FLUSH_LSN and FIL_PAGE_DATA ~ FIL_PAGE_END_LSN_OLD_CHKSUM
+	c1 := crc32.Checksum(page[4:26], Crc32cTable)
+	c2 := crc32.Checksum(page[38:len(page)-8], Crc32cTable)
+	return c1 ^ c2
+}
+
+// Reference mysql-5.7.19/storage/innobase/buf/buf0checksum.cc buf_calc_page_new_checksum
+func PageChecksumInnodb(page []byte) uint32 {
+	checksum := UtFoldBinary(page[4:26]) + UtFoldBinary(page[38:len(page)-8])
+	return uint32(checksum & 0xFFFFFFFF)
+}
+
+// Reference mysql-5.7.19/storage/innobase/include/ut0rnd.ic ut_fold_binary
+func UtFoldBinary(str []byte) uint64 {
+	var fold uint64
+	for _, b := range str {
+		fold = UtFoldUlintPair(fold, uint64(b))
+	}
+	return fold
+}
+
+// Reference mysql-5.7.19/storage/innobase/include/ut0rnd.ic ut_fold_ulint_pair
+func UtFoldUlintPair(n1 uint64, n2 uint64) uint64 {
+	return ((((n1 ^ n2 ^ UtHashRandomMask2) << 8) + n1) ^ UtHashRandomMask) + n2
+}
+
+// Whether the page is not torn and not corrupted. The low 4 bytes of the
+// FIL_PAGE_LSN is stored again at the page end, and the checksum may be
+// crc32, innodb or none.
+// Reference mysql-5.7.19/storage/innobase/buf/buf0buf.cc buf_page_is_corrupted
+func PageIsValid(page []byte) bool {
+	if len(page) < 38+8 {
+		return false
+	}
+
+	// The all zero page have not been initialized.
+	if bytes.Count(page, []byte{0}) == len(page) {
+		return false
+	}
+
+	// FIL_PAGE_LSN + 4 and FIL_PAGE_END_LSN_OLD_CHKSUM + 4
+	if MatchReadFrom4(page[20:]) != MatchReadFrom4(page[len(page)-4:]) {
+		return false
+	}
+
+	checksum := uint32(MatchReadFrom4(page))
+	return checksum == BufNoChecksumMagic ||
+		checksum == PageChecksumCrc32(page) ||
+		checksum == PageChecksumInnodb(page)
+}
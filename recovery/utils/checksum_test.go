@@ -0,0 +1,76 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Make the 16k page which every byte is i*7%251, the FIL_PAGE_LSN is
+// 0x1122334455667788, and its low 4 bytes are at the page end.
+func MakeChecksumTestPage() []byte {
+	d := make([]byte, 16384)
+	for i := range d {
+		d[i] = byte(i * 7 % 251)
+	}
+	binary.BigEndian.PutUint64(d[16:], 0x1122334455667788)
+	binary.BigEndian.PutUint32(d[len(d)-4:], 0x55667788)
+	return d
+}
+
+// The checksums are computed by buf_calc_page_crc32 and buf_calc_page_new_checksum.
+func TestPageChecksum(t *testing.T) {
+	d := MakeChecksumTestPage()
+	if c := PageChecksumCrc32(d); c != 0x1006722E {
+		t.Errorf("the crc32 checksum is %X, expected 1006722E", c)
+	}
+	if c := PageChecksumInnodb(d); c != 0x5BB070AE {
+		t.Errorf("the innodb checksum is %X, expected 5BB070AE", c)
+	}
+}
+
+func TestPageIsValid(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		checksum uint32
+		modify   func(d []byte)
+		valid    bool
+	}{
+		{"crc32", 0x1006722E, nil, true},
+		{"innodb", 0x5BB070AE, nil, true},
+		{"none", BufNoChecksumMagic, nil, true},
+		{"wrong checksum", 0x12345678, nil, false},
+		// The checksum don't cover the FIL_PAGE_FILE_FLUSH_LSN and the space id.
+		{"flush lsn", 0x1006722E, func(d []byte) { d[30] ^= 0xFF }, true},
+		{"corrupted", 0x1006722E, func(d []byte) { d[1000] ^= 0xFF }, false},
+		// The page is torn, the page end is written by the older flush.
+		{"torn", 0x1006722E, func(d []byte) { binary.BigEndian.PutUint32(d[len(d)-4:], 0x55667787) }, false},
+		{"zero", 0, func(d []byte) {
+			for i := range d {
+				d[i] = 0
+			}
+		}, false},
+	} {
+		d := MakeChecksumTestPage()
+		binary.BigEndian.PutUint32(d, c.checksum)
+		if c.modify != nil {
+			c.modify(d)
+		}
+		if PageIsValid(d) != c.valid {
+			t.Errorf("the %s page is valid %v, expected %v", c.name, !c.valid, c.valid)
+		}
+	}
+}
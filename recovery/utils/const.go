@@ -67,6 +67,20 @@ const (
 	DATA_MTYPE_MAX
 )
 
+// The geometry main type, added by MySQL 5.7.
+// Reference mysql-5.7.19/storage/innobase/include/data0type.h
+const (
+	// geometry data type, the SRID and WKB stored as a blob
+	DATA_GEOMETRY uint64 = 14
+
+	// geometry datatype of fixed length POINT, 25 bytes
+	DATA_POINT uint64 = 15
+
+	// geometry datatype of variable length POINT, used when we want to
+	// store POINT as BLOB internally
+	DATA_VAR_POINT uint64 = 16
+)
+
 // The MySQL Server type
 const (
 	MYSQL_TYPE_DECIMAL uint64 = iota
@@ -89,6 +103,7 @@ const (
 	MYSQL_TYPE_TIMESTAMP2
 	MYSQL_TYPE_DATETIME2
 	MYSQL_TYPE_TIME2
+	MYSQL_TYPE_JSON        = 245
 	MYSQL_TYPE_NEWDECIMAL  = 246
 	MYSQL_TYPE_ENUM        = 247
 	MYSQL_TYPE_SET         = 248
@@ -0,0 +1,422 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// The OGC WKB geometry type.
+// Reference mysql-5.7.19/sql/spatial.h
+const (
+	WKB_POINT              uint32 = 1
+	WKB_LINESTRING         uint32 = 2
+	WKB_POLYGON            uint32 = 3
+	WKB_MULTIPOINT         uint32 = 4
+	WKB_MULTILINESTRING    uint32 = 5
+	WKB_MULTIPOLYGON       uint32 = 6
+	WKB_GEOMETRYCOLLECTION uint32 = 7
+)
+
+// The WKB byte order.
+const (
+	WKB_XDR byte = 0
+	WKB_NDR byte = 1
+)
+
+// Store the geometry value parsed from the GEOMETRY column.
+// Only one of Point/Points/Rings/Geometries is used, according to the Type.
+type Geometry struct {
+	SRID       uint32
+	Type       uint32
+	Point      [2]float64
+	Points     [][2]float64
+	Rings      [][][2]float64
+	Geometries []Geometry
+}
+
+// MySQL store the geometry value as a 4 bytes little endian SRID
+// followed by the WKB data.
+func ParseGeometry(data []byte) (Geometry, error) {
+	if len(data) < 4 {
+		return Geometry{}, fmt.Errorf("geometry data is too short")
+	}
+	SRID := binary.LittleEndian.Uint32(data)
+
+	g, n, err := ParseWKB(data[4:])
+	if err != nil {
+		return Geometry{}, err
+	}
+	if n != len(data)-4 {
+		return Geometry{}, fmt.Errorf("geometry data have %d bytes left", len(data)-4-n)
+	}
+	g.SRID = SRID
+	return g, nil
+}
+
+// Parse one WKB geometry, return the geometry and the bytes it used.
+// WKB ::= byte-order uint32-type data
+func ParseWKB(data []byte) (Geometry, int, error) {
+	var g Geometry
+	if len(data) < 5 {
+		return g, 0, fmt.Errorf("wkb data is too short")
+	}
+
+	var order binary.ByteOrder
+	switch data[0] {
+	case WKB_NDR:
+		order = binary.LittleEndian
+	case WKB_XDR:
+		order = binary.BigEndian
+	default:
+		return g, 0, fmt.Errorf("unknown wkb byte order %d", data[0])
+	}
+	g.Type = order.Uint32(data[1:])
+	pos := 5
+
+	ReadUint32 := func() (uint32, error) {
+		if len(data) < pos+4 {
+			return 0, fmt.Errorf("wkb data is too short")
+		}
+		v := order.Uint32(data[pos:])
+		pos += 4
+		return v, nil
+	}
+
+	ReadPoint := func() ([2]float64, error) {
+		var p [2]float64
+		if len(data) < pos+16 {
+			return p, fmt.Errorf("wkb data is too short")
+		}
+		p[0] = math.Float64frombits(order.Uint64(data[pos:]))
+		p[1] = math.Float64frombits(order.Uint64(data[pos+8:]))
+		pos += 16
+		return p, nil
+	}
+
+	ReadPoints := func() ([][2]float64, error) {
+		n, err := ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(n)*16 > uint64(len(data)-pos) {
+			return nil, fmt.Errorf("wkb point num %d is too large", n)
+		}
+		points := make([][2]float64, 0, n)
+		for i := uint32(0); i < n; i++ {
+			p, err := ReadPoint()
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, p)
+		}
+		return points, nil
+	}
+
+	switch g.Type {
+	case WKB_POINT:
+		p, err := ReadPoint()
+		if err != nil {
+			return g, 0, err
+		}
+		g.Point = p
+
+	case WKB_LINESTRING:
+		points, err := ReadPoints()
+		if err != nil {
+			return g, 0, err
+		}
+		g.Points = points
+
+	case WKB_POLYGON:
+		n, err := ReadUint32()
+		if err != nil {
+			return g, 0, err
+		}
+		for i := uint32(0); i < n; i++ {
+			ring, err := ReadPoints()
+			if err != nil {
+				return g, 0, err
+			}
+			g.Rings = append(g.Rings, ring)
+		}
+
+	case WKB_MULTIPOINT, WKB_MULTILINESTRING, WKB_MULTIPOLYGON, WKB_GEOMETRYCOLLECTION:
+		// Every element of the collection is a complete WKB geometry.
+		n, err := ReadUint32()
+		if err != nil {
+			return g, 0, err
+		}
+		for i := uint32(0); i < n; i++ {
+			sub, used, err := ParseWKB(data[pos:])
+			if err != nil {
+				return g, 0, err
+			}
+			if !WKBElementTypeValid(g.Type, sub.Type) {
+				return g, 0, fmt.Errorf("wkb type %d can't contain type %d", g.Type, sub.Type)
+			}
+			g.Geometries = append(g.Geometries, sub)
+			pos += used
+		}
+
+	default:
+		return g, 0, fmt.Errorf("unknown wkb geometry type %d", g.Type)
+	}
+	return g, pos, nil
+}
+
+func WKBElementTypeValid(CollectionType uint32, ElementType uint32) bool {
+	switch CollectionType {
+	case WKB_MULTIPOINT:
+		return ElementType == WKB_POINT
+	case WKB_MULTILINESTRING:
+		return ElementType == WKB_LINESTRING
+	case WKB_MULTIPOLYGON:
+		return ElementType == WKB_POLYGON
+	}
+	return true
+}
+
+// Make the geometry to the MySQL storage format, the 4 bytes little endian
+// SRID followed by the little endian WKB data.
+func (g Geometry) Bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, g.SRID)
+	g.WriteWKB(&buf)
+	return buf.Bytes()
+}
+
+// Write the little endian WKB data of the geometry.
+func (g Geometry) WriteWKB(buf *bytes.Buffer) {
+	buf.WriteByte(WKB_NDR)
+	binary.Write(buf, binary.LittleEndian, g.Type)
+
+	switch g.Type {
+	case WKB_POINT:
+		binary.Write(buf, binary.LittleEndian, g.Point)
+	case WKB_LINESTRING:
+		binary.Write(buf, binary.LittleEndian, uint32(len(g.Points)))
+		binary.Write(buf, binary.LittleEndian, g.Points)
+	case WKB_POLYGON:
+		binary.Write(buf, binary.LittleEndian, uint32(len(g.Rings)))
+		for _, ring := range g.Rings {
+			binary.Write(buf, binary.LittleEndian, uint32(len(ring)))
+			binary.Write(buf, binary.LittleEndian, ring)
+		}
+	default:
+		binary.Write(buf, binary.LittleEndian, uint32(len(g.Geometries)))
+		for _, sub := range g.Geometries {
+			sub.WriteWKB(buf)
+		}
+	}
+}
+
+// Make the geometry to MySQL expression, use it in sql statement. It is the
+// hex of the storage format like mysqldump --hex-blob, the same in MySQL 5.7
+// and 8.0. The ST_GeomFromText and ST_GeomFromWKB of MySQL 8.0 read the
+// geographic SRS like 4326 in the latitude-longitude order, but the stored
+// point is longitude-latitude, and 5.7 don't have the axis-order option.
+func (g Geometry) SQL() string {
+	return fmt.Sprintf("0x%X", g.Bytes())
+}
+
+// Print the geometry as WKT, the same as MySQL ST_AsText.
+func (g Geometry) String() string {
+	return g.WKT()
+}
+
+func (g Geometry) WKT() string {
+	var buf bytes.Buffer
+	g.WriteWKT(&buf)
+	return buf.String()
+}
+
+func (g Geometry) WriteWKT(buf *bytes.Buffer) {
+	switch g.Type {
+	case WKB_POINT:
+		buf.WriteString("POINT(")
+		WriteWKTPoint(buf, g.Point)
+		buf.WriteString(")")
+	case WKB_LINESTRING:
+		buf.WriteString("LINESTRING")
+		WriteWKTPoints(buf, g.Points)
+	case WKB_POLYGON:
+		buf.WriteString("POLYGON")
+		WriteWKTRings(buf, g.Rings)
+	case WKB_MULTIPOINT:
+		buf.WriteString("MULTIPOINT(")
+		for i, p := range g.Geometries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('(')
+			WriteWKTPoint(buf, p.Point)
+			buf.WriteByte(')')
+		}
+		buf.WriteString(")")
+	case WKB_MULTILINESTRING:
+		buf.WriteString("MULTILINESTRING(")
+		for i, l := range g.Geometries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			WriteWKTPoints(buf, l.Points)
+		}
+		buf.WriteString(")")
+	case WKB_MULTIPOLYGON:
+		buf.WriteString("MULTIPOLYGON(")
+		for i, p := range g.Geometries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			WriteWKTRings(buf, p.Rings)
+		}
+		buf.WriteString(")")
+	case WKB_GEOMETRYCOLLECTION:
+		buf.WriteString("GEOMETRYCOLLECTION(")
+		for i, sub := range g.Geometries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			sub.WriteWKT(buf)
+		}
+		buf.WriteString(")")
+	}
+}
+
+func WriteWKTPoint(buf *bytes.Buffer, p [2]float64) {
+	buf.WriteString(FormatCoordinate(p[0]))
+	buf.WriteByte(' ')
+	buf.WriteString(FormatCoordinate(p[1]))
+}
+
+func WriteWKTPoints(buf *bytes.Buffer, points [][2]float64) {
+	buf.WriteByte('(')
+	for i, p := range points {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		WriteWKTPoint(buf, p)
+	}
+	buf.WriteByte(')')
+}
+
+func WriteWKTRings(buf *bytes.Buffer, rings [][][2]float64) {
+	buf.WriteByte('(')
+	for i, ring := range rings {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		WriteWKTPoints(buf, ring)
+	}
+	buf.WriteByte(')')
+}
+
+// Print the geometry as GeoJSON, the same as MySQL ST_AsGeoJSON.
+func (g Geometry) GeoJSON() string {
+	var buf bytes.Buffer
+	g.WriteGeoJSON(&buf)
+	return buf.String()
+}
+
+func (g Geometry) WriteGeoJSON(buf *bytes.Buffer) {
+	switch g.Type {
+	case WKB_POINT:
+		buf.WriteString(`{"type": "Point", "coordinates": `)
+		WriteGeoJSONPoint(buf, g.Point)
+	case WKB_LINESTRING:
+		buf.WriteString(`{"type": "LineString", "coordinates": `)
+		WriteGeoJSONPoints(buf, g.Points)
+	case WKB_POLYGON:
+		buf.WriteString(`{"type": "Polygon", "coordinates": `)
+		WriteGeoJSONRings(buf, g.Rings)
+	case WKB_MULTIPOINT:
+		buf.WriteString(`{"type": "MultiPoint", "coordinates": [`)
+		for i, p := range g.Geometries {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			WriteGeoJSONPoint(buf, p.Point)
+		}
+		buf.WriteByte(']')
+	case WKB_MULTILINESTRING:
+		buf.WriteString(`{"type": "MultiLineString", "coordinates": [`)
+		for i, l := range g.Geometries {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			WriteGeoJSONPoints(buf, l.Points)
+		}
+		buf.WriteByte(']')
+	case WKB_MULTIPOLYGON:
+		buf.WriteString(`{"type": "MultiPolygon", "coordinates": [`)
+		for i, p := range g.Geometries {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			WriteGeoJSONRings(buf, p.Rings)
+		}
+		buf.WriteByte(']')
+	case WKB_GEOMETRYCOLLECTION:
+		buf.WriteString(`{"type": "GeometryCollection", "geometries": [`)
+		for i, sub := range g.Geometries {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			sub.WriteGeoJSON(buf)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteByte('}')
+}
+
+func WriteGeoJSONPoint(buf *bytes.Buffer, p [2]float64) {
+	buf.WriteByte('[')
+	buf.WriteString(FormatCoordinate(p[0]))
+	buf.WriteString(", ")
+	buf.WriteString(FormatCoordinate(p[1]))
+	buf.WriteByte(']')
+}
+
+func WriteGeoJSONPoints(buf *bytes.Buffer, points [][2]float64) {
+	buf.WriteByte('[')
+	for i, p := range points {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		WriteGeoJSONPoint(buf, p)
+	}
+	buf.WriteByte(']')
+}
+
+func WriteGeoJSONRings(buf *bytes.Buffer, rings [][][2]float64) {
+	buf.WriteByte('[')
+	for i, ring := range rings {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		WriteGeoJSONPoints(buf, ring)
+	}
+	buf.WriteByte(']')
+}
+
+// Print the coordinate without exponent, the shortest one which can be read back.
+func FormatCoordinate(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestGeometrySQL(t *testing.T) {
+	// SELECT HEX(ST_GeomFromText('POINT(116.4 39.9)', 4326, 'axis-order=long-lat'))
+	stored, _ := hex.DecodeString("E610000001010000009A99999999195D403333333333F34340")
+
+	g, err := ParseGeometry(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.SRID != 4326 || g.WKT() != "POINT(116.4 39.9)" {
+		t.Errorf("the geometry is %d %s", g.SRID, g.WKT())
+	}
+
+	// The stored longitude-latitude order is kept.
+	if !bytes.Equal(g.Bytes(), stored) {
+		t.Errorf("the bytes are %X", g.Bytes())
+	}
+	if sql := g.SQL(); sql != "0xE610000001010000009A99999999195D403333333333F34340" {
+		t.Errorf("the sql is %s", sql)
+	}
+}
@@ -0,0 +1,548 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// The MySQL binary JSON value type.
+// Reference mysql-5.7.19/sql/json_binary.h
+const (
+	JSONB_TYPE_SMALL_OBJECT uint64 = 0x0
+	JSONB_TYPE_LARGE_OBJECT uint64 = 0x1
+	JSONB_TYPE_SMALL_ARRAY  uint64 = 0x2
+	JSONB_TYPE_LARGE_ARRAY  uint64 = 0x3
+	JSONB_TYPE_LITERAL      uint64 = 0x4
+	JSONB_TYPE_INT16        uint64 = 0x5
+	JSONB_TYPE_UINT16       uint64 = 0x6
+	JSONB_TYPE_INT32        uint64 = 0x7
+	JSONB_TYPE_UINT32       uint64 = 0x8
+	JSONB_TYPE_INT64        uint64 = 0x9
+	JSONB_TYPE_UINT64       uint64 = 0xA
+	JSONB_TYPE_DOUBLE       uint64 = 0xB
+	JSONB_TYPE_STRING       uint64 = 0xC
+	JSONB_TYPE_OPAQUE       uint64 = 0xF
+)
+
+// The MySQL binary JSON literal value.
+const (
+	JSONB_NULL_LITERAL  uint64 = 0x0
+	JSONB_TRUE_LITERAL  uint64 = 0x1
+	JSONB_FALSE_LITERAL uint64 = 0x2
+)
+
+// Parse the MySQL binary JSON value which stored in the JSON column,
+// and return the JSON text in the same format as MySQL print it.
+// The binary format is all little endian, the first byte is the value type:
+//
+//	doc ::= type value
+//	value ::= object | array | literal | number | string | opaque
+//
+// Reference mysql-5.7.19/sql/json_binary.cc
+func ParseJSON(data []byte) (string, error) {
+	// The empty value is stored for the JSON column which added
+	// by alter table on a not empty table.
+	if len(data) == 0 {
+		return "null", nil
+	}
+
+	var buf bytes.Buffer
+	err := ParseJSONValue(&buf, MatchReadFrom1(data), data[1:])
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func ParseJSONValue(buf *bytes.Buffer, JSONType uint64, data []byte) error {
+	switch JSONType {
+	case JSONB_TYPE_SMALL_OBJECT:
+		return ParseJSONObjectOrArray(buf, data, false, true)
+	case JSONB_TYPE_LARGE_OBJECT:
+		return ParseJSONObjectOrArray(buf, data, true, true)
+	case JSONB_TYPE_SMALL_ARRAY:
+		return ParseJSONObjectOrArray(buf, data, false, false)
+	case JSONB_TYPE_LARGE_ARRAY:
+		return ParseJSONObjectOrArray(buf, data, true, false)
+	case JSONB_TYPE_LITERAL:
+		if len(data) < 1 {
+			return fmt.Errorf("json literal data is too short")
+		}
+		return ParseJSONLiteral(buf, MatchReadFrom1(data))
+	case JSONB_TYPE_INT16, JSONB_TYPE_UINT16, JSONB_TYPE_INT32, JSONB_TYPE_UINT32,
+		JSONB_TYPE_INT64, JSONB_TYPE_UINT64, JSONB_TYPE_DOUBLE:
+		return ParseJSONNumber(buf, JSONType, data)
+	case JSONB_TYPE_STRING:
+		length, n, err := ParseJSONVariableLength(data)
+		if err != nil {
+			return err
+		}
+		if uint64(len(data)) < n+length {
+			return fmt.Errorf("json string data is too short")
+		}
+		WriteJSONString(buf, data[n:n+length])
+		return nil
+	case JSONB_TYPE_OPAQUE:
+		return ParseJSONOpaque(buf, data)
+	}
+	return fmt.Errorf("unknown json value type %d", JSONType)
+}
+
+// The object and array have the same header:
+//
+//	element-count  uint16 or uint32
+//	size           uint16 or uint32, the size of the whole value
+//	key-entry      only object have it, key-offset and uint16 key-length
+//	value-entry    type and offset, or the inlined value
+//
+// The offset is count from the start of the value.
+func ParseJSONObjectOrArray(buf *bytes.Buffer, data []byte, IsLarge bool, IsObject bool) error {
+	OffsetSize := uint64(2)
+	if IsLarge {
+		OffsetSize = 4
+	}
+
+	if uint64(len(data)) < 2*OffsetSize {
+		return fmt.Errorf("json object or array data is too short")
+	}
+
+	count := ReadJSONOffset(data, OffsetSize)
+	size := ReadJSONOffset(data[OffsetSize:], OffsetSize)
+	if size > uint64(len(data)) {
+		return fmt.Errorf("json object or array size %d is larger than data len %d", size, len(data))
+	}
+
+	KeyEntrySize := uint64(0)
+	if IsObject {
+		KeyEntrySize = OffsetSize + 2
+	}
+	ValueEntrySize := 1 + OffsetSize
+
+	HeaderSize := 2*OffsetSize + count*(KeyEntrySize+ValueEntrySize)
+	if HeaderSize > size {
+		return fmt.Errorf("json object or array header size %d is larger than size %d", HeaderSize, size)
+	}
+
+	if IsObject {
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte('[')
+	}
+
+	var i uint64
+	for i = 0; i < count; i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+
+		if IsObject {
+			entry := 2*OffsetSize + i*KeyEntrySize
+			KeyOffset := ReadJSONOffset(data[entry:], OffsetSize)
+			KeyLength := uint64(binary.LittleEndian.Uint16(data[entry+OffsetSize:]))
+			if KeyOffset+KeyLength > size {
+				return fmt.Errorf("json object key is out of range")
+			}
+			WriteJSONString(buf, data[KeyOffset:KeyOffset+KeyLength])
+			buf.WriteString(": ")
+		}
+
+		entry := 2*OffsetSize + count*KeyEntrySize + i*ValueEntrySize
+		ValueType := MatchReadFrom1(data[entry:])
+
+		// The literal and the small integer are inlined in the value entry.
+		if JSONValueIsInlined(ValueType, IsLarge) {
+			err := ParseJSONValue(buf, ValueType, data[entry+1:entry+1+OffsetSize])
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		ValueOffset := ReadJSONOffset(data[entry+1:], OffsetSize)
+		if ValueOffset >= size {
+			return fmt.Errorf("json value offset %d is out of range", ValueOffset)
+		}
+		err := ParseJSONValue(buf, ValueType, data[ValueOffset:size])
+		if err != nil {
+			return err
+		}
+	}
+
+	if IsObject {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return nil
+}
+
+func JSONValueIsInlined(ValueType uint64, IsLarge bool) bool {
+	switch ValueType {
+	case JSONB_TYPE_LITERAL, JSONB_TYPE_INT16, JSONB_TYPE_UINT16:
+		return true
+	case JSONB_TYPE_INT32, JSONB_TYPE_UINT32:
+		return IsLarge
+	}
+	return false
+}
+
+func ReadJSONOffset(data []byte, OffsetSize uint64) uint64 {
+	if OffsetSize == 2 {
+		return uint64(binary.LittleEndian.Uint16(data))
+	}
+	return uint64(binary.LittleEndian.Uint32(data))
+}
+
+func ParseJSONLiteral(buf *bytes.Buffer, literal uint64) error {
+	switch literal {
+	case JSONB_NULL_LITERAL:
+		buf.WriteString("null")
+	case JSONB_TRUE_LITERAL:
+		buf.WriteString("true")
+	case JSONB_FALSE_LITERAL:
+		buf.WriteString("false")
+	default:
+		return fmt.Errorf("unknown json literal %d", literal)
+	}
+	return nil
+}
+
+func ParseJSONNumber(buf *bytes.Buffer, JSONType uint64, data []byte) error {
+	var size int
+	switch JSONType {
+	case JSONB_TYPE_INT16, JSONB_TYPE_UINT16:
+		size = 2
+	case JSONB_TYPE_INT32, JSONB_TYPE_UINT32:
+		size = 4
+	default:
+		size = 8
+	}
+	if len(data) < size {
+		return fmt.Errorf("json number data is too short")
+	}
+
+	switch JSONType {
+	case JSONB_TYPE_INT16:
+		buf.WriteString(strconv.FormatInt(int64(ParseBinaryInt16(data)), 10))
+	case JSONB_TYPE_UINT16:
+		buf.WriteString(strconv.FormatUint(uint64(ParseBinaryUint16(data)), 10))
+	case JSONB_TYPE_INT32:
+		buf.WriteString(strconv.FormatInt(int64(ParseBinaryInt32(data)), 10))
+	case JSONB_TYPE_UINT32:
+		buf.WriteString(strconv.FormatUint(uint64(ParseBinaryUint32(data)), 10))
+	case JSONB_TYPE_INT64:
+		buf.WriteString(strconv.FormatInt(ParseBinaryInt64(data), 10))
+	case JSONB_TYPE_UINT64:
+		buf.WriteString(strconv.FormatUint(ParseBinaryUint64(data), 10))
+	case JSONB_TYPE_DOUBLE:
+		buf.WriteString(FormatJSONDouble(ParseBinaryFloat64(data)))
+	}
+	return nil
+}
+
+// MySQL print the double which have no fraction part with ".0",
+// so it can be read back as a double.
+func FormatJSONDouble(d float64) string {
+	if math.IsInf(d, 0) || math.IsNaN(d) {
+		return "null"
+	}
+	s := strconv.FormatFloat(d, 'g', -1, 64)
+	if !bytes.ContainsAny([]byte(s), ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+// The string and opaque data length is stored with variable length,
+// every byte use the low 7 bits, the high bit means more bytes follow.
+func ParseJSONVariableLength(data []byte) (uint64, uint64, error) {
+	var length uint64
+	var i uint64
+	for i = 0; i < 5 && i < uint64(len(data)); i++ {
+		b := uint64(data[i])
+		length |= (b & 0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return length, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("json variable length is invalid")
+}
+
+// The opaque value store the MySQL type which JSON don't have,
+// such as DECIMAL, DATE, TIME, DATETIME, TIMESTAMP and binary string:
+//
+//	opaque ::= mysql-type variable-length binary-data
+func ParseJSONOpaque(buf *bytes.Buffer, data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("json opaque data is too short")
+	}
+	MySQLType := MatchReadFrom1(data)
+
+	length, n, err := ParseJSONVariableLength(data[1:])
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) < 1+n+length {
+		return fmt.Errorf("json opaque data is too short")
+	}
+	value := data[1+n : 1+n+length]
+
+	switch MySQLType {
+	case MYSQL_TYPE_NEWDECIMAL:
+		// The precision and scale are stored before the binary decimal.
+		if len(value) < 2 {
+			return fmt.Errorf("json decimal data is too short")
+		}
+		decimal, err := ParseDecimal(value[2:], int(value[0]), int(value[1]))
+		if err != nil {
+			return err
+		}
+		buf.WriteString(decimal)
+		return nil
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_TIME, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIMESTAMP:
+		if len(value) < 8 {
+			return fmt.Errorf("json temporal data is too short")
+		}
+		buf.WriteByte('"')
+		buf.WriteString(ParsePackedTime(MySQLType, ParseBinaryInt64(value)))
+		buf.WriteByte('"')
+		return nil
+	}
+
+	// Print the other type like MySQL: "base64:type<mysql type>:<base64 data>"
+	buf.WriteString(fmt.Sprintf("\"base64:type%d:%s\"", MySQLType,
+		base64.StdEncoding.EncodeToString(value)))
+	return nil
+}
+
+// Parse the packed temporal value which used by the JSON opaque value.
+// Reference mysql-5.7.19/sql-common/my_time.c TIME_from_longlong_packed
+func ParsePackedTime(MySQLType uint64, packed int64) string {
+	negative := packed < 0
+	if negative {
+		packed = -packed
+	}
+
+	IntPart := packed >> 24
+	frac := packed % (1 << 24)
+
+	if MySQLType == MYSQL_TYPE_TIME {
+		hour := (IntPart >> 12) % (1 << 10)
+		min := (IntPart >> 6) % (1 << 6)
+		sec := IntPart % (1 << 6)
+		s := fmt.Sprintf("%02d:%02d:%02d.%06d", hour, min, sec, frac)
+		if negative {
+			s = "-" + s
+		}
+		return s
+	}
+
+	ymd := IntPart >> 17
+	ym := ymd >> 5
+	hms := IntPart % (1 << 17)
+
+	year := ym / 13
+	month := ym % 13
+	day := ymd % (1 << 5)
+
+	if MySQLType == MYSQL_TYPE_DATE {
+		return fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	}
+
+	hour := hms >> 12
+	min := (hms >> 6) % (1 << 6)
+	sec := hms % (1 << 6)
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d.%06d", year, month, day, hour, min, sec, frac)
+}
+
+// Write the JSON string with quote, only escape the characters which
+// JSON require, the same as MySQL.
+func WriteJSONString(buf *bytes.Buffer, s []byte) {
+	buf.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(fmt.Sprintf(`\u%04x`, c))
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// Make the JSON text to the MySQL binary JSON, it is the reverse of the
+// ParseJSON. The object and array always use the large format, the number
+// is stored as INT64, UINT64 or DOUBLE, and the object keys are sorted by
+// the length and then the bytes like MySQL, the last duplicate key win.
+// Reference mysql-5.7.19/sql/json_binary.cc serialize_json_value
+func MakeJSON(text string) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader([]byte(text)))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, fmt.Errorf("json text have the data after the value")
+	}
+
+	var buf bytes.Buffer
+	JSONType, err := MakeJSONValue(&buf, v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(JSONType)}, buf.Bytes()...), nil
+}
+
+// Write the value without the type, and return the type.
+func MakeJSONValue(buf *bytes.Buffer, v interface{}) (uint64, error) {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(byte(JSONB_NULL_LITERAL))
+		return JSONB_TYPE_LITERAL, nil
+
+	case bool:
+		if value {
+			buf.WriteByte(byte(JSONB_TRUE_LITERAL))
+		} else {
+			buf.WriteByte(byte(JSONB_FALSE_LITERAL))
+		}
+		return JSONB_TYPE_LITERAL, nil
+
+	case json.Number:
+		if i, err := strconv.ParseInt(value.String(), 10, 64); err == nil {
+			binary.Write(buf, binary.LittleEndian, i)
+			return JSONB_TYPE_INT64, nil
+		}
+		if u, err := strconv.ParseUint(value.String(), 10, 64); err == nil {
+			binary.Write(buf, binary.LittleEndian, u)
+			return JSONB_TYPE_UINT64, nil
+		}
+		f, err := strconv.ParseFloat(value.String(), 64)
+		if err != nil {
+			return 0, err
+		}
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(f))
+		return JSONB_TYPE_DOUBLE, nil
+
+	case string:
+		WriteJSONVariableLength(buf, uint64(len(value)))
+		buf.WriteString(value)
+		return JSONB_TYPE_STRING, nil
+
+	case []interface{}:
+		return JSONB_TYPE_LARGE_ARRAY, MakeJSONObjectOrArray(buf, nil, value)
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		values := make([]interface{}, 0, len(keys))
+		for _, k := range keys {
+			values = append(values, value[k])
+		}
+		return JSONB_TYPE_LARGE_OBJECT, MakeJSONObjectOrArray(buf, keys, values)
+	}
+	return 0, fmt.Errorf("unknown json value type %T", v)
+}
+
+// Write the large object or array, the keys is nil for the array:
+//
+//	large-object ::= element-count size key-entry* value-entry* key* value*
+//	large-array ::= element-count size value-entry* value*
+func MakeJSONObjectOrArray(buf *bytes.Buffer, keys []string, values []interface{}) error {
+	count := uint32(len(values))
+	HeaderSize := 8 + uint32(len(keys))*6 + count*5
+
+	var data bytes.Buffer
+	entries := make([]byte, 0, HeaderSize-8)
+	for _, k := range keys {
+		if len(k) > math.MaxUint16 {
+			return fmt.Errorf("json object key is too long")
+		}
+		entries = append(entries, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(entries[len(entries)-6:], HeaderSize+uint32(data.Len()))
+		binary.LittleEndian.PutUint16(entries[len(entries)-2:], uint16(len(k)))
+		data.WriteString(k)
+	}
+
+	for _, v := range values {
+		var value bytes.Buffer
+		ValueType, err := MakeJSONValue(&value, v)
+		if err != nil {
+			return err
+		}
+
+		// The literal is inlined in the value entry.
+		entries = append(entries, byte(ValueType), 0, 0, 0, 0)
+		if ValueType == JSONB_TYPE_LITERAL {
+			entries[len(entries)-4] = value.Bytes()[0]
+			continue
+		}
+		binary.LittleEndian.PutUint32(entries[len(entries)-4:], HeaderSize+uint32(data.Len()))
+		data.Write(value.Bytes())
+	}
+
+	binary.Write(buf, binary.LittleEndian, count)
+	binary.Write(buf, binary.LittleEndian, HeaderSize+uint32(data.Len()))
+	buf.Write(entries)
+	buf.Write(data.Bytes())
+	return nil
+}
+
+// Write the variable length, 7 bits per byte, the high bit mark more bytes.
+func WriteJSONVariableLength(buf *bytes.Buffer, length uint64) {
+	for {
+		b := byte(length & 0x7F)
+		length >>= 7
+		if length == 0 {
+			buf.WriteByte(b)
+			return
+		}
+		buf.WriteByte(b | 0x80)
+	}
+}
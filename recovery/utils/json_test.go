@@ -0,0 +1,69 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The binary JSON made by MySQL use the small format.
+func TestParseJSON(t *testing.T) {
+	for _, c := range []struct {
+		stored string
+		parsed string
+	}{
+		// [1]
+		{"0201000700050100", "[1]"},
+		// {"a": "b"}
+		{"0001000E000B0001000C0C00610162", `{"a": "b"}`},
+		{"", "null"},
+	} {
+		data, _ := hex.DecodeString(c.stored)
+		parsed, err := ParseJSON(data)
+		if err != nil || parsed != c.parsed {
+			t.Errorf("json %s is parsed to %s, expected %s, the error is %v", c.stored, parsed, c.parsed, err)
+		}
+	}
+}
+
+func TestMakeJSON(t *testing.T) {
+	for _, c := range []struct {
+		text   string
+		parsed string
+	}{
+		{`{"b": {"c": -3}, "a": [1, 2.5, "x", true, null]}`, `{"a": [1, 2.5, "x", true, null], "b": {"c": -3}}`},
+		{`{"bb": 1, "a": 2, "a": 3}`, `{"a": 3, "bb": 1}`},
+		{`[18446744073709551615, -9223372036854775808]`, `[18446744073709551615, -9223372036854775808]`},
+		{`"a\"b\n"`, `"a\"b\n"`},
+		{`[]`, `[]`},
+		{`{}`, `{}`},
+		{`false`, `false`},
+	} {
+		stored, err := MakeJSON(c.text)
+		if err != nil {
+			t.Errorf("make json %s failed, the error is %v", c.text, err)
+			continue
+		}
+		parsed, err := ParseJSON(stored)
+		if err != nil || parsed != c.parsed {
+			t.Errorf("json %s is parsed to %s, expected %s, the error is %v", c.text, parsed, c.parsed, err)
+		}
+	}
+
+	if _, err := MakeJSON(`{"a": 1} 2`); err == nil {
+		t.Error("the json text have the data after the value is not rejected")
+	}
+}
@@ -99,12 +99,13 @@ func GetUintValue(FixLength int, value []byte) uint64 {
 		return MatchReadFrom3(value) & 0x3FFFFF
 	case 4:
 		return MatchReadFrom4(value)
-	case 5:
-		return MatchReadFrom8(value) >> 24
-	case 6:
-		return MatchReadFrom8(value) >> 16
-	case 7:
-		return MatchReadFrom8(value) >> 8
+	case 5, 6, 7:
+		// The value may be the field slice, don't read past it.
+		var v uint64
+		for _, b := range value[:FixLength] {
+			v = v<<8 | uint64(b)
+		}
+		return v
 	case 8:
 		return MatchReadFrom8(value)
 	}
@@ -113,15 +114,26 @@ func GetUintValue(FixLength int, value []byte) uint64 {
 
 // Reference MySQL rec_offs_nth_size method.
 func RecOffsNthSize(offsets *[]uint64, n int) uint64 {
+	// MySQL 8.0 add the REC_OFFS_DEFAULT and REC_OFFS_DROP flags.
+	// #define REC_OFFS_MASK (REC_OFFS_DROP - 1)
+	// #define REC_OFFS_DROP ((ulint)1 << 28)
 	if n == 0 {
 		// REC_OFFS_MASK
-		return (*offsets)[2:][1+n] & ((1 << 30) - 1)
+		return (*offsets)[2:][1+n] & ((1 << 28) - 1)
 	} else {
 		// REC_OFFS_MASK
-		return ((*offsets)[2:][1+n] - (*offsets)[2:][n]) & ((1 << 30) - 1)
+		return (((*offsets)[2:][1+n] & ((1 << 28) - 1)) - ((*offsets)[2:][n] & ((1 << 28) - 1))) & ((1 << 28) - 1)
 	}
 }
 
+// Reference MySQL 8.0 rec_offs_nth_default method, the field is not stored
+// in the record, it was added by instant add column after the record inserted,
+// or it was dropped by instant drop column before the record inserted.
+func RecOffsNthDefault(offsets []uint64, n int) bool {
+	// #define REC_OFFS_DEFAULT ((ulint)1 << 29)
+	return offsets[2:][1+n]&(1<<29) != 0
+}
+
 // Reference MySQL rec_offs_size method.
 func RecOffsSize(offsets *[]uint64) uint64 {
 	return RecOffsDataSize(offsets) + RecOffsExtraSize(offsets)
@@ -135,7 +147,8 @@ func RecOffsExtraSize(offsets *[]uint64) uint64 {
 
 // Reference MySQL rec_offs_data_size method.
 func RecOffsDataSize(offsets *[]uint64) uint64 {
-	return (*offsets)[2:][RecOffNFields(offsets)]
+	// REC_OFFS_MASK
+	return (*offsets)[2:][RecOffNFields(offsets)] & ((1 << 28) - 1)
 }
 
 // Reference MySQL rec_offs_n_fields method.
@@ -181,16 +194,16 @@ func RecGetNthFieldOffs(offsets []uint64, n int, len *uint64) uint64 {
 		offs = 0
 	} else {
 		// #define rec_offs_base(offsets) (offsets + REC_OFFS_HEADER_SIZE)
-		// #define REC_OFFS_MASK		(REC_OFFS_EXTERNAL - 1)
-		// #define REC_OFFS_EXTERNAL	((ulint) 1 << 30)
-		offs = (offsets[2:][n]) & ((1 << 30) - 1)
+		// #define REC_OFFS_MASK		(REC_OFFS_DROP - 1)
+		// #define REC_OFFS_DROP	((ulint) 1 << 28)
+		offs = (offsets[2:][n]) & ((1 << 28) - 1)
 	}
 
 	length = offsets[2:][n+1]
 
 	// #define REC_OFFS_SQL_NULL	((ulint) 1 << 31)
 	if (length & (1 << 31)) == 0 {
-		length &= (1 << 30) - 1
+		length &= (1 << 28) - 1
 		length -= offs
 	} else {
 		length = 0xFFFFFFFF
@@ -555,8 +568,10 @@ func ParseData(DataType uint64, MySQLType uint64,
 		case MYSQL_TYPE_BIT:
 			return GetUintValue(FixLength, data[:FieldLen]), nil
 		case MYSQL_TYPE_NEWDECIMAL:
-			// TODO: support it.
-			fmt.Println("test MYSQL_TYPE_NEWDECIMAL")
+			// The precision and scale are unknown here, output it as hex,
+			// it is decoded when the table struct provide them.
+			*IsBinary = true
+			return ParseBlob(data[:FieldLen]), nil
 		case MYSQL_TYPE_STRING:
 			*IsBinary = true
 			return ParseBlob(data[:FieldLen]), nil
@@ -602,7 +617,29 @@ func ParseData(DataType uint64, MySQLType uint64,
 		return string(data[:FieldLen]), nil
 	case DATA_MYSQL:
 		return strings.TrimSpace(string(data[:FieldLen])), nil
+	case DATA_SYS:
+		// The internal columns DB_ROW_ID, DB_TRX_ID and DB_ROLL_PTR.
+		return GetUintValue(FixLength, data[:FieldLen]), nil
+	case DATA_GEOMETRY, DATA_VAR_POINT, DATA_POINT:
+		return ParseGeometryData(data[:FieldLen], IsBinary), nil
 	case DATA_BLOB:
+		// MySQL 5.6 store the geometry as blob.
+		if MySQLType == MYSQL_TYPE_GEOMETRY {
+			return ParseGeometryData(data[:FieldLen], IsBinary), nil
+		}
+
+		// The JSON column is stored as binary JSON format, decode it to text.
+		// If decode failed, such as the value is stored off page, print it as hex.
+		if MySQLType == MYSQL_TYPE_JSON {
+			FormatJSON, err := ParseJSON(data[:FieldLen])
+			if err == nil {
+				*IsBinary = false
+				return FormatJSON, nil
+			}
+			logs.Warn("parse json value failed, print it as hex, the error is ", err)
+			*IsBinary = true
+		}
+
 		// TODO: deal with binary data.
 		if *IsBinary {
 			FormatBlobToHex := ParseBlob(data[:FieldLen])
@@ -615,6 +652,18 @@ func ParseData(DataType uint64, MySQLType uint64,
 	return nil, nil
 }
 
+// Parse the geometry value, if parse failed, print it as hex.
+func ParseGeometryData(data []byte, IsBinary *bool) interface{} {
+	g, err := ParseGeometry(data)
+	if err != nil {
+		logs.Warn("parse geometry value failed, print it as hex, the error is ", err)
+		*IsBinary = true
+		return ParseBlob(data)
+	}
+	*IsBinary = false
+	return g
+}
+
 func GetFixedLengthByMySQLType(MySQLType uint64, FieldLen uint64) uint64 {
 
 	switch MySQLType {
@@ -665,7 +714,7 @@ func GetFixedLength(DataType uint64, FieldLen uint64) uint64 {
 	//return FieldLen / 3
 	//case DATA_FIXBINARY:
 	//case DATA_BINARY:
-	case DATA_BLOB:
+	case DATA_BLOB, DATA_GEOMETRY, DATA_VAR_POINT:
 		return 0
 	//case DATA_INT:
 	//	//return GetUintValue(FixLength, data), nil
@@ -758,7 +807,7 @@ func ParseDateTime(data []byte) string {
 		ldate /= 100
 		year := ldate % 10000
 
-		FormatTime := fmt.Sprintf("%02d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, min, sec)
+		FormatTime := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, min, sec)
 
 		return FormatTime
 
@@ -772,7 +821,7 @@ func ParseDateTime(data []byte) string {
 		min := (ldate & 0x0000000FC0000000) >> 30
 		sec := (ldate & 0x000000003F000000) >> 24
 
-		FormatTime := fmt.Sprintf("%02d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, min, sec)
+		FormatTime := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, min, sec)
 
 		return FormatTime
 	}
@@ -791,7 +840,7 @@ func ParseDate(data []byte) string {
 	month = int(ldate % 16)
 	ldate /= 16
 	year = int(ldate)
-	DateTime := fmt.Sprintf("%02d-%02d-%02d", year, month, day)
+	DateTime := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
 	return DateTime
 }
 
@@ -835,7 +884,12 @@ func ParseTime(data []byte) string {
 func ParseTimeStamp(data []byte) string {
 	// TODO: add time_precision
 	t := MatchReadFrom4(data)
-	tm := time.Unix(int64(t), 0).Format("2006-01-02 03:04:05")
+
+	// The zero timestamp is stored as 0.
+	if t == 0 {
+		return "0000-00-00 00:00:00"
+	}
+	tm := time.Unix(int64(t), 0).Format("2006-01-02 15:04:05")
 	return tm
 }
 
@@ -887,11 +941,6 @@ func EscapeValue(colValue string) string {
 		last = i + 1
 	}
 	colBuffer.WriteString(colValue[last:])
-	// TODO: If the source is really 0001-01-01T00:00:00Z, it will cause data inconsistency.
-	if colBuffer.String() == "0001-01-01T00:00:00Z" {
-		colBuffer.Reset()
-		colBuffer.WriteString("")
-	}
 	return colBuffer.String()
 }
 
@@ -931,3 +980,182 @@ func FixedLengthInt(buf []byte) uint64 {
 	}
 	return num
 }
+
+// The ENUM value is the index of the label, start from 1,
+// 0 means the empty string which is inserted for the invalid value.
+// Return false when the index is out of the labels.
+func ParseEnum(value uint64, elements []string) (string, bool) {
+	if value == 0 {
+		return "", true
+	}
+	if value > uint64(len(elements)) {
+		return "", false
+	}
+	return elements[value-1], true
+}
+
+// The SET value is a bitmap, the nth bit means the nth label is set.
+// Return false when the bits out of the labels are set.
+func ParseSet(value uint64, elements []string) (string, bool) {
+	var labels []string
+	for i, e := range elements {
+		if i >= 64 {
+			break
+		}
+		if value&(1<<uint(i)) != 0 {
+			labels = append(labels, e)
+		}
+	}
+	if len(elements) < 64 && value>>uint(len(elements)) != 0 {
+		return "", false
+	}
+	return strings.Join(labels, ","), true
+}
+
+// The bytes which needed to store the decimal digits, 9 digits use 4 bytes.
+var DigitsToBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// Parse the MySQL binary decimal, the integer part and fraction part are
+// stored separately, every 9 digits use 4 bytes and the leftover digits
+// use the DigitsToBytes bytes, all big endian. The sign bit is the highest
+// bit of the first byte, and the negative value have all bits inverted.
+// Reference mysql-5.7.19/strings/decimal.c bin2decimal
+func ParseDecimal(data []byte, precision int, scale int) (string, error) {
+	intg := precision - scale
+	intg0 := intg / 9
+	intg0x := intg - intg0*9
+	frac0 := scale / 9
+	frac0x := scale - frac0*9
+
+	size := intg0*4 + DigitsToBytes[intg0x] + frac0*4 + DigitsToBytes[frac0x]
+	if precision <= 0 || scale < 0 || scale > precision || len(data) < size {
+		return "", fmt.Errorf("decimal data is invalid, precision is %d scale is %d", precision, scale)
+	}
+
+	d := make([]byte, size)
+	copy(d, data[:size])
+
+	var mask byte
+	negative := d[0]&0x80 == 0
+	if negative {
+		mask = 0xFF
+	}
+	d[0] ^= 0x80
+
+	pos := 0
+	read := func(n int) uint64 {
+		var v uint64
+		for i := 0; i < n; i++ {
+			v = v<<8 | uint64(d[pos+i]^mask)
+		}
+		pos += n
+		return v
+	}
+
+	var buf bytes.Buffer
+	if negative {
+		buf.WriteByte('-')
+	}
+
+	var IntPart string
+	if intg0x > 0 {
+		IntPart = strconv.FormatUint(read(DigitsToBytes[intg0x]), 10)
+	}
+	for i := 0; i < intg0; i++ {
+		v := read(4)
+		if IntPart == "" || IntPart == "0" {
+			IntPart = strconv.FormatUint(v, 10)
+		} else {
+			IntPart += fmt.Sprintf("%09d", v)
+		}
+	}
+	IntPart = strings.TrimLeft(IntPart, "0")
+	if IntPart == "" {
+		IntPart = "0"
+	}
+	buf.WriteString(IntPart)
+
+	if scale > 0 {
+		buf.WriteByte('.')
+		for i := 0; i < frac0; i++ {
+			buf.WriteString(fmt.Sprintf("%09d", read(4)))
+		}
+		if frac0x > 0 {
+			buf.WriteString(fmt.Sprintf("%0*d", frac0x, read(DigitsToBytes[frac0x])))
+		}
+	}
+
+	// Don't print the negative zero.
+	s := buf.String()
+	if negative && strings.Trim(s, "-0.") == "" {
+		s = s[1:]
+	}
+	return s, nil
+}
+
+// Make the decimal string to the MySQL binary decimal, it is the reverse
+// of the ParseDecimal, the fraction digits more than the scale are cut.
+// Reference mysql-5.7.19/strings/decimal.c decimal2bin
+func MakeDecimal(value string, precision int, scale int) ([]byte, error) {
+	intg := precision - scale
+	intg0 := intg / 9
+	intg0x := intg - intg0*9
+	frac0 := scale / 9
+	frac0x := scale - frac0*9
+	if precision <= 0 || scale < 0 || scale > precision {
+		return nil, fmt.Errorf("decimal precision %d scale %d is invalid", precision, scale)
+	}
+
+	s := strings.TrimSpace(value)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	IntPart, FracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		IntPart, FracPart = s[:i], s[i+1:]
+	}
+	for _, c := range IntPart + FracPart {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("decimal value %s is invalid", value)
+		}
+	}
+
+	IntPart = strings.TrimLeft(IntPart, "0")
+	if len(IntPart) > intg {
+		return nil, fmt.Errorf("decimal value %s is out of range, precision is %d scale is %d",
+			value, precision, scale)
+	}
+	IntPart = strings.Repeat("0", intg-len(IntPart)) + IntPart
+	if len(FracPart) > scale {
+		FracPart = FracPart[:scale]
+	}
+	FracPart += strings.Repeat("0", scale-len(FracPart))
+
+	var d []byte
+	write := func(digits string, n int) {
+		v, _ := strconv.ParseUint(digits, 10, 64)
+		for i := n - 1; i >= 0; i-- {
+			d = append(d, byte(v>>(uint(i)*8)))
+		}
+	}
+	if intg0x > 0 {
+		write(IntPart[:intg0x], DigitsToBytes[intg0x])
+	}
+	for i := 0; i < intg0; i++ {
+		write(IntPart[intg0x+i*9:intg0x+i*9+9], 4)
+	}
+	for i := 0; i < frac0; i++ {
+		write(FracPart[i*9:i*9+9], 4)
+	}
+	if frac0x > 0 {
+		write(FracPart[frac0*9:], DigitsToBytes[frac0x])
+	}
+
+	// The negative zero is stored as zero.
+	if negative && strings.Trim(IntPart+FracPart, "0") != "" {
+		for i := range d {
+			d[i] = ^d[i]
+		}
+	}
+	d[0] ^= 0x80
+	return d, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 The zbdba Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecimal(t *testing.T) {
+	for _, c := range []struct {
+		value     string
+		precision int
+		scale     int
+		stored    string
+		parsed    string
+	}{
+		// The example of mysql-5.7.19/strings/decimal.c decimal2bin.
+		{"1234567890.1234", 14, 4, "810DFB38D204D2", "1234567890.1234"},
+		{"-1234567890.1234", 14, 4, "7EF204C72DFB2D", "-1234567890.1234"},
+		{"0", 10, 2, "8000000000", "0.00"},
+		{"-0.00", 10, 2, "8000000000", "0.00"},
+		{"12.5", 5, 0, "80000C", "12"},
+		{"-0.000000001", 20, 10, "7FFFFFFFFFFFFFFFFEFF", "-0.0000000010"},
+		{"123456789012345678", 18, 0, "875BCD1500BC614E", "123456789012345678"},
+	} {
+		stored, err := MakeDecimal(c.value, c.precision, c.scale)
+		if err != nil {
+			t.Errorf("make decimal %s failed, the error is %v", c.value, err)
+			continue
+		}
+		if fmt.Sprintf("%X", stored) != c.stored {
+			t.Errorf("decimal %s is stored as %X, expected %s", c.value, stored, c.stored)
+		}
+
+		parsed, err := ParseDecimal(stored, c.precision, c.scale)
+		if err != nil || parsed != c.parsed {
+			t.Errorf("decimal %X is parsed to %s, expected %s, the error is %v", stored, parsed, c.parsed, err)
+		}
+	}
+
+	if _, err := MakeDecimal("123456", 5, 2); err == nil {
+		t.Error("the decimal out of range is not rejected")
+	}
+}